@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// nextClientID hands out connection ids, starting at 1 like Redis.
+var nextClientID atomic.Int64
+
+func NewClientConn(conn net.Conn, writer *RESPreader) *ClientConn {
+	now := time.Now()
+	return &ClientConn{
+		conn:          conn,
+		writer:        writer,
+		id:            nextClientID.Add(1),
+		createdAt:     now,
+		lastActive:    now,
+		subscriptions: make(map[string]struct{}),
+		db:            server.databases[0],
+	}
+}
+
+// watchClose reports when the peer closes the connection while a command
+// blocks, since the read loop isn't running to notice. The returned stop
+// function must be called before the connection is read again.
+func (c *ClientConn) watchClose() (<-chan struct{}, func()) {
+	closed := make(chan struct{})
+	if c.conn == nil || c.writer == nil {
+		return closed, func() {}
+	}
+
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		// Pipelined commands stay buffered for the read loop; only an
+		// error other than the deadline set by stop means the peer is gone
+		if _, err := c.writer.reader.Peek(1); err != nil {
+			if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+				close(closed)
+			}
+		}
+	}()
+
+	return closed, func() {
+		c.conn.SetReadDeadline(time.Now())
+		<-exited
+		c.conn.SetReadDeadline(time.Time{})
+	}
+}
+
+// allowCommand takes a token from the connection's bucket, which refills at
+// limit tokens per second and holds at most limit. A limit of 0 disables
+// rate limiting.
+func (c *ClientConn) allowCommand(limit int64) bool {
+	if limit <= 0 {
+		return true
+	}
+
+	now := time.Now()
+	if c.rateRefill.IsZero() {
+		c.rateTokens = float64(limit)
+	} else {
+		c.rateTokens += now.Sub(c.rateRefill).Seconds() * float64(limit)
+		c.rateTokens = min(c.rateTokens, float64(limit))
+	}
+	c.rateRefill = now
+
+	if c.rateTokens < 1 {
+		return false
+	}
+	c.rateTokens--
+	return true
+}
+
+// clientStatus is the part of a client's state shown by CLIENT INFO and
+// CLIENT LIST that changes as it runs commands.
+type clientStatus struct {
+	name       string
+	lastActive time.Time
+	lastCmd    string
+	inMulti    bool
+	queued     int
+	subs       int
+	db         int
+}
+
+// currentStatus reads the status from the connection's own fields. Only the
+// connection's goroutine may call it.
+func (c *ClientConn) currentStatus() clientStatus {
+	return clientStatus{
+		name:       c.name,
+		lastActive: c.lastActive,
+		lastCmd:    c.lastCmd,
+		inMulti:    c.isTransaction,
+		queued:     len(c.transactionQueue),
+		subs:       len(c.subscriptions),
+		db:         c.db.id,
+	}
+}
+
+// publishStatus makes the current status visible to CLIENT LIST on other
+// connections. It is called after every command.
+func (c *ClientConn) publishStatus() {
+	status := c.currentStatus()
+	c.statusMu.Lock()
+	c.status = status
+	c.statusMu.Unlock()
+}
+
+func (c *ClientConn) publishedStatus() clientStatus {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+	return c.status
+}
+
+// info formats the connection the way CLIENT INFO and CLIENT LIST do.
+func (c *ClientConn) info(status clientStatus) string {
+	now := time.Now()
+	flags := ""
+	multi := -1
+	if status.inMulti {
+		flags += "x"
+		multi = status.queued
+	}
+	if status.subs > 0 {
+		flags += "P"
+	}
+	if flags == "" {
+		flags = "N"
+	}
+	return fmt.Sprintf("id=%d addr=%s laddr=%s name=%s age=%d idle=%d flags=%s db=%d sub=%d psub=0 multi=%d cmd=%s",
+		c.id, c.conn.RemoteAddr(), c.conn.LocalAddr(), status.name,
+		int64(now.Sub(c.createdAt).Seconds()), int64(now.Sub(status.lastActive).Seconds()),
+		flags, status.db, status.subs, multi, status.lastCmd)
+}
+
+// clients registers every open connection for CLIENT LIST.
+var clients = struct {
+	sync.Mutex
+	m map[int64]*ClientConn
+}{m: make(map[int64]*ClientConn)}
+
+func registerClient(c *ClientConn) {
+	c.publishStatus()
+	clients.Lock()
+	clients.m[c.id] = c
+	clients.Unlock()
+}
+
+func unregisterClient(c *ClientConn) {
+	clients.Lock()
+	delete(clients.m, c.id)
+	clients.Unlock()
+}
+
+// clientList renders one CLIENT LIST line per connection, ordered by id.
+// The caller's own line reflects the command being run.
+func clientList(self *ClientConn) string {
+	clients.Lock()
+	conns := make([]*ClientConn, 0, len(clients.m))
+	for _, c := range clients.m {
+		conns = append(conns, c)
+	}
+	clients.Unlock()
+	sort.Slice(conns, func(i, j int) bool { return conns[i].id < conns[j].id })
+
+	var sb strings.Builder
+	for _, c := range conns {
+		status := c.publishedStatus()
+		if c == self {
+			status = c.currentStatus()
+		}
+		sb.WriteString(c.info(status))
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+func handleClientCommand(cmd Command, clientConn *ClientConn) RespData {
+	if len(cmd.args) < 1 {
+		return RespData{Type: Error, Str: "ERR wrong number of arguments for 'client' command"}
+	}
+
+	switch strings.ToLower(cmd.args[0]) {
+	case "id":
+		return RespData{Type: Integer, Num: clientConn.id}
+	case "info":
+		return RespData{Type: BulkString, Str: clientConn.info(clientConn.currentStatus()) + "\n"}
+	case "list":
+		if len(cmd.args) != 1 {
+			return RespData{Type: Error, Str: "ERR syntax error"}
+		}
+		return RespData{Type: BulkString, Str: clientList(clientConn)}
+	case "getname":
+		if clientConn.name == "" {
+			return RespData{Type: BulkString, IsNull: true}
+		}
+		return RespData{Type: BulkString, Str: clientConn.name}
+	case "setname":
+		if len(cmd.args) != 2 {
+			return RespData{Type: Error, Str: "ERR wrong number of arguments for 'client|setname' command"}
+		}
+		for _, c := range cmd.args[1] {
+			if c <= ' ' || c > '~' {
+				return RespData{Type: Error, Str: "ERR Client names cannot contain spaces, newlines or special characters."}
+			}
+		}
+		clientConn.name = cmd.args[1]
+		return RespData{Type: SimpleString, Str: "OK"}
+	default:
+		return RespData{Type: Error, Str: "ERR unknown subcommand '" + cmd.args[0] + "'. Try CLIENT HELP."}
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+)
+
+// infoHas reports whether a CLIENT INFO or CLIENT LIST line contains each
+// of the given fields.
+func infoHas(t *testing.T, line string, fields ...string) {
+	t.Helper()
+	have := strings.Fields(line)
+	for _, field := range fields {
+		found := false
+		for _, f := range have {
+			if f == field {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("%q has no %s", line, field)
+		}
+	}
+}
+
+func TestClientInfo(t *testing.T) {
+	c := newTestClient(t)
+	expectReply(t, c, nullReply(), "CLIENT", "GETNAME")
+	expectReply(t, c, okReply(), "CLIENT", "SETNAME", "worker")
+	expectReply(t, c, bulkReply("worker"), "CLIENT", "GETNAME")
+	expectReply(t, c, errorReply("ERR Client names cannot contain spaces, newlines or special characters."),
+		"CLIENT", "SETNAME", "two words")
+	expectReply(t, c, intReply(c.id), "CLIENT", "ID")
+
+	run(c, "SELECT", "3")
+	infoHas(t, run(c, "CLIENT", "INFO").Str, "name=worker", "flags=N", "db=3", "multi=-1")
+
+	run(c, "MULTI")
+	run(c, "SET", "k", "v")
+	run(c, "CLIENT", "INFO")
+	got := run(c, "EXEC")
+	if len(got.Array) != 2 {
+		t.Fatalf("EXEC: got %+v", got)
+	}
+	infoHas(t, got.Array[1].Str, "name=worker", "flags=x", "multi=2")
+}
+
+func TestCommandsPerSecond(t *testing.T) {
+	conn, r := dialTestServer(t)
+	other, otherR := dialTestConn(t)
+	if _, err := io.WriteString(conn, "CONFIG SET commands-per-second 5\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	expectLine(t, r, "+OK\r\n")
+
+	if _, err := io.WriteString(conn, strings.Repeat("PING\r\n", 20)); err != nil {
+		t.Fatal(err)
+	}
+	rejected := 0
+	for range 20 {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch line {
+		case "+PONG\r\n":
+		case "-ERR rate limit exceeded\r\n":
+			rejected++
+		default:
+			t.Fatalf("unexpected reply %q", line)
+		}
+	}
+	if rejected < 10 {
+		t.Errorf("%d of 20 commands rejected, want at least 10", rejected)
+	}
+
+	// Each connection has its own bucket
+	if _, err := io.WriteString(other, strings.Repeat("PING\r\n", 5)); err != nil {
+		t.Fatal(err)
+	}
+	for range 5 {
+		expectLine(t, otherR, "+PONG\r\n")
+	}
+}
+
+// clientLine returns the CLIENT LIST line of the client with the given id.
+func clientLine(t *testing.T, list, id string) string {
+	t.Helper()
+	for _, line := range strings.Split(strings.TrimSpace(list), "\n") {
+		if strings.HasPrefix(line, "id="+id+" ") {
+			return line
+		}
+	}
+	t.Fatalf("CLIENT LIST has no client %s:\n%s", id, list)
+	return ""
+}
+
+func TestClientListSubscriptionsAndMulti(t *testing.T) {
+	sub, subR := dialTestServer(t)
+	multi, multiR := dialTestConn(t)
+	ids := make([]string, 2)
+	for i, conn := range []struct {
+		w io.Writer
+		r *bufio.Reader
+	}{{sub, subR}, {multi, multiR}} {
+		io.WriteString(conn.w, "CLIENT ID\r\n")
+		line, err := conn.r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = strings.TrimSpace(strings.TrimPrefix(line, ":"))
+	}
+
+	io.WriteString(sub, respCommand("SUBSCRIBE", "a", "b"))
+	expectLines(t, subR, "*3", "$9", "subscribe", "$1", "a", ":1")
+	expectLines(t, subR, "*3", "$9", "subscribe", "$1", "b", ":2")
+	io.WriteString(multi, "MULTI\r\nSET k v\r\nINCR n\r\nGET k\r\n")
+	for range 4 {
+		if _, err := multiR.ReadString('\n'); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Each connection publishes its status just after replying
+	c := connectTestClient(t)
+	var list string
+	waitFor(t, "CLIENT LIST to show the last commands", func() bool {
+		list = run(c, "CLIENT", "LIST").Str
+		return strings.Contains(clientLine(t, list, ids[0]), "cmd=subscribe") &&
+			strings.Contains(clientLine(t, list, ids[1]), "cmd=get")
+	})
+	infoHas(t, clientLine(t, list, ids[0]), "sub=2", "psub=0", "multi=-1", "flags=P")
+	infoHas(t, clientLine(t, list, ids[1]), "sub=0", "multi=3", "flags=x")
+}
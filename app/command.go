@@ -1,10 +1,16 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"net"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 type Command struct {
@@ -13,8 +19,157 @@ type Command struct {
 }
 type ClientConn struct {
 	conn             net.Conn
+	writer           *RESPreader
 	transactionQueue []Command
 	isTransaction    bool
+	queueError       bool // a command failed to queue; EXEC must abort
+
+	id         int64
+	name       string // set by CLIENT SETNAME
+	createdAt  time.Time
+	lastActive time.Time
+	lastCmd    string
+
+	subscriptions map[string]struct{} // channels this client is subscribed to
+
+	db *DataBase // the database chosen with SELECT
+
+	// Token bucket for the commands-per-second limit
+	rateTokens float64
+	rateRefill time.Time
+
+	// status is a copy of the fields above for CLIENT LIST on other
+	// connections, refreshed after each command
+	statusMu sync.Mutex
+	status   clientStatus
+}
+
+// commandSpec describes a command for validation before it is queued.
+// arity follows the Redis convention: a positive value is the exact number
+// of arguments including the command name, a negative value is the minimum.
+// keys describes which arguments are keys, for COMMAND GETKEYS. write marks
+// commands that can modify the keyspace and are passed to propagate; noop,
+// if set, recognises the replies of a write that changed nothing, which
+// aren't propagated.
+type commandSpec struct {
+	arity int
+	keys  []keySpec
+	write bool
+	noop  func(cmd Command, reply RespData) bool
+}
+
+var commandTable = map[string]commandSpec{
+	"multi":   {arity: 1},
+	"exec":    {arity: 1},
+	"discard": {arity: 1},
+	"ping":    {arity: -1},
+	"echo":    {arity: 2},
+	"select":  {arity: 2},
+	"swapdb":  {arity: 3, write: true},
+	"set":     {arity: -3, write: true, noop: setNoop, keys: oneKey(keyOverwrite)},
+	"delete":  {arity: -2, write: true, noop: zeroReplyNoop, keys: []keySpec{{first: 1, last: -1, step: 1, flags: keyDelete}}},
+	"exists":  {arity: -2, keys: []keySpec{{first: 1, last: -1, step: 1, flags: keyReadOnly}}},
+	"rename":  {arity: 3, write: true, keys: []keySpec{{first: 1, last: 1, step: 1, flags: keyPop}, {first: 2, last: 2, step: 1, flags: keyOverwrite}}},
+	"copy":    {arity: -3, write: true, noop: zeroReplyNoop, keys: []keySpec{{first: 1, last: 1, step: 1, flags: keyRead}, {first: 2, last: 2, step: 1, flags: keyOverwrite}}},
+	"get":     {arity: 2, keys: oneKey(keyRead)},
+	"mget":    {arity: -2, keys: []keySpec{{first: 1, last: -1, step: 1, flags: keyRead}}},
+	"getdel":  {arity: 2, write: true, noop: nullReplyNoop, keys: oneKey(keyPop)},
+	"append":  {arity: 3, write: true, keys: oneKey(keyInsert)},
+	"getex":   {arity: -2, write: true, noop: nullReplyNoop, keys: oneKey(keyUpdate)},
+	"setex":   {arity: 4, write: true, keys: oneKey(keyOverwrite)},
+	"psetex":  {arity: 4, write: true, keys: oneKey(keyOverwrite)},
+	"ttl":     {arity: 2, keys: oneKey(keyReadOnly)},
+	"pttl":    {arity: 2, keys: oneKey(keyReadOnly)},
+	"expire":  {arity: 3, write: true, noop: zeroReplyNoop, keys: oneKey(keyUpdate)},
+	"pexpire": {arity: 3, write: true, noop: zeroReplyNoop, keys: oneKey(keyUpdate)},
+
+	"expireat":  {arity: 3, write: true, noop: zeroReplyNoop, keys: oneKey(keyUpdate)},
+	"pexpireat": {arity: 3, write: true, noop: zeroReplyNoop, keys: oneKey(keyUpdate)},
+
+	"persist": {arity: 2, write: true, noop: zeroReplyNoop, keys: oneKey(keyUpdate)},
+	"save":    {arity: 1},
+	"bgsave":  {arity: -1},
+	"config":  {arity: -2},
+	"keys":    {arity: 2},
+	"scan":    {arity: -2},
+	"dbsize":  {arity: 1},
+	"flushdb": {arity: -1, write: true},
+	"info":    {arity: -1},
+	"debug":   {arity: -2},
+	"memory":  {arity: -2, keys: []keySpec{{first: 2, last: 2, step: 1, flags: keyReadOnly}}},
+	"object":  {arity: -2, keys: []keySpec{{first: 2, last: 2, step: 1, flags: keyReadOnly}}},
+	"incr":    {arity: 2, write: true, keys: oneKey(keyUpdate)},
+	"incrby":  {arity: 3, write: true, keys: oneKey(keyUpdate)},
+	"decr":    {arity: 2, write: true, keys: oneKey(keyUpdate)},
+	"decrby":  {arity: 3, write: true, keys: oneKey(keyUpdate)},
+
+	"incrbyfloat": {arity: 3, write: true, keys: oneKey(keyUpdate)},
+	"getrange":    {arity: 4, keys: oneKey(keyRead)},
+	"renamenx":    {arity: 3, write: true, noop: zeroReplyNoop, keys: []keySpec{{first: 1, last: 1, step: 1, flags: keyPop}, {first: 2, last: 2, step: 1, flags: keyInsertNew}}},
+	"setrange":    {arity: 4, write: true, keys: oneKey(keyUpdate)},
+
+	"mset":    {arity: -3, write: true, keys: []keySpec{{first: 1, last: -1, step: 2, flags: keyOverwrite}}},
+	"msetnx":  {arity: -3, write: true, noop: zeroReplyNoop, keys: []keySpec{{first: 1, last: -1, step: 2, flags: keyInsertNew}}},
+	"lpush":   {arity: -3, write: true, keys: oneKey(keyInsert)},
+	"rpush":   {arity: -3, write: true, keys: oneKey(keyInsert)},
+	"lpop":    {arity: 2, write: true, noop: nullReplyNoop, keys: oneKey(keyPop)},
+	"rpop":    {arity: 2, write: true, noop: nullReplyNoop, keys: oneKey(keyPop)},
+	"blpop":   {arity: -3, write: true, noop: nullReplyNoop, keys: []keySpec{{first: 1, last: -2, step: 1, flags: keyPop}}},
+	"brpop":   {arity: -3, write: true, noop: nullReplyNoop, keys: []keySpec{{first: 1, last: -2, step: 1, flags: keyPop}}},
+	"lmove":   {arity: 5, write: true, noop: nullReplyNoop, keys: moveKeys},
+	"blmove":  {arity: 6, write: true, noop: nullReplyNoop, keys: moveKeys},
+	"lmpop":   {arity: -4, write: true, noop: nullReplyNoop, keys: []keySpec{{numKeys: 1, flags: keyPop}}},
+	"blmpop":  {arity: -5, write: true, noop: nullReplyNoop, keys: []keySpec{{numKeys: 2, flags: keyPop}}},
+	"llen":    {arity: 2, keys: oneKey(keyReadOnly)},
+	"lrange":  {arity: 4, keys: oneKey(keyRead)},
+	"type":    {arity: 2, keys: oneKey(keyReadOnly)},
+	"xadd":    {arity: -5, write: true, keys: oneKey(keyInsert)},
+	"xlen":    {arity: 2, keys: oneKey(keyReadOnly)},
+	"xrange":  {arity: -4, keys: oneKey(keyRead)},
+	"xread":   {arity: -4, keys: []keySpec{{afterStreams: true, flags: keyRead}}},
+	"xgroup":  {arity: -2, write: true, keys: []keySpec{{first: 2, last: 2, step: 1, flags: keyUpdate}}},
+	"xack":    {arity: -4, write: true, noop: zeroReplyNoop, keys: oneKey(keyUpdate)},
+	"xinfo":   {arity: -2, keys: []keySpec{{first: 2, last: 2, step: 1, flags: keyReadOnly}}},
+	"dump":    {arity: 2, keys: oneKey(keyRead)},
+	"restore": {arity: -4, write: true, keys: oneKey(keyOverwrite)},
+	"client":  {arity: -2},
+	"cluster": {arity: -2},
+	"command": {arity: -1},
+
+	"xreadgroup": {arity: -7, write: true, noop: nullReplyNoop, keys: []keySpec{{afterStreams: true, flags: keyUpdate}}},
+
+	"subscribe":   {arity: -2},
+	"unsubscribe": {arity: -1},
+	"publish":     {arity: 3},
+
+	"eval":     {arity: -3, keys: []keySpec{{numKeys: 2, flags: keyUpdate}}},
+	"evalsha":  {arity: -3, keys: []keySpec{{numKeys: 2, flags: keyUpdate}}},
+	"fcall":    {arity: -3, keys: []keySpec{{numKeys: 2, flags: keyUpdate}}},
+	"fcall_ro": {arity: -3, keys: []keySpec{{numKeys: 2, flags: keyRead}}},
+	"script":   {arity: -2},
+	"function": {arity: -2},
+}
+
+// validateCommand checks that cmd is known and has a valid argument count.
+// notAllowedInMulti lists commands that can't be queued in a transaction:
+// entering subscriber mode halfway through EXEC would strand the replies
+// of the commands after it.
+var notAllowedInMulti = map[string]bool{
+	"subscribe":   true,
+	"unsubscribe": true,
+}
+
+func validateCommand(cmd Command) (RespData, bool) {
+	name := strings.ToLower(cmd.cmd)
+	spec, ok := commandTable[name]
+	if !ok {
+		return RespData{Type: Error, Str: "ERR unknown command '" + cmd.cmd + "'"}, false
+	}
+	argc := len(cmd.args) + 1
+	if (spec.arity > 0 && argc != spec.arity) || (spec.arity < 0 && argc < -spec.arity) {
+		return RespData{Type: Error, Str: "ERR wrong number of arguments for '" + name + "' command"}, false
+	}
+	return RespData{}, true
 }
 
 func parseCmd(r RespData) (Command, error) {
@@ -47,6 +202,19 @@ func parseCmd(r RespData) (Command, error) {
 
 // executeCommand handles the command logic and returns RespData
 func executeCommand(cmd Command, clientConn *ClientConn, context bool) RespData {
+	// Unknown commands and bad argument counts never reach a handler. In a
+	// transaction they also make EXEC abort.
+	if errReply, ok := validateCommand(cmd); !ok {
+		if clientConn.isTransaction && !context {
+			clientConn.queueError = true
+		}
+		return errReply
+	}
+	if len(clientConn.subscriptions) > 0 && !allowedWhileSubscribed[strings.ToLower(cmd.cmd)] {
+		return subscribedModeError(cmd)
+	}
+
+	db := clientConn.db
 	switch strings.ToLower(cmd.cmd) {
 	case "multi":
 		return handleMultiCommand(cmd, clientConn)
@@ -57,66 +225,188 @@ func executeCommand(cmd Command, clientConn *ClientConn, context bool) RespData
 
 	}
 	if clientConn.isTransaction && !context {
+		if notAllowedInMulti[strings.ToLower(cmd.cmd)] {
+			clientConn.queueError = true
+			return RespData{Type: Error, Str: "ERR " + strings.ToUpper(cmd.cmd) + " is not allowed in transactions"}
+		}
+		if limit := server.maxQueuedCommands.Load(); limit > 0 && int64(len(clientConn.transactionQueue)) >= limit {
+			clientConn.queueError = true
+			return RespData{Type: Error, Str: "ERR Too many commands queued in transaction"}
+		}
 		clientConn.transactionQueue = append(clientConn.transactionQueue, cmd)
 		return RespData{Type: SimpleString, Str: "QUEUED"}
 	}
 
 	switch strings.ToLower(cmd.cmd) {
 	case "ping":
-		return RespData{Type: SimpleString, Str: "PONG"}
+		return handlePingCommand(cmd, clientConn)
 
 	case "echo":
 		return RespData{Type: BulkString, Str: cmd.args[0]}
+	case "select":
+		return handleSelectCommand(cmd, clientConn)
+	case "swapdb":
+		return handleSwapDBCommand(cmd)
 
 	case "set":
-		return handleSetCommand(cmd)
+		return handleSetCommand(cmd, db)
 	case "delete":
-		return handleDeleteCommand(cmd)
+		return handleDeleteCommand(cmd, db)
+	case "exists":
+		return handleExistsCommand(cmd, db)
+	case "rename", "renamenx":
+		return handleRenameCommand(cmd, db)
+	case "copy":
+		return handleCopyCommand(cmd, db)
 
 	case "get":
-		return handleGetCommand(cmd)
+		return handleGetCommand(cmd, db)
+	case "mget":
+		return handleMGetCommand(cmd, db)
+	case "getdel":
+		return handleGetDelCommand(cmd, db)
+	case "getex":
+		return handleGetExCommand(cmd, db)
+	case "append":
+		return handleAppendCommand(cmd, db)
+	case "getrange":
+		return handleGetRangeCommand(cmd, db)
+	case "setrange":
+		return handleSetRangeCommand(cmd, db)
+	case "setex", "psetex":
+		return handleSetExCommand(cmd, db)
+	case "ttl", "pttl":
+		return handleTTLCommand(cmd, db)
+	case "expire", "pexpire", "expireat", "pexpireat":
+		return handleExpireCommand(cmd, db)
+	case "persist":
+		return handlePersistCommand(cmd, db)
 
 	case "save":
-		if err := db.SaveRDB(); err != nil {
+		if err := server.SaveRDB(); err != nil {
 			return RespData{Type: Error, Str: fmt.Sprintf("ERR %v", err)}
 		}
 		return RespData{Type: SimpleString, Str: "OK"}
+	case "bgsave":
+		if !server.BackgroundSave() {
+			return RespData{Type: Error, Str: "ERR Background save already in progress"}
+		}
+		return RespData{Type: SimpleString, Str: "Background saving started"}
 
 	case "config":
 		return handleConfigCommand(cmd)
 
 	case "keys":
-		return handleKeysCommand(cmd)
+		return handleKeysCommand(cmd, db)
+	case "scan":
+		return handleScanCommand(cmd, db)
+	case "dbsize":
+		return RespData{Type: Integer, Num: int64(db.Size())}
+	case "flushdb":
+		return handleFlushDBCommand(cmd, db)
 
 	case "info":
-		// Minimal INFO without replication details
-		return RespData{Type: BulkString, Str: "role:master"}
+		return handleInfoCommand(cmd)
+
+	case "debug":
+		return handleDebugCommand(cmd, db)
+	case "memory":
+		return handleMemoryCommand(cmd, db)
+	case "object":
+		return handleObjectCommand(cmd, db)
+
+	case "client":
+		return handleClientCommand(cmd, clientConn)
+
+	case "subscribe":
+		return handleSubscribeCommand(cmd, clientConn)
+	case "unsubscribe":
+		return handleUnsubscribeCommand(cmd, clientConn)
+	case "publish":
+		return handlePublishCommand(cmd)
+
+	case "eval", "evalsha", "fcall", "fcall_ro":
+		return handleEvalCommand(cmd, clientConn)
+	case "script":
+		return handleScriptCommand(cmd)
+	case "function":
+		return handleFunctionCommand(cmd)
+
+	case "dump":
+		return handleDumpCommand(cmd, db)
+	case "restore":
+		return handleRestoreCommand(cmd, db)
 
 	case "incr":
-		return handleIncrCommand(cmd)
+		return handleIncrCommand(cmd, db)
+	case "incrby", "decr", "decrby":
+		return handleIncrByCommand(cmd, db)
+	case "incrbyfloat":
+		return handleIncrByFloatCommand(cmd, db)
+
+	case "cluster":
+		return handleClusterCommand(cmd)
+
+	case "command":
+		return handleCommandCommand(cmd)
+
+	case "mset":
+		return handleMSetCommand(cmd, db)
+	case "msetnx":
+		return handleMSetNXCommand(cmd, db)
+
+	case "blpop":
+		return handleBLPopCommand(cmd, clientConn)
+
+	case "brpop":
+		return handleBRPopCommand(cmd, clientConn)
+
+	case "lmove":
+		return handleLMoveCommand(cmd, db)
+
+	case "blmove":
+		return handleBLMoveCommand(cmd, clientConn)
+
+	case "lmpop":
+		return handleLMPopCommand(cmd, db)
+
+	case "blmpop":
+		return handleBLMPopCommand(cmd, clientConn)
 
 	case "lpush":
-		return handleLPushCommand(cmd)
+		return handleLPushCommand(cmd, db)
 	case "rpush":
-		return handleRPushCommand(cmd)
+		return handleRPushCommand(cmd, db)
 	case "lpop":
-		return handleLPopCommand(cmd)
+		return handleLPopCommand(cmd, db)
 	case "rpop":
-		return handleRPopCommand(cmd)
+		return handleRPopCommand(cmd, db)
 	case "llen":
-		return handleLLenCommand(cmd)
+		return handleLLenCommand(cmd, db)
 	case "lrange":
-		return handleLRangeCommand(cmd)
+		return handleLRangeCommand(cmd, db)
 	case "type":
-		return handleTypeCommand(cmd)
+		return handleTypeCommand(cmd, db)
 	case "xadd":
-		return handleXAddCommand(cmd)
+		return handleXAddCommand(cmd, db)
 	case "xlen":
-		return handleXLenCommand(cmd)
+		return handleXLenCommand(cmd, db)
 	case "xrange":
-		return handleXRangeCommand(cmd)
+		return handleXRangeCommand(cmd, db)
 	case "xread":
-		return handleXReadCommand(cmd)
+		return handleXReadCommand(cmd, clientConn)
+
+	case "xgroup":
+		return handleXGroupCommand(cmd, db)
+
+	case "xreadgroup":
+		return handleXReadGroupCommand(cmd, clientConn)
+
+	case "xack":
+		return handleXAckCommand(cmd, db)
+
+	case "xinfo":
+		return handleXInfoCommand(cmd, db)
 
 	default:
 		return RespData{Type: Error, Str: "ERR unknown command '" + cmd.cmd + "'"}
@@ -124,67 +414,574 @@ func executeCommand(cmd Command, clientConn *ClientConn, context bool) RespData
 }
 
 // handleCommand executes the command and writes the result
-func handleCommand(cmd Command, r *RESPreader, clientConn *ClientConn) {
-	result := executeCommand(cmd, clientConn, false)
+// commandMu makes scripts atomic: ordinary commands share it while a script
+// holds it exclusively. Blocking commands skip it so a client waiting on a
+// stream can't stall every script.
+var commandMu sync.RWMutex
 
-	// Handle special cases that need additional operations after getting result
+func isBlockingCommand(cmd Command) bool {
 	switch strings.ToLower(cmd.cmd) {
-	case "config":
-		if strings.ToLower(cmd.args[0]) == "get" {
-			handleConfigGetResponse(cmd, r)
-			return
+	case "blpop", "brpop", "blmove", "blmpop":
+		return true
+	case "debug":
+		// DEBUG SLEEP only pauses the calling client
+		return len(cmd.args) > 0 && strings.ToLower(cmd.args[0]) == "sleep"
+	case "xread", "xreadgroup":
+	default:
+		return false
+	}
+	for _, arg := range cmd.args {
+		if strings.ToLower(arg) == "block" {
+			return true
 		}
 	}
+	return false
+}
 
-	// Standard response writing
-	if result.Type == Array && len(result.Array) > 0 {
-		r.WriteArray(result.Array)
-	} else {
-		r.Write(result)
+func isScriptCommand(cmd Command) bool {
+	name := strings.ToLower(cmd.cmd)
+	return name == "eval" || name == "evalsha"
+}
+
+// runsScript reports whether cmd will run a script now: an EVAL outside a
+// transaction, or an EXEC with an EVAL queued. Either must hold commandMu
+// exclusively.
+func runsScript(cmd Command, clientConn *ClientConn) bool {
+	if !clientConn.isTransaction {
+		return isScriptCommand(cmd)
+	}
+	return strings.ToLower(cmd.cmd) == "exec" && slices.ContainsFunc(clientConn.transactionQueue, isScriptCommand)
+}
+
+// propagate receives each write command that ran without an error, with
+// the index of the database it ran against. This is where a replication or
+// AOF feed would attach; for now nothing consumes it.
+var propagate = func(dbIndex int, cmd Command) {}
+
+// propagateWrite passes cmd to propagate if it is a write that changed the
+// keyspace. A served blocking pop goes out in its non-blocking form, so
+// replaying it never waits.
+func propagateWrite(dbIndex int, cmd Command, result RespData) {
+	spec, ok := commandTable[strings.ToLower(cmd.cmd)]
+	if !ok || !spec.write || result.Type == Error {
+		return
 	}
+	if spec.noop != nil && spec.noop(cmd, result) {
+		return
+	}
+	propagate(dbIndex, unblockedForm(cmd, result))
+}
+
+// nullReplyNoop is the noop check for writes that reply null when there
+// was nothing to pop, move or delete, including blocking pops that timed out.
+func nullReplyNoop(cmd Command, reply RespData) bool {
+	return reply.IsNull
+}
+
+// zeroReplyNoop is the noop check for writes that reply with the number of
+// keys or entries they changed.
+func zeroReplyNoop(cmd Command, reply RespData) bool {
+	return reply.Type == Integer && reply.Num == 0
+}
 
-	// Replication removed: no command propagation
+// setNoop reports a SET whose NX or XX condition failed. With GET a null
+// reply only means there was no old value, so the key was still written.
+func setNoop(cmd Command, reply RespData) bool {
+	if !reply.IsNull {
+		return false
+	}
+	for _, arg := range cmd.args[2:] {
+		if strings.EqualFold(arg, "get") {
+			return false
+		}
+	}
+	return true
+}
+
+// unblockedForm rewrites a served BLPOP, BRPOP, BLMOVE or BLMPOP as the
+// non-blocking command that has the same effect; other commands are
+// returned unchanged.
+func unblockedForm(cmd Command, result RespData) Command {
+	switch strings.ToLower(cmd.cmd) {
+	case "blpop":
+		return Command{cmd: "LPOP", args: []string{result.Array[0].Str}}
+	case "brpop":
+		return Command{cmd: "RPOP", args: []string{result.Array[0].Str}}
+	case "blmove":
+		return Command{cmd: "LMOVE", args: cmd.args[:4]}
+	case "blmpop":
+		_, left, _, _ := parseMPopArgs(cmd.args[1:], "blmpop")
+		where := "RIGHT"
+		if left {
+			where = "LEFT"
+		}
+		count := strconv.Itoa(len(result.Array[1].Array))
+		return Command{cmd: "LMPOP", args: []string{"1", result.Array[0].Str, where, "COUNT", count}}
+	}
+	return cmd
+}
+
+func handleCommand(cmd Command, r *RESPreader, clientConn *ClientConn) {
+	if !clientConn.allowCommand(server.commandsPerSecond.Load()) {
+		r.WriteError("ERR rate limit exceeded")
+		return
+	}
+	server.totalCommands.Add(1)
+	// Inside MULTI the command is only queued; EXEC propagates it once run
+	queued := clientConn.isTransaction
+	dbIndex := clientConn.db.id
+	var result RespData
+	switch {
+	case runsScript(cmd, clientConn):
+		commandMu.Lock()
+		result = executeCommand(cmd, clientConn, false)
+		commandMu.Unlock()
+	case isBlockingCommand(cmd):
+		result = executeCommand(cmd, clientConn, false)
+	default:
+		commandMu.RLock()
+		result = executeCommand(cmd, clientConn, false)
+		commandMu.RUnlock()
+	}
+	if !queued {
+		propagateWrite(dbIndex, cmd, result)
+	}
+	r.Write(result)
+}
+
+func handlePingCommand(cmd Command, clientConn *ClientConn) RespData {
+	if len(cmd.args) > 1 {
+		return RespData{Type: Error, Str: "ERR wrong number of arguments for 'ping' command"}
+	}
+
+	// Subscribers get ["pong", message] instead of a plain reply
+	if len(clientConn.subscriptions) > 0 {
+		message := ""
+		if len(cmd.args) == 1 {
+			message = cmd.args[0]
+		}
+		return RespData{
+			Type: Array,
+			Array: []RespData{
+				{Type: BulkString, Str: "pong"},
+				{Type: BulkString, Str: message},
+			},
+		}
+	}
+
+	if len(cmd.args) == 1 {
+		return RespData{Type: BulkString, Str: cmd.args[0]}
+	}
+	return RespData{Type: SimpleString, Str: "PONG"}
 }
 
-func handleTypeCommand(cmd Command) RespData {
+// handleSelectCommand switches the connection to another numbered database.
+func handleSelectCommand(cmd Command, clientConn *ClientConn) RespData {
+	if len(cmd.args) != 1 {
+		return RespData{Type: Error, Str: "ERR wrong number of arguments for 'select' command"}
+	}
+
+	index, err := strconv.Atoi(cmd.args[0])
+	if err != nil {
+		return RespData{Type: Error, Str: "ERR value is not an integer or out of range"}
+	}
+	if index < 0 || index >= len(server.databases) {
+		return RespData{Type: Error, Str: "ERR DB index is out of range"}
+	}
+	clientConn.db = server.databases[index]
+	return RespData{Type: SimpleString, Str: "OK"}
+}
+
+// handleSwapDBCommand handles SWAPDB index1 index2.
+func handleSwapDBCommand(cmd Command) RespData {
+	if len(cmd.args) != 2 {
+		return RespData{Type: Error, Str: "ERR wrong number of arguments for 'swapdb' command"}
+	}
+
+	first, err := strconv.Atoi(cmd.args[0])
+	if err != nil {
+		return RespData{Type: Error, Str: "ERR invalid first DB index"}
+	}
+	second, err := strconv.Atoi(cmd.args[1])
+	if err != nil {
+		return RespData{Type: Error, Str: "ERR invalid second DB index"}
+	}
+	if first < 0 || first >= len(server.databases) || second < 0 || second >= len(server.databases) {
+		return RespData{Type: Error, Str: "ERR DB index is out of range"}
+	}
+	server.SwapDB(first, second)
+	return RespData{Type: SimpleString, Str: "OK"}
+}
+
+func handleTypeCommand(cmd Command, db *DataBase) RespData {
 	if len(cmd.args) != 1 {
 		return RespData{Type: Error, Str: "ERR wrong number of arguments for 'type' command"}
 	}
 
-	val := db.GetType(cmd.args[0])
-	if val == nil {
+	entry, ok := db.Lookup(cmd.args[0])
+	if !ok {
 		return RespData{Type: SimpleString, Str: "none"}
 	}
 
-	return RespData{Type: SimpleString, Str: *val}
+	return RespData{Type: SimpleString, Str: entry.dataType.String()}
 }
 
 // Helper functions for individual command logic
-func handleSetCommand(cmd Command) RespData {
-	if len(cmd.args) == 2 {
-		db.Add(cmd.args[0], cmd.args[1])
-		return RespData{Type: SimpleString, Str: "OK"}
-	} else if len(cmd.args) == 4 && strings.ToLower(cmd.args[2]) == "px" {
-		num, err := strconv.Atoi(cmd.args[3])
-		if err != nil {
-			return RespData{Type: Error, Str: "wrong numeric value for expiry"}
+func handleSetCommand(cmd Command, db *DataBase) RespData {
+	if len(cmd.args) < 2 {
+		return RespData{Type: Error, Str: "ERR wrong number of arguments for 'set' command"}
+	}
+
+	key, value := cmd.args[0], cmd.args[1]
+	opts := SetOptions{ExpireAt: -1}
+	get := false
+	for i := 2; i < len(cmd.args); i++ {
+		switch strings.ToLower(cmd.args[i]) {
+		case "nx":
+			opts.NX = true
+		case "xx":
+			opts.XX = true
+		case "get":
+			get = true
+		case "keepttl":
+			if opts.ExpireAt != -1 {
+				return RespData{Type: Error, Str: "ERR syntax error"}
+			}
+			opts.KeepTTL = true
+		case "ex", "px", "exat", "pxat":
+			if opts.ExpireAt != -1 || opts.KeepTTL || i+1 >= len(cmd.args) {
+				return RespData{Type: Error, Str: "ERR syntax error"}
+			}
+			at, err := parseExpireAt(cmd.args[i], cmd.args[i+1], "set")
+			if err != nil {
+				return RespData{Type: Error, Str: err.Error()}
+			}
+			i++
+			opts.ExpireAt = at
+		default:
+			return RespData{Type: Error, Str: "ERR syntax error"}
 		}
-		db.Addex(cmd.args[0], cmd.args[1], int64(num))
-		return RespData{Type: SimpleString, Str: "OK"}
 	}
-	return RespData{Type: Error, Str: "ERR wrong number of arguments for 'set' command"}
+	if opts.NX && opts.XX {
+		return RespData{Type: Error, Str: "ERR syntax error"}
+	}
+
+	old, written, err := db.Set(key, value, opts, get)
+	if err != nil {
+		return RespData{Type: Error, Str: err.Error()}
+	}
+	if written {
+		db.notifyKeyspaceEvent(notifyString, "set", key)
+		if opts.ExpireAt != -1 {
+			db.notifyKeyspaceEvent(notifyGeneric, expireEvent(opts.ExpireAt), key)
+		}
+	}
+	if get {
+		if old == nil {
+			return RespData{Type: BulkString, IsNull: true}
+		}
+		return RespData{Type: BulkString, Str: *old}
+	}
+	if !written {
+		return RespData{Type: BulkString, IsNull: true}
+	}
+	return RespData{Type: SimpleString, Str: "OK"}
 }
 
-func handleGetCommand(cmd Command) RespData {
+// parseExpireAt converts the argument of an EX, PX, EXAT or PXAT option to
+// an absolute deadline in unix milliseconds. Zero and negative values are
+// rejected; an absolute time in the past is returned as is and deletes the
+// key when applied. command names the command in the error message.
+func parseExpireAt(option, arg, command string) (int64, error) {
+	option = strings.ToLower(option)
+	unit := int64(1)
+	if option == "ex" || option == "exat" {
+		unit = 1000
+	}
+	num, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return 0, errors.New("ERR value is not an integer or out of range")
+	}
+	var base int64
+	if option == "ex" || option == "px" {
+		base = time.Now().UnixMilli()
+	}
+	// The deadline must fit in an int64 too
+	if num <= 0 || num > (math.MaxInt64-base)/unit {
+		return 0, fmt.Errorf("ERR invalid expire time in '%s' command", command)
+	}
+	return base + num*unit, nil
+}
+
+// handleSetExCommand handles SETEX key seconds value and PSETEX key
+// milliseconds value.
+func handleSetExCommand(cmd Command, db *DataBase) RespData {
+	name := strings.ToLower(cmd.cmd)
+	if len(cmd.args) != 3 {
+		return RespData{Type: Error, Str: "ERR wrong number of arguments for '" + name + "' command"}
+	}
+
+	option := "ex"
+	if name == "psetex" {
+		option = "px"
+	}
+	at, err := parseExpireAt(option, cmd.args[1], name)
+	if err != nil {
+		return RespData{Type: Error, Str: err.Error()}
+	}
+	db.Set(cmd.args[0], cmd.args[2], SetOptions{ExpireAt: at}, false)
+	db.notifyKeyspaceEvent(notifyString, "set", cmd.args[0])
+	db.notifyKeyspaceEvent(notifyGeneric, expireEvent(at), cmd.args[0])
+	return RespData{Type: SimpleString, Str: "OK"}
+}
+
+// handleTTLCommand handles TTL and PTTL: the remaining lifetime of a key in
+// seconds or milliseconds, -1 if it has no expiry and -2 if it is missing.
+func handleTTLCommand(cmd Command, db *DataBase) RespData {
+	name := strings.ToLower(cmd.cmd)
+	if len(cmd.args) != 1 {
+		return RespData{Type: Error, Str: "ERR wrong number of arguments for '" + name + "' command"}
+	}
+
+	deadline, found := db.ExpiryMillis(cmd.args[0])
+	if !found {
+		return RespData{Type: Integer, Num: -2}
+	}
+	if deadline == -1 {
+		return RespData{Type: Integer, Num: -1}
+	}
+	remaining := max(deadline-time.Now().UnixMilli(), 0)
+	if name == "ttl" {
+		remaining = ttlSeconds(remaining)
+	}
+	return RespData{Type: Integer, Num: remaining}
+}
+
+// ttlSeconds rounds a remaining time in milliseconds to the nearest second
+// for TTL, as Redis does, so 1500ms left reports 2.
+func ttlSeconds(ms int64) int64 {
+	return (ms + 500) / 1000
+}
+
+// expireOptions maps the EXPIRE family to the SET option with the same
+// unit and base.
+var expireOptions = map[string]string{
+	"expire":    "ex",
+	"pexpire":   "px",
+	"expireat":  "exat",
+	"pexpireat": "pxat",
+}
+
+// parseExpireTimeout is parseExpireAt for the EXPIRE family, where a zero
+// or negative timeout isn't an error but a deadline that has already
+// passed, so the key is deleted.
+func parseExpireTimeout(option, arg, command string) (int64, error) {
+	if num, err := strconv.ParseInt(arg, 10, 64); err == nil && num <= 0 {
+		return 0, nil
+	}
+	return parseExpireAt(option, arg, command)
+}
+
+// handleExpireCommand handles EXPIRE key seconds, PEXPIRE key milliseconds
+// and their absolute EXPIREAT and PEXPIREAT forms. A deadline that has
+// already passed deletes the key.
+func handleExpireCommand(cmd Command, db *DataBase) RespData {
+	name := strings.ToLower(cmd.cmd)
+	if len(cmd.args) != 2 {
+		return RespData{Type: Error, Str: "ERR wrong number of arguments for '" + name + "' command"}
+	}
+
+	at, err := parseExpireTimeout(expireOptions[name], cmd.args[1], name)
+	if err != nil {
+		return RespData{Type: Error, Str: err.Error()}
+	}
+	if !db.Expire(cmd.args[0], at) {
+		return RespData{Type: Integer, Num: 0}
+	}
+	db.notifyKeyspaceEvent(notifyGeneric, expireEvent(at), cmd.args[0])
+	return RespData{Type: Integer, Num: 1}
+}
+
+func handlePersistCommand(cmd Command, db *DataBase) RespData {
+	if len(cmd.args) != 1 {
+		return RespData{Type: Error, Str: "ERR wrong number of arguments for 'persist' command"}
+	}
+
+	if !db.Persist(cmd.args[0]) {
+		return RespData{Type: Integer, Num: 0}
+	}
+	db.notifyKeyspaceEvent(notifyGeneric, "persist", cmd.args[0])
+	return RespData{Type: Integer, Num: 1}
+}
+
+func handleGetCommand(cmd Command, db *DataBase) RespData {
 	if len(cmd.args) != 1 {
 		return RespData{Type: Error, Str: "ERR wrong number of arguments for 'get' command"}
 	}
 
-	val := db.Get(cmd.args[0])
-	if val == nil {
+	entry, ok, wrongType := db.GetTyped(cmd.args[0], StringType)
+	if wrongType {
+		return RespData{Type: Error, Str: ErrWrongType.Error()}
+	}
+	if !ok {
 		return RespData{Type: BulkString, IsNull: true}
 	}
-	return RespData{Type: BulkString, Str: *val}
+	return RespData{Type: BulkString, Str: entry.val}
+}
+
+// handleMGetCommand returns the value of every key in order, with a null
+// for keys that are missing or don't hold a string.
+func handleMGetCommand(cmd Command, db *DataBase) RespData {
+	if len(cmd.args) < 1 {
+		return RespData{Type: Error, Str: "ERR wrong number of arguments for 'mget' command"}
+	}
+
+	values := make([]RespData, len(cmd.args))
+	for i, key := range cmd.args {
+		if val := db.Get(key); val != nil {
+			values[i] = RespData{Type: BulkString, Str: *val}
+		} else {
+			values[i] = RespData{Type: BulkString, IsNull: true}
+		}
+	}
+	return RespData{Type: Array, Array: values}
+}
+
+func handleGetDelCommand(cmd Command, db *DataBase) RespData {
+	if len(cmd.args) != 1 {
+		return RespData{Type: Error, Str: "ERR wrong number of arguments for 'getdel' command"}
+	}
+
+	val, ok, err := db.GetDel(cmd.args[0])
+	if err != nil {
+		return RespData{Type: Error, Str: err.Error()}
+	}
+	if !ok {
+		return RespData{Type: BulkString, IsNull: true}
+	}
+	db.notifyKeyspaceEvent(notifyGeneric, "del", cmd.args[0])
+	return RespData{Type: BulkString, Str: val}
+}
+
+func handleGetExCommand(cmd Command, db *DataBase) RespData {
+	if len(cmd.args) < 1 {
+		return RespData{Type: Error, Str: "ERR wrong number of arguments for 'getex' command"}
+	}
+
+	var at int64 = -1
+	update := false
+	for i := 1; i < len(cmd.args); i++ {
+		switch strings.ToLower(cmd.args[i]) {
+		case "ex", "px", "exat", "pxat":
+			if update || i+1 >= len(cmd.args) {
+				return RespData{Type: Error, Str: "ERR syntax error"}
+			}
+			deadline, err := parseExpireAt(cmd.args[i], cmd.args[i+1], "getex")
+			if err != nil {
+				return RespData{Type: Error, Str: err.Error()}
+			}
+			i++
+			at, update = deadline, true
+		case "persist":
+			if update {
+				return RespData{Type: Error, Str: "ERR syntax error"}
+			}
+			update = true
+		default:
+			return RespData{Type: Error, Str: "ERR syntax error"}
+		}
+	}
+
+	if !update {
+		return handleGetCommand(Command{cmd: "get", args: cmd.args[:1]}, db)
+	}
+	val, ok, err := db.GetEx(cmd.args[0], at)
+	if err != nil {
+		return RespData{Type: Error, Str: err.Error()}
+	}
+	if !ok {
+		return RespData{Type: BulkString, IsNull: true}
+	}
+	if at == -1 {
+		db.notifyKeyspaceEvent(notifyGeneric, "persist", cmd.args[0])
+	} else {
+		db.notifyKeyspaceEvent(notifyGeneric, expireEvent(at), cmd.args[0])
+	}
+	return RespData{Type: BulkString, Str: val}
+}
+
+func handleAppendCommand(cmd Command, db *DataBase) RespData {
+	if len(cmd.args) != 2 {
+		return RespData{Type: Error, Str: "ERR wrong number of arguments for 'append' command"}
+	}
+
+	length, err := db.Append(cmd.args[0], cmd.args[1])
+	if err != nil {
+		return RespData{Type: Error, Str: err.Error()}
+	}
+	db.notifyKeyspaceEvent(notifyString, "append", cmd.args[0])
+	return RespData{Type: Integer, Num: int64(length)}
+}
+
+// handleGetRangeCommand returns the bytes of a string between two inclusive
+// offsets. Negative offsets count from the end; ranges are clamped to the
+// string and an empty range gives an empty string.
+func handleGetRangeCommand(cmd Command, db *DataBase) RespData {
+	if len(cmd.args) != 3 {
+		return RespData{Type: Error, Str: "ERR wrong number of arguments for 'getrange' command"}
+	}
+
+	start, err1 := strconv.ParseInt(cmd.args[1], 10, 64)
+	end, err2 := strconv.ParseInt(cmd.args[2], 10, 64)
+	if err1 != nil || err2 != nil {
+		return RespData{Type: Error, Str: errNotInteger.Error()}
+	}
+	entry, ok, wrongType := db.GetTyped(cmd.args[0], StringType)
+	if wrongType {
+		return RespData{Type: Error, Str: ErrWrongType.Error()}
+	}
+	if !ok {
+		return RespData{Type: BulkString, Str: ""}
+	}
+
+	length := int64(len(entry.val))
+	if start < 0 && end < 0 && start > end {
+		return RespData{Type: BulkString, Str: ""}
+	}
+	if start < 0 {
+		start = max(length+start, 0)
+	}
+	if end < 0 {
+		end = max(length+end, 0)
+	}
+	end = min(end, length-1)
+	if start > end || length == 0 {
+		return RespData{Type: BulkString, Str: ""}
+	}
+	return RespData{Type: BulkString, Str: entry.val[start : end+1]}
+}
+
+func handleSetRangeCommand(cmd Command, db *DataBase) RespData {
+	if len(cmd.args) != 3 {
+		return RespData{Type: Error, Str: "ERR wrong number of arguments for 'setrange' command"}
+	}
+
+	offset, err := strconv.ParseInt(cmd.args[1], 10, 64)
+	if err != nil {
+		return RespData{Type: Error, Str: errNotInteger.Error()}
+	}
+	if offset < 0 {
+		return RespData{Type: Error, Str: "ERR offset is out of range"}
+	}
+	length, err := db.SetRange(cmd.args[0], offset, cmd.args[2])
+	if err != nil {
+		return RespData{Type: Error, Str: err.Error()}
+	}
+	// An empty value leaves the key as it was
+	if cmd.args[2] != "" {
+		db.notifyKeyspaceEvent(notifyString, "setrange", cmd.args[0])
+	}
+	return RespData{Type: Integer, Num: int64(length)}
 }
 
 func handleConfigCommand(cmd Command) RespData {
@@ -196,6 +993,9 @@ func handleConfigCommand(cmd Command) RespData {
 	case "get":
 		return handleConfigGet(cmd.args[1])
 	case "set":
+		if len(cmd.args) != 3 {
+			return RespData{Type: Error, Str: "ERR wrong number of arguments for 'config|set' command"}
+		}
 		return handleConfigSet(cmd.args[1], cmd.args[2])
 	default:
 		return RespData{Type: Error, Str: "ERR unknown config subcommand"}
@@ -205,93 +1005,408 @@ func handleConfigCommand(cmd Command) RespData {
 func handleConfigGet(param string) RespData {
 	switch param {
 	case "dir":
-		return RespData{
-			Type: Array,
-			Array: []RespData{
-				{Type: BulkString, Str: "dir"},
-				{Type: BulkString, Str: db.dir},
-			},
-		}
+		return configPair(param, server.dir)
 	case "dbfilename":
-		return RespData{
-			Type: Array,
-			Array: []RespData{
-				{Type: BulkString, Str: "dbfilename"},
-				{Type: BulkString, Str: db.dbfilename},
-			},
-		}
+		return configPair(param, server.dbfilename)
 	case "port":
-		return RespData{
-			Type: Array,
-			Array: []RespData{
-				{Type: BulkString, Str: "port"},
-				{Type: BulkString, Str: db.port},
-			},
-		}
+		return configPair(param, server.port)
+	case "databases":
+		return configPair(param, strconv.Itoa(len(server.databases)))
+	case "proto-max-bulk-len":
+		return configPair(param, strconv.FormatInt(server.protoMaxBulkLen.Load(), 10))
+	case "max-queued-commands":
+		return configPair(param, strconv.FormatInt(server.maxQueuedCommands.Load(), 10))
+	case "list-max-listpack-size":
+		return configPair(param, strconv.FormatInt(server.listMaxListpackSize.Load(), 10))
+	case "commands-per-second":
+		return configPair(param, strconv.FormatInt(server.commandsPerSecond.Load(), 10))
+	case "save":
+		return configPair(param, server.savePointsString())
+	case "notify-keyspace-events":
+		return configPair(param, notifyFlagsString(int(server.notifyFlags.Load())))
 	default:
 		return RespData{Type: Array, IsNull: true}
 	}
 }
 
+// configPair builds the [name, value] reply of CONFIG GET.
+func configPair(name, value string) RespData {
+	return RespData{
+		Type: Array,
+		Array: []RespData{
+			{Type: BulkString, Str: name},
+			{Type: BulkString, Str: value},
+		},
+	}
+}
+
 func handleConfigSet(param, value string) RespData {
 	switch param {
 	case "dir":
-		db.dir = value
+		server.dir = value
 		return RespData{Type: SimpleString, Str: "OK"}
 	case "dbfilename":
-		db.dbfilename = value
+		server.dbfilename = value
+		return RespData{Type: SimpleString, Str: "OK"}
+	case "proto-max-bulk-len":
+		num, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || num <= 0 {
+			return RespData{Type: Error, Str: "ERR Invalid argument '" + value + "' for CONFIG SET 'proto-max-bulk-len'"}
+		}
+		server.protoMaxBulkLen.Store(num)
+		return RespData{Type: SimpleString, Str: "OK"}
+	case "max-queued-commands":
+		num, err := strconv.Atoi(value)
+		if err != nil || num < 0 {
+			return RespData{Type: Error, Str: "ERR Invalid argument '" + value + "' for CONFIG SET 'max-queued-commands'"}
+		}
+		server.maxQueuedCommands.Store(int64(num))
+		return RespData{Type: SimpleString, Str: "OK"}
+	case "list-max-listpack-size":
+		num, err := strconv.Atoi(value)
+		if err != nil || num == 0 || num < -5 {
+			return RespData{Type: Error, Str: "ERR Invalid argument '" + value + "' for CONFIG SET 'list-max-listpack-size'"}
+		}
+		server.listMaxListpackSize.Store(int64(num))
+		return RespData{Type: SimpleString, Str: "OK"}
+	case "commands-per-second":
+		num, err := strconv.Atoi(value)
+		if err != nil || num < 0 {
+			return RespData{Type: Error, Str: "ERR Invalid argument '" + value + "' for CONFIG SET 'commands-per-second'"}
+		}
+		server.commandsPerSecond.Store(int64(num))
+		return RespData{Type: SimpleString, Str: "OK"}
+	case "save":
+		if err := server.setSavePoints(value); err != nil {
+			return RespData{Type: Error, Str: "ERR Invalid argument '" + value + "' for CONFIG SET 'save'"}
+		}
+		return RespData{Type: SimpleString, Str: "OK"}
+	case "notify-keyspace-events":
+		flags, ok := parseNotifyFlags(value)
+		if !ok {
+			return RespData{Type: Error, Str: "ERR Invalid argument '" + value + "' for CONFIG SET 'notify-keyspace-events'"}
+		}
+		server.notifyFlags.Store(int32(flags))
 		return RespData{Type: SimpleString, Str: "OK"}
 	default:
 		return RespData{Type: Error, Str: "ERR unsupported config parameter"}
 	}
 }
 
-func handleKeysCommand(cmd Command) RespData {
+func handleKeysCommand(cmd Command, db *DataBase) RespData {
 	if len(cmd.args) != 1 {
 		return RespData{Type: Error, Str: "ERR wrong number of arguments for 'keys' command"}
 	}
 
-	keys := make([]RespData, 0, len(db.M))
-	for k := range db.M {
-		fmt.Println(k)
-		keys = append(keys, RespData{Type: BulkString, Str: k})
+	names := db.Keys(cmd.args[0])
+	if server.sortReplies.Load() {
+		sort.Strings(names)
 	}
 
-	if len(keys) == 0 {
-		return RespData{Type: Array, IsNull: true}
+	keys := make([]RespData, 0, len(names))
+	for _, k := range names {
+		keys = append(keys, RespData{Type: BulkString, Str: k})
 	}
 	return RespData{Type: Array, Array: keys}
 }
 
-func handleIncrCommand(cmd Command) RespData {
+// handleFlushDBCommand handles FLUSHDB [ASYNC|SYNC]. Both modes flush
+// synchronously; dropping the old map is already cheap.
+func handleFlushDBCommand(cmd Command, db *DataBase) RespData {
+	if len(cmd.args) > 1 {
+		return RespData{Type: Error, Str: "ERR syntax error"}
+	}
+	if len(cmd.args) == 1 {
+		if mode := strings.ToLower(cmd.args[0]); mode != "async" && mode != "sync" {
+			return RespData{Type: Error, Str: "ERR syntax error"}
+		}
+	}
+	db.Flush()
+	return RespData{Type: SimpleString, Str: "OK"}
+}
+
+// handleScanCommand handles SCAN cursor [MATCH pattern] [COUNT count].
+func handleScanCommand(cmd Command, db *DataBase) RespData {
+	if len(cmd.args) < 1 {
+		return RespData{Type: Error, Str: "ERR wrong number of arguments for 'scan' command"}
+	}
+
+	cursor, err := strconv.ParseUint(cmd.args[0], 10, 64)
+	if err != nil {
+		return RespData{Type: Error, Str: "ERR invalid cursor"}
+	}
+	count, pattern := 10, ""
+	for i := 1; i < len(cmd.args); i += 2 {
+		if i+1 >= len(cmd.args) {
+			return RespData{Type: Error, Str: "ERR syntax error"}
+		}
+		switch strings.ToLower(cmd.args[i]) {
+		case "match":
+			pattern = cmd.args[i+1]
+		case "count":
+			num, err := strconv.Atoi(cmd.args[i+1])
+			if err != nil {
+				return RespData{Type: Error, Str: "ERR value is not an integer or out of range"}
+			}
+			if num < 1 {
+				return RespData{Type: Error, Str: "ERR syntax error"}
+			}
+			count = num
+		default:
+			return RespData{Type: Error, Str: "ERR syntax error"}
+		}
+	}
+
+	next, names := db.Scan(cursor, count, pattern)
+	if server.sortReplies.Load() {
+		sort.Strings(names)
+	}
+	keys := make([]RespData, 0, len(names))
+	for _, k := range names {
+		keys = append(keys, RespData{Type: BulkString, Str: k})
+	}
+	return RespData{
+		Type: Array,
+		Array: []RespData{
+			{Type: BulkString, Str: strconv.FormatUint(next, 10)},
+			{Type: Array, Array: keys},
+		},
+	}
+}
+
+func handleInfoCommand(cmd Command) RespData {
+	if len(cmd.args) > 1 {
+		return RespData{Type: Error, Str: "ERR syntax error"}
+	}
+
+	var stats []string
+	for _, stat := range server.statsFields() {
+		stats = append(stats, fmt.Sprintf("%s:%d", stat.name, stat.value))
+	}
+
+	// Minimal INFO without replication details
+	sections := []struct {
+		name   string
+		fields []string
+	}{
+		{"server", []string{
+			"run_id:" + server.runID,
+			"tcp_port:" + server.port,
+		}},
+		{"persistence", []string{
+			fmt.Sprintf("rdb_changes_since_last_save:%d", server.dirty.Load()),
+			fmt.Sprintf("rdb_last_save_time:%d", server.lastSave.Load()),
+		}},
+		{"replication", []string{"role:master"}},
+		{"stats", stats},
+	}
+
+	want := "all"
+	if len(cmd.args) == 1 {
+		want = strings.ToLower(cmd.args[0])
+	}
+
+	var sb strings.Builder
+	for _, section := range sections {
+		if want != "all" && want != "default" && want != section.name {
+			continue
+		}
+		if sb.Len() > 0 {
+			sb.WriteString("\r\n")
+		}
+		sb.WriteString("# " + strings.ToUpper(section.name[:1]) + section.name[1:] + "\r\n")
+		for _, field := range section.fields {
+			sb.WriteString(field + "\r\n")
+		}
+	}
+	return RespData{Type: BulkString, Str: sb.String()}
+}
+
+// handleClusterCommand answers CLUSTER MYID with the run id; cluster mode
+// itself is not supported.
+func handleClusterCommand(cmd Command) RespData {
+	if len(cmd.args) < 1 {
+		return RespData{Type: Error, Str: "ERR wrong number of arguments for 'cluster' command"}
+	}
+	if strings.ToLower(cmd.args[0]) == "myid" {
+		if len(cmd.args) != 1 {
+			return RespData{Type: Error, Str: "ERR wrong number of arguments for 'cluster|myid' command"}
+		}
+		return RespData{Type: BulkString, Str: server.runID}
+	}
+	return RespData{Type: Error, Str: "ERR This instance has cluster support disabled"}
+}
+
+func handleIncrCommand(cmd Command, db *DataBase) RespData {
 	if len(cmd.args) != 1 {
 		return RespData{Type: Error, Str: "ERR wrong number of arguments for 'incr' command"}
 	}
 
-	err := db.Incr(cmd.args[0])
+	val, err := db.Incr(cmd.args[0])
 	if err != nil {
 		return RespData{Type: Error, Str: err.Error()}
 	}
+	db.notifyKeyspaceEvent(notifyString, "incrby", cmd.args[0])
+	return RespData{Type: Integer, Num: val}
+}
+
+// handleIncrByCommand handles INCRBY, DECR and DECRBY.
+func handleIncrByCommand(cmd Command, db *DataBase) RespData {
+	name := strings.ToLower(cmd.cmd)
+	want := 2
+	if name == "decr" {
+		want = 1
+	}
+	if len(cmd.args) != want {
+		return RespData{Type: Error, Str: "ERR wrong number of arguments for '" + name + "' command"}
+	}
+
+	delta := int64(-1)
+	if want == 2 {
+		num, err := strconv.ParseInt(cmd.args[1], 10, 64)
+		if err != nil {
+			return RespData{Type: Error, Str: errNotInteger.Error()}
+		}
+		delta = num
+		if name == "decrby" {
+			if num == math.MinInt64 {
+				return RespData{Type: Error, Str: "ERR decrement would overflow"}
+			}
+			delta = -num
+		}
+	}
+	val, err := db.IncrBy(cmd.args[0], delta)
+	if err != nil {
+		return RespData{Type: Error, Str: err.Error()}
+	}
+	db.notifyKeyspaceEvent(notifyString, "incrby", cmd.args[0])
+	return RespData{Type: Integer, Num: val}
+}
+
+func handleIncrByFloatCommand(cmd Command, db *DataBase) RespData {
+	if len(cmd.args) != 2 {
+		return RespData{Type: Error, Str: "ERR wrong number of arguments for 'incrbyfloat' command"}
+	}
+
+	// Like Redis, an infinite increment parses and is rejected as a result
+	delta, err := strconv.ParseFloat(cmd.args[1], 64)
+	if (err != nil && !math.IsInf(delta, 0)) || math.IsNaN(delta) {
+		return RespData{Type: Error, Str: errNotFloat.Error()}
+	}
+	val, err := db.IncrByFloat(cmd.args[0], delta)
+	if err != nil {
+		return RespData{Type: Error, Str: err.Error()}
+	}
+	db.notifyKeyspaceEvent(notifyString, "incrbyfloat", cmd.args[0])
+	return RespData{Type: BulkString, Str: val}
+}
+
+func handleMSetCommand(cmd Command, db *DataBase) RespData {
+	if len(cmd.args) == 0 || len(cmd.args)%2 != 0 {
+		return RespData{Type: Error, Str: "ERR wrong number of arguments for 'mset' command"}
+	}
+
+	db.MSet(cmd.args)
+	for i := 0; i < len(cmd.args); i += 2 {
+		db.notifyKeyspaceEvent(notifyString, "set", cmd.args[i])
+	}
 	return RespData{Type: SimpleString, Str: "OK"}
 }
 
-func handleConfigGetResponse(cmd Command, r *RESPreader) {
-	result := handleConfigGet(cmd.args[1])
-	if result.Type == Array {
-		r.WriteArray(result.Array)
-	} else {
-		r.Write(result)
+func handleMSetNXCommand(cmd Command, db *DataBase) RespData {
+	if len(cmd.args) == 0 || len(cmd.args)%2 != 0 {
+		return RespData{Type: Error, Str: "ERR wrong number of arguments for 'msetnx' command"}
 	}
+
+	if !db.MSetNX(cmd.args) {
+		return RespData{Type: Integer, Num: 0}
+	}
+	for i := 0; i < len(cmd.args); i += 2 {
+		db.notifyKeyspaceEvent(notifyString, "set", cmd.args[i])
+	}
+	return RespData{Type: Integer, Num: 1}
 }
 
 // replication-specific slave handlers removed
 
-func handleDeleteCommand(cmd Command) RespData {
-	if len(cmd.args) != 1 {
-		return RespData{Type: Error, Str: "ERR wrong number of arguments for 'delete' command"}
+// handleExistsCommand counts how many of the given keys exist. A key named
+// more than once is counted each time.
+func handleExistsCommand(cmd Command, db *DataBase) RespData {
+	if len(cmd.args) < 1 {
+		return RespData{Type: Error, Str: "ERR wrong number of arguments for 'exists' command"}
 	}
 
-	db.Delete(cmd.args[0])
+	count := 0
+	for _, key := range cmd.args {
+		if db.Exists(key) {
+			count++
+		}
+	}
+	return RespData{Type: Integer, Num: int64(count)}
+}
 
-	return RespData{Type: SimpleString, Str: "OK"}
+// handleRenameCommand handles RENAME, which overwrites the destination,
+// and RENAMENX, which only renames if the destination doesn't exist.
+func handleRenameCommand(cmd Command, db *DataBase) RespData {
+	name := strings.ToLower(cmd.cmd)
+	if len(cmd.args) != 2 {
+		return RespData{Type: Error, Str: "ERR wrong number of arguments for '" + name + "' command"}
+	}
+
+	nx := name == "renamenx"
+	renamed, err := db.Rename(cmd.args[0], cmd.args[1], nx)
+	if err != nil {
+		return RespData{Type: Error, Str: err.Error()}
+	}
+	if renamed && cmd.args[0] != cmd.args[1] {
+		db.notifyKeyspaceEvent(notifyGeneric, "rename_from", cmd.args[0])
+		db.notifyKeyspaceEvent(notifyGeneric, "rename_to", cmd.args[1])
+	}
+	if !nx {
+		return RespData{Type: SimpleString, Str: "OK"}
+	}
+	if !renamed {
+		return RespData{Type: Integer, Num: 0}
+	}
+	return RespData{Type: Integer, Num: 1}
+}
+
+// handleCopyCommand handles COPY source destination [REPLACE].
+func handleCopyCommand(cmd Command, db *DataBase) RespData {
+	if len(cmd.args) < 2 {
+		return RespData{Type: Error, Str: "ERR wrong number of arguments for 'copy' command"}
+	}
+
+	replace := false
+	for _, opt := range cmd.args[2:] {
+		if strings.ToLower(opt) != "replace" {
+			return RespData{Type: Error, Str: "ERR syntax error"}
+		}
+		replace = true
+	}
+	if cmd.args[0] == cmd.args[1] {
+		return RespData{Type: Error, Str: "ERR source and destination objects are the same"}
+	}
+	if !db.Copy(cmd.args[0], cmd.args[1], replace) {
+		return RespData{Type: Integer, Num: 0}
+	}
+	db.notifyKeyspaceEvent(notifyGeneric, "copy_to", cmd.args[1])
+	return RespData{Type: Integer, Num: 1}
+}
+
+// handleDeleteCommand removes the given keys and replies with how many of
+// them existed.
+func handleDeleteCommand(cmd Command, db *DataBase) RespData {
+	if len(cmd.args) < 1 {
+		return RespData{Type: Error, Str: "ERR wrong number of arguments for 'delete' command"}
+	}
+
+	deleted := 0
+	for _, key := range cmd.args {
+		if db.Delete(key) {
+			db.notifyKeyspaceEvent(notifyGeneric, "del", key)
+			deleted++
+		}
+	}
+	return RespData{Type: Integer, Num: int64(deleted)}
 }
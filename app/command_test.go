@@ -0,0 +1,298 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestClusterCommand(t *testing.T) {
+	c := newTestClient(t)
+	expectReply(t, c, errorReply("ERR wrong number of arguments for 'cluster' command"), "CLUSTER")
+	expectReply(t, c, bulkReply(server.runID), "CLUSTER", "MYID")
+	expectReply(t, c, errorReply("ERR This instance has cluster support disabled"), "CLUSTER", "INFO")
+}
+
+// subcommandNames are tried as the first argument so that every
+// subcommand's own argument handling is exercised too.
+var subcommandNames = []string{
+	"1", "get", "set", "usage", "encoding", "object", "sort-replies", "myid",
+	"id", "info", "list", "getname", "setname", "exists", "flush", "load",
+	"create", "destroy", "setid", "createconsumer", "delconsumer", "stream",
+	"groups", "consumers", "getkeys", "getkeysandflags", "count", "dump",
+	"stats",
+}
+
+// TestCommandsSurviveAnyArgumentCount runs every command in the table with
+// zero to five arguments, to catch handlers that index past cmd.args.
+func TestCommandsSurviveAnyArgumentCount(t *testing.T) {
+	for name := range commandTable {
+		if name == "debug" {
+			continue // DEBUG SLEEP 1 would just sleep
+		}
+		// Keep blocking commands' timeouts short
+		filler := "1"
+		if isBlockingCommand(Command{cmd: name}) {
+			filler = "0.001"
+		}
+		for _, first := range subcommandNames {
+			for n := 0; n <= 5; n++ {
+				c := newTestClient(t)
+				args := append([]string{first}, filler, filler, filler, filler)[:n]
+				func() {
+					defer func() {
+						if r := recover(); r != nil {
+							t.Errorf("%s %v panicked: %v", name, args, r)
+						}
+					}()
+					executeCommand(Command{cmd: name, args: args}, c, false)
+				}()
+			}
+		}
+	}
+}
+
+func TestArityCheckedOutsideMulti(t *testing.T) {
+	c := newTestClient(t)
+	expectReply(t, c, errorReply("ERR wrong number of arguments for 'get' command"), "GET")
+	expectReply(t, c, errorReply("ERR unknown command 'NOPE'"), "NOPE")
+	if c.queueError {
+		t.Error("queueError set outside a transaction")
+	}
+
+	run(c, "MULTI")
+	expectReply(t, c, errorReply("ERR wrong number of arguments for 'get' command"), "GET")
+	expectReply(t, c, errorReply("EXECABORT Transaction discarded because of previous errors."), "EXEC")
+}
+
+func TestPing(t *testing.T) {
+	c := newTestClient(t)
+	expectReply(t, c, RespData{Type: SimpleString, Str: "PONG"}, "PING")
+	expectReply(t, c, bulkReply("hello"), "PING", "hello")
+	expectReply(t, c, errorReply("ERR wrong number of arguments for 'ping' command"), "PING", "a", "b")
+
+	// Subscribers get the array form
+	run(c, "SUBSCRIBE", "ch")
+	got := run(c, "PING", "hello")
+	if got.Type != Array || len(got.Array) != 2 || got.Array[0].Str != "pong" || got.Array[1].Str != "hello" {
+		t.Errorf("PING hello while subscribed: got %+v", got)
+	}
+}
+
+func TestSetGet(t *testing.T) {
+	c := newTestClient(t)
+	expectReply(t, c, nullReply(), "SET", "k", "v1", "GET")
+	expectReply(t, c, bulkReply("v1"), "SET", "k", "v2", "GET")
+	expectReply(t, c, bulkReply("v2"), "GET", "k")
+
+	// A list is neither returned nor overwritten
+	run(c, "RPUSH", "l", "a")
+	expectReply(t, c, errorReply("WRONGTYPE Operation against a key holding the wrong kind of value"), "SET", "l", "v", "GET")
+	expectReply(t, c, intReply(1), "LLEN", "l")
+
+	// With NX the old value is returned but only an absent key is written
+	expectReply(t, c, bulkReply("v2"), "SET", "k", "v3", "NX", "GET")
+	expectReply(t, c, bulkReply("v2"), "GET", "k")
+	expectReply(t, c, nullReply(), "SET", "n", "v", "GET", "NX")
+	expectReply(t, c, bulkReply("v"), "GET", "n")
+	expectReply(t, c, nullReply(), "SET", "x", "v", "XX", "GET")
+	expectReply(t, c, intReply(0), "EXISTS", "x")
+}
+
+func TestGetDel(t *testing.T) {
+	c := newTestClient(t)
+	expectReply(t, c, nullReply(), "GETDEL", "missing")
+
+	run(c, "SET", "k", "v", "EX", "100")
+	expectReply(t, c, bulkReply("v"), "GETDEL", "k")
+	expectReply(t, c, nullReply(), "GET", "k")
+	expectReply(t, c, nullReply(), "GETDEL", "k")
+
+	run(c, "RPUSH", "l", "a")
+	expectReply(t, c, errorReply("WRONGTYPE Operation against a key holding the wrong kind of value"), "GETDEL", "l")
+	expectReply(t, c, intReply(1), "LLEN", "l")
+	expectReply(t, c, errorReply("ERR wrong number of arguments for 'getdel' command"), "GETDEL", "a", "b")
+}
+
+func TestIncrByAndDecrBy(t *testing.T) {
+	c := newTestClient(t)
+	// A missing key counts from zero
+	expectReply(t, c, intReply(5), "INCRBY", "a", "5")
+	expectReply(t, c, intReply(-1), "DECR", "b")
+	expectReply(t, c, intReply(-3), "DECRBY", "c", "3")
+
+	expectReply(t, c, intReply(15), "INCRBY", "a", "10")
+	expectReply(t, c, intReply(8), "INCRBY", "a", "-7")
+	expectReply(t, c, intReply(7), "DECR", "a")
+	expectReply(t, c, intReply(-13), "DECRBY", "a", "20")
+	expectReply(t, c, bulkReply("-13"), "GET", "a")
+
+	overflow := errorReply("ERR increment or decrement would overflow")
+	run(c, "SET", "max", "9223372036854775807")
+	expectReply(t, c, overflow, "INCRBY", "max", "1")
+	expectReply(t, c, overflow, "INCR", "max")
+	expectReply(t, c, bulkReply("9223372036854775807"), "GET", "max")
+	run(c, "SET", "min", "-9223372036854775808")
+	expectReply(t, c, overflow, "DECR", "min")
+	expectReply(t, c, overflow, "DECRBY", "min", "1")
+	expectReply(t, c, errorReply("ERR decrement would overflow"), "DECRBY", "a", "-9223372036854775808")
+
+	notInteger := errorReply("ERR value is not an integer or out of range")
+	expectReply(t, c, notInteger, "INCRBY", "a", "1.5")
+	expectReply(t, c, notInteger, "DECRBY", "a", "x")
+	run(c, "SET", "word", "abc")
+	expectReply(t, c, notInteger, "INCRBY", "word", "1")
+	expectReply(t, c, notInteger, "DECR", "word")
+}
+
+func TestAppend(t *testing.T) {
+	c := newTestClient(t)
+	expectReply(t, c, intReply(5), "APPEND", "k", "hello")
+	expectReply(t, c, bulkReply("hello"), "GET", "k")
+	expectReply(t, c, intReply(11), "APPEND", "k", " world")
+	expectReply(t, c, bulkReply("hello world"), "GET", "k")
+
+	// The expiry is kept
+	run(c, "SET", "t", "a", "EX", "100")
+	expectReply(t, c, intReply(2), "APPEND", "t", "b")
+	expectReply(t, c, intReply(100), "TTL", "t")
+
+	run(c, "RPUSH", "l", "a")
+	expectReply(t, c, errorReply("WRONGTYPE Operation against a key holding the wrong kind of value"), "APPEND", "l", "x")
+	expectReply(t, c, intReply(1), "LLEN", "l")
+}
+
+func TestPropagateChangesOnly(t *testing.T) {
+	var got []string
+	saved := propagate
+	propagate = func(dbIndex int, cmd Command) {
+		got = append(got, strings.TrimSpace(cmd.cmd+" "+strings.Join(cmd.args, " ")))
+	}
+	t.Cleanup(func() { propagate = saved })
+
+	c := newTestClient(t)
+	write := func(args ...string) RespData {
+		cmd := Command{cmd: args[0], args: args[1:]}
+		reply := executeCommand(cmd, c, false)
+		propagateWrite(0, cmd, reply)
+		return reply
+	}
+	write("DELETE", "missing")
+	write("EXPIRE", "missing", "10")
+	write("GETDEL", "missing")
+	write("LPOP", "missing")
+	write("SET", "k", "v", "GET")
+	write("SET", "k", "w", "NX")
+	write("BLPOP", "missing", "0.01")
+	write("BLMOVE", "missing", "dst", "LEFT", "RIGHT", "0.01")
+	write("BLMPOP", "0.01", "1", "missing", "LEFT")
+	write("RPUSH", "l", "a", "b", "c", "d", "e")
+	if reply := write("BLPOP", "missing", "l", "0"); len(reply.Array) != 2 || reply.Array[1].Str != "a" {
+		t.Fatalf("BLPOP: got %+v", reply)
+	}
+	write("BRPOP", "l", "0")
+	write("BLMOVE", "l", "dst", "LEFT", "RIGHT", "0")
+	write("BLMPOP", "0", "2", "missing", "l", "RIGHT", "COUNT", "5")
+
+	// Writes that changed nothing, like the timed out blocking pops, are
+	// dropped; served blocking pops go out in their non-blocking form
+	want := []string{"SET k v GET", "RPUSH l a b c d e", "LPOP l", "RPOP l",
+		"LMOVE l dst LEFT RIGHT", "LMPOP 1 l RIGHT COUNT 2"}
+	if !slices.Equal(got, want) {
+		t.Errorf("propagated %q, want %q", got, want)
+	}
+}
+
+// keysAndFlags flattens a COMMAND GETKEYSANDFLAGS reply into "key:flag,flag"
+// strings.
+func keysAndFlags(reply RespData) []string {
+	var out []string
+	for _, entry := range reply.Array {
+		if len(entry.Array) != 2 {
+			return append(out, fmt.Sprintf("%+v", entry))
+		}
+		var flags []string
+		for _, f := range entry.Array[1].Array {
+			flags = append(flags, f.Str)
+		}
+		out = append(out, entry.Array[0].Str+":"+strings.Join(flags, ","))
+	}
+	return out
+}
+
+func TestCommandGetKeysAndFlags(t *testing.T) {
+	c := newTestClient(t)
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"SET", "k", "v"}, []string{"k:OW,update"}},
+		{[]string{"GET", "k"}, []string{"k:RO,access"}},
+		{[]string{"MSET", "a", "1", "b", "2"}, []string{"a:OW,update", "b:OW,update"}},
+		{[]string{"RENAME", "a", "b"}, []string{"a:RW,access,delete", "b:OW,update"}},
+	}
+	for _, tt := range tests {
+		got := keysAndFlags(run(c, append([]string{"COMMAND", "GETKEYSANDFLAGS"}, tt.args...)...))
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("GETKEYSANDFLAGS %v: got %v, want %v", tt.args, got, tt.want)
+		}
+	}
+
+	got := run(c, "COMMAND", "GETKEYS", "MSET", "a", "1", "b", "2")
+	if len(got.Array) != 2 || got.Array[0].Str != "a" || got.Array[1].Str != "b" {
+		t.Errorf("GETKEYS MSET: got %+v", got)
+	}
+	expectReply(t, c, errorReply("ERR The command has no key arguments"), "COMMAND", "GETKEYSANDFLAGS", "PING")
+	expectReply(t, c, errorReply("ERR Invalid command specified"), "COMMAND", "GETKEYSANDFLAGS", "NOPE")
+	expectReply(t, c, errorReply("ERR Invalid number of arguments specified for command"), "COMMAND", "GETKEYSANDFLAGS", "GET")
+}
+
+func TestPropagateWritesOnly(t *testing.T) {
+	var mu sync.Mutex
+	var got []string
+	saved := propagate
+	propagate = func(dbIndex int, cmd Command) {
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, fmt.Sprintf("%d %s %s", dbIndex, cmd.cmd, strings.Join(cmd.args, " ")))
+	}
+	t.Cleanup(func() { propagate = saved })
+
+	conn, r := dialTestServer(t)
+	req := respCommand("SET", "k", "1") +
+		respCommand("GET", "k") +
+		respCommand("INCR", "k") +
+		respCommand("SET", "s", "x") +
+		respCommand("INCR", "s") +
+		respCommand("SELECT", "3") +
+		respCommand("SET", "k", "v") +
+		respCommand("MULTI") +
+		respCommand("SET", "m", "1") +
+		respCommand("GET", "m") +
+		respCommand("EXEC") +
+		respCommand("EVAL", "redis.call('set', KEYS[1], 'x') redis.call('get', KEYS[1]) redis.pcall('incr', KEYS[1]) return 1", "1", "e") +
+		respCommand("MULTI") +
+		respCommand("EVAL", "return redis.call('delete', KEYS[1])", "1", "e") +
+		respCommand("EXEC")
+	if _, err := io.WriteString(conn, req); err != nil {
+		t.Fatal(err)
+	}
+	expectLines(t, r, "+OK", "$1", "1", ":2", "+OK",
+		"-ERR value is not an integer or out of range",
+		"+OK", "+OK", "+BEGIN", "+QUEUED", "+QUEUED", "*2", "+OK", "$1", "1",
+		":1", "+BEGIN", "+QUEUED", "*1", ":1")
+
+	mu.Lock()
+	defer mu.Unlock()
+	// Reads and failed writes aren't propagated; queued writes are once
+	// EXEC runs them. Scripts propagate the writes they make rather than
+	// the EVAL
+	want := []string{"0 SET k 1", "0 INCR k", "0 SET s x", "3 SET k v", "3 SET m 1",
+		"3 set e x", "3 delete e"}
+	if !slices.Equal(got, want) {
+		t.Errorf("propagated %q, want %q", got, want)
+	}
+}
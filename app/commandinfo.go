@@ -0,0 +1,142 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// keySpec locates key arguments the way Redis key specs do. Positions count
+// the command name as 0; a negative last counts back from the final
+// argument. Commands whose keys follow a numkeys argument set numKeys to its
+// position instead, and XREAD's keys are the first half of what follows
+// STREAMS.
+type keySpec struct {
+	first, last, step int
+	numKeys           int
+	afterStreams      bool
+	flags             []string
+}
+
+// Per-key flags as reported by COMMAND GETKEYSANDFLAGS.
+var (
+	keyRead      = []string{"RO", "access"}
+	keyReadOnly  = []string{"RO"}
+	keyOverwrite = []string{"OW", "update"}
+	keyUpdate    = []string{"RW", "access", "update"}
+	keyInsert    = []string{"RW", "insert"}
+	keyInsertNew = []string{"OW", "insert"}
+	keyPop       = []string{"RW", "access", "delete"}
+	keyDelete    = []string{"RM", "delete"}
+)
+
+// moveKeys describes LMOVE and BLMOVE: an element leaves the source and is
+// inserted into the destination.
+var moveKeys = []keySpec{
+	{first: 1, last: 1, step: 1, flags: keyPop},
+	{first: 2, last: 2, step: 1, flags: keyInsert},
+}
+
+func oneKey(flags []string) []keySpec {
+	return []keySpec{{first: 1, last: 1, step: 1, flags: flags}}
+}
+
+type commandKey struct {
+	name  string
+	flags []string
+}
+
+// commandKeys extracts the keys of a full command line (name included).
+func commandKeys(spec commandSpec, argv []string) []commandKey {
+	var keys []commandKey
+	add := func(positions []int, flags []string) {
+		for _, pos := range positions {
+			keys = append(keys, commandKey{name: argv[pos], flags: flags})
+		}
+	}
+
+	for _, ks := range spec.keys {
+		var positions []int
+		switch {
+		case ks.numKeys > 0:
+			if ks.numKeys >= len(argv) {
+				continue
+			}
+			n, err := strconv.Atoi(argv[ks.numKeys])
+			if err != nil || n < 0 {
+				continue
+			}
+			for i := ks.numKeys + 1; i <= ks.numKeys+n && i < len(argv); i++ {
+				positions = append(positions, i)
+			}
+		case ks.afterStreams:
+			for i := 1; i < len(argv); i++ {
+				if strings.ToLower(argv[i]) == "streams" {
+					n := (len(argv) - i - 1) / 2
+					for j := i + 1; j <= i+n; j++ {
+						positions = append(positions, j)
+					}
+					break
+				}
+			}
+		default:
+			last := ks.last
+			if last < 0 {
+				last += len(argv)
+			}
+			for i := ks.first; i <= last && i < len(argv); i += ks.step {
+				positions = append(positions, i)
+			}
+		}
+		add(positions, ks.flags)
+	}
+	return keys
+}
+
+func handleCommandCommand(cmd Command) RespData {
+	if len(cmd.args) == 0 {
+		return RespData{Type: Error, Str: "ERR wrong number of arguments for 'command' command"}
+	}
+
+	switch sub := strings.ToLower(cmd.args[0]); sub {
+	case "count":
+		return RespData{Type: Integer, Num: int64(len(commandTable))}
+	case "getkeys", "getkeysandflags":
+		if len(cmd.args) < 2 {
+			return RespData{Type: Error, Str: "ERR wrong number of arguments for 'command|" + sub + "' command"}
+		}
+		argv := cmd.args[1:]
+		spec, ok := commandTable[strings.ToLower(argv[0])]
+		if !ok {
+			return RespData{Type: Error, Str: "ERR Invalid command specified"}
+		}
+		if (spec.arity > 0 && len(argv) != spec.arity) || (spec.arity < 0 && len(argv) < -spec.arity) {
+			return RespData{Type: Error, Str: "ERR Invalid number of arguments specified for command"}
+		}
+		keys := commandKeys(spec, argv)
+		if len(keys) == 0 {
+			return RespData{Type: Error, Str: "ERR The command has no key arguments"}
+		}
+
+		results := make([]RespData, len(keys))
+		for i, key := range keys {
+			if sub == "getkeys" {
+				results[i] = RespData{Type: BulkString, Str: key.name}
+				continue
+			}
+			flags := make([]RespData, len(key.flags))
+			for j, flag := range key.flags {
+				flags[j] = RespData{Type: SimpleString, Str: flag}
+			}
+			results[i] = RespData{
+				Type: Array,
+				Array: []RespData{
+					{Type: BulkString, Str: key.name},
+					{Type: Array, Array: flags},
+				},
+			}
+		}
+		return RespData{Type: Array, Array: results}
+	default:
+		return RespData{Type: Error, Str: "ERR unknown subcommand '" + cmd.args[0] + "'. Try COMMAND HELP."}
+	}
+}
@@ -1,27 +1,93 @@
 package main
 
 import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hdt3213/rdb/encoder"
 	"github.com/hdt3213/rdb/parser"
 )
 
+// Server holds what all numbered databases share: configuration,
+// statistics and persistence.
+type Server struct {
+	databases  []*DataBase
+	dir        string
+	dbfilename string
+	port       string
+	rdbVersion int
+
+	// closing is closed on shutdown to release blocked clients
+	closing   chan struct{}
+	closeOnce sync.Once
+
+	// runID identifies this server process so clients can detect restarts
+	runID string
+
+	// protoMaxBulkLen bounds bulk strings and inline requests read from clients
+	protoMaxBulkLen atomic.Int64
+	// sortReplies makes KEYS return sorted output (DEBUG SORT-REPLIES)
+	sortReplies atomic.Bool
+	// maxQueuedCommands caps a MULTI queue per connection; 0 means no limit
+	maxQueuedCommands atomic.Int64
+	// listMaxListpackSize is the quicklist node limit reported for lists:
+	// entries per node if positive, -1..-5 for 4kb..64kb per node
+	listMaxListpackSize atomic.Int64
+	// commandsPerSecond rate limits each connection; 0 means no limit
+	commandsPerSecond atomic.Int64
+	// notifyFlags holds the notify-keyspace-events classes; 0 disables
+	// keyspace notifications
+	notifyFlags atomic.Int32
+	// activeExpireInterval is how often expired keys are swept in the
+	// background
+	activeExpireInterval time.Duration
+	// listServesHeld counts scripts and transactions in progress. While it
+	// is non-zero a push leaves blocked clients waiting, so a script or
+	// transaction sees its own pushes; releaseListServes serves them after.
+	listServesHeld atomic.Int32
+
+	// Keyspace statistics reported by INFO. Updated atomically since reads
+	// only hold the read lock.
+	keyspaceHits   atomic.Int64
+	keyspaceMisses atomic.Int64
+	totalCommands  atomic.Int64
+
+	// dirty counts changes since the last successful save; saveParams are
+	// the save points that trigger an automatic save
+	dirty      atomic.Int64
+	lastSave   atomic.Int64 // unix seconds
+	saveParams atomic.Pointer[[]savePoint]
+	saveMu     sync.Mutex // one RDB save at a time
+}
+
+// DataBase is one numbered keyspace, selected per connection with SELECT.
+// The shared server state is embedded so database methods can reach the
+// counters and configuration directly.
 type DataBase struct {
+	*Server
+	id int
+
 	M             map[string]DBentry
-	dir           string
-	dbfilename    string
-	port          string
-	rdbVersion    int
+	index         scanIndex // M's keys in SCAN order, guarded by mu
 	mu            sync.RWMutex
 	streamWaiters map[string][]*StreamWaiter // key -> waiters
 	waiterMutex   sync.RWMutex
+	listWaiters   map[string][]*ListWaiter // key -> waiters, guarded by mu
+	readyLists    map[string]struct{}      // keys pushed to while serving was held, guarded by mu
 }
+
 type DataType int
 
 const (
@@ -30,6 +96,20 @@ const (
 	StreamType
 )
 
+// String returns the name TYPE reports for t.
+func (t DataType) String() string {
+	switch t {
+	case StringType:
+		return "string"
+	case ListType:
+		return "list"
+	case StreamType:
+		return "stream"
+	default:
+		return "unknown"
+	}
+}
+
 type DBentry struct {
 	dataType  DataType
 	val       string
@@ -47,122 +127,683 @@ func (entry *DBentry) IsList() bool {
 	return entry.dataType == ListType
 }
 
-func (db *DataBase) Addex(key string, val string, expiresAt int64) {
+func (entry *DBentry) isExpired(now int64) bool {
+	return entry.ttlMs != -1 && entry.timestamp+entry.ttlMs < now
+}
+
+// deadline returns the unix time in milliseconds at which entry expires, or
+// -1 if it has no expiry.
+func (entry *DBentry) deadline() int64 {
+	if entry.ttlMs == -1 {
+		return -1
+	}
+	return entry.timestamp + entry.ttlMs
+}
+
+// clone returns a copy of entry that shares no mutable state with it.
+// Stream entries themselves are never modified once added, so only the
+// slice holding them is copied; consumer groups are copied in full.
+func (entry DBentry) clone() DBentry {
+	switch entry.dataType {
+	case ListType:
+		entry.list = slices.Clone(entry.list)
+	case StreamType:
+		stream := &Stream{
+			Entries: slices.Clone(entry.stream.Entries),
+			LastID:  entry.stream.LastID,
+			Waiters: []*StreamWaiter{},
+		}
+		if entry.stream.Groups != nil {
+			stream.Groups = make(map[string]*ConsumerGroup, len(entry.stream.Groups))
+			for name, g := range entry.stream.Groups {
+				stream.Groups[name] = g.clone()
+			}
+		}
+		entry.stream = stream
+	}
+	return entry
+}
+
+// storeLocked stores entry at key, expiring at the unix millisecond
+// deadline at, or never if at is -1. Every command that sets an expiry goes
+// through here so that a deadline which has already passed consistently
+// deletes the key instead. The caller must hold db.mu.
+func (db *DataBase) storeLocked(key string, entry DBentry, at, now int64) {
+	db.dirty.Add(1)
+	if at != -1 && at <= now {
+		db.deleteLocked(key)
+		return
+	}
+	entry.timestamp, entry.ttlMs = now, -1
+	if at != -1 {
+		entry.ttlMs = at - now
+	}
+	db.putLocked(key, entry)
+}
+
+// ErrWrongType is returned by db operations applied to a key of another type.
+var ErrWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+
+// SetOptions are the conditions and expiry of a SET.
+type SetOptions struct {
+	NX, XX   bool  // only write if key is absent / present
+	KeepTTL  bool  // retain the expiry of the value being replaced
+	ExpireAt int64 // unix ms deadline for the new value, -1 for none
+}
+
+// Set stores a string at key under opts, atomically. It returns the value
+// that was there before (nil if the key was missing) and whether the write
+// happened. If key holds another type, ErrWrongType is returned only when
+// checkType is set; otherwise the value is overwritten like any other.
+func (db *DataBase) Set(key, val string, opts SetOptions, checkType bool) (old *string, written bool, err error) {
 	db.mu.Lock()
 	defer db.mu.Unlock()
-	db.M[key] = DBentry{StringType, val, nil, nil, expiresAt, time.Now().UnixMilli()}
+	now := time.Now().UnixMilli()
+	entry, exists := db.M[key]
+	if exists && entry.isExpired(now) {
+		exists = false
+	}
+	if exists {
+		if entry.dataType == StringType {
+			old = &entry.val
+		} else if checkType {
+			return nil, false, ErrWrongType
+		}
+	}
+	if (opts.NX && exists) || (opts.XX && !exists) {
+		return old, false, nil
+	}
+	at := opts.ExpireAt
+	if opts.KeepTTL && exists {
+		at = entry.deadline()
+	}
+	db.storeLocked(key, DBentry{dataType: StringType, val: val}, at, now)
+	return old, true, nil
 }
 
-func (db *DataBase) Add(key string, val string) {
+// MSet sets every key/value pair in pairs under one lock, clearing any
+// expiry the keys had.
+func (db *DataBase) MSet(pairs []string) {
 	db.mu.Lock()
 	defer db.mu.Unlock()
-	db.M[key] = DBentry{StringType, val, nil, nil, -1, time.Now().UnixMilli()}
+	now := time.Now().UnixMilli()
+	for i := 0; i < len(pairs); i += 2 {
+		db.putLocked(pairs[i], DBentry{StringType, pairs[i+1], nil, nil, -1, now})
+	}
+	db.dirty.Add(int64(len(pairs) / 2))
 }
 
-func (db *DataBase) Incr(key string) error {
+// MSetNX sets every key/value pair in pairs only if none of the keys exist.
+// All keys are checked under the same lock that writes them.
+func (db *DataBase) MSetNX(pairs []string) bool {
 	db.mu.Lock()
 	defer db.mu.Unlock()
-	if entry, ok := db.M[key]; ok {
-		curr_val, err := strconv.Atoi(entry.val)
-		if err != nil {
-			return err
+	now := time.Now().UnixMilli()
+	for i := 0; i < len(pairs); i += 2 {
+		if entry, ok := db.M[pairs[i]]; ok && !entry.isExpired(now) {
+			return false
 		}
-		entry.val = strconv.Itoa(curr_val + 1)
-		db.M[key] = entry
+	}
+	for i := 0; i < len(pairs); i += 2 {
+		db.putLocked(pairs[i], DBentry{StringType, pairs[i+1], nil, nil, -1, now})
+	}
+	db.dirty.Add(int64(len(pairs) / 2))
+	return true
+}
+
+// GetDel returns the string at key and deletes it, atomically.
+func (db *DataBase) GetDel(key string) (string, bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	entry, ok := db.M[key]
+	if !ok || entry.isExpired(time.Now().UnixMilli()) {
+		db.recordLookup(false)
+		return "", false, nil
+	}
+	if entry.dataType != StringType {
+		return "", false, ErrWrongType
+	}
+	db.deleteLocked(key)
+	db.dirty.Add(1)
+	db.recordLookup(true)
+	return entry.val, true, nil
+}
+
+// GetEx returns the string at key and replaces its expiry with the unix
+// millisecond deadline at (-1 to remove it), atomically.
+func (db *DataBase) GetEx(key string, at int64) (string, bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	now := time.Now().UnixMilli()
+	entry, ok := db.M[key]
+	if !ok || entry.isExpired(now) {
+		db.recordLookup(false)
+		return "", false, nil
+	}
+	if entry.dataType != StringType {
+		return "", false, ErrWrongType
+	}
+	db.storeLocked(key, entry, at, now)
+	db.recordLookup(true)
+	return entry.val, true, nil
+}
+
+// Errors returned by INCR and friends when the value isn't an integer or
+// the result doesn't fit in one.
+var (
+	errNotInteger = errors.New("ERR value is not an integer or out of range")
+	errOverflow   = errors.New("ERR increment or decrement would overflow")
+)
+
+// Incr increments the integer stored at key by one and returns the new
+// value.
+func (db *DataBase) Incr(key string) (int64, error) {
+	return db.IncrBy(key, 1)
+}
+
+// IncrBy adds delta to the integer stored at key, starting from 0 if the
+// key is missing, and returns the new value.
+func (db *DataBase) IncrBy(key string, delta int64) (int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	now := time.Now().UnixMilli()
+	entry, ok := db.M[key]
+	if !ok || entry.isExpired(now) {
+		entry = DBentry{StringType, "0", nil, nil, -1, now}
+	}
+	if entry.dataType != StringType {
+		return 0, ErrWrongType
+	}
+	curr, err := strconv.ParseInt(entry.val, 10, 64)
+	if err != nil {
+		return 0, errNotInteger
+	}
+	if (delta > 0 && curr > math.MaxInt64-delta) || (delta < 0 && curr < math.MinInt64-delta) {
+		return 0, errOverflow
+	}
+	entry.val = strconv.FormatInt(curr+delta, 10)
+	db.putLocked(key, entry)
+	db.dirty.Add(1)
+	return curr + delta, nil
+}
+
+// errStringTooLong is returned when a string would grow past
+// proto-max-bulk-len.
+var errStringTooLong = errors.New("ERR string exceeds maximum allowed size (proto-max-bulk-len)")
+
+// Append appends value to the string at key, creating it if missing, and
+// returns the new length. The key's expiry is kept.
+func (db *DataBase) Append(key, value string) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	now := time.Now().UnixMilli()
+	entry, ok := db.M[key]
+	if !ok || entry.isExpired(now) {
+		entry = DBentry{StringType, "", nil, nil, -1, now}
+	}
+	if entry.dataType != StringType {
+		return 0, ErrWrongType
+	}
+	if int64(len(entry.val)+len(value)) > db.protoMaxBulkLen.Load() {
+		return 0, errStringTooLong
+	}
+	entry.val += value
+	db.putLocked(key, entry)
+	db.dirty.Add(1)
+	return len(entry.val), nil
+}
+
+// SetRange overwrites the string at key from offset with value, padding
+// with zero bytes if the string is shorter, and returns the new length. The
+// size is checked against proto-max-bulk-len before anything is allocated.
+func (db *DataBase) SetRange(key string, offset int64, value string) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	now := time.Now().UnixMilli()
+	entry, ok := db.M[key]
+	if !ok || entry.isExpired(now) {
+		if value == "" {
+			return 0, nil
+		}
+		entry = DBentry{StringType, "", nil, nil, -1, now}
+	}
+	if entry.dataType != StringType {
+		return 0, ErrWrongType
+	}
+	if value == "" {
+		return len(entry.val), nil
+	}
+	if offset > db.protoMaxBulkLen.Load()-int64(len(value)) {
+		return 0, errStringTooLong
+	}
+
+	end := int(offset) + len(value)
+	buf := []byte(entry.val)
+	if end > len(buf) {
+		buf = append(buf, make([]byte, end-len(buf))...)
+	}
+	copy(buf[offset:], value)
+	entry.val = string(buf)
+	db.putLocked(key, entry)
+	db.dirty.Add(1)
+	return len(entry.val), nil
+}
+
+// errNotFloat is returned by INCRBYFLOAT when the value isn't a float.
+var errNotFloat = errors.New("ERR value is not a valid float")
+
+// IncrByFloat adds delta to the float stored at key, starting from 0 if the
+// key is missing, and returns the new value as stored.
+func (db *DataBase) IncrByFloat(key string, delta float64) (string, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	now := time.Now().UnixMilli()
+	entry, ok := db.M[key]
+	if !ok || entry.isExpired(now) {
+		entry = DBentry{StringType, "0", nil, nil, -1, now}
+	}
+	if entry.dataType != StringType {
+		return "", ErrWrongType
+	}
+	curr, err := strconv.ParseFloat(entry.val, 64)
+	if err != nil || math.IsNaN(curr) || math.IsInf(curr, 0) {
+		return "", errNotFloat
+	}
+	result := curr + delta
+	if math.IsNaN(result) || math.IsInf(result, 0) {
+		return "", errors.New("ERR increment would produce NaN or Infinity")
+	}
+	entry.val = strconv.FormatFloat(result, 'f', -1, 64)
+	db.putLocked(key, entry)
+	db.dirty.Add(1)
+	return entry.val, nil
+}
+
+// statsFields lists the counters reported in INFO's stats section.
+func (s *Server) statsFields() []statField {
+	return []statField{
+		{"total_commands_processed", s.totalCommands.Load()},
+		{"keyspace_hits", s.keyspaceHits.Load()},
+		{"keyspace_misses", s.keyspaceMisses.Load()},
+	}
+}
+
+type statField struct {
+	name  string
+	value int64
+}
+
+// recordLookup updates the keyspace hit/miss counters for a read command.
+func (s *Server) recordLookup(found bool) {
+	if found {
+		s.keyspaceHits.Add(1)
 	} else {
-		db.M[key] = DBentry{StringType, "1", nil, nil, -1, time.Now().UnixMilli()}
+		s.keyspaceMisses.Add(1)
 	}
-	return nil
 }
 
-func (db *DataBase) Get(key string) *string {
+// Lookup fetches key for a read command, lazily deleting it if it has
+// expired, and counts a keyspace hit or miss.
+func (db *DataBase) Lookup(key string) (DBentry, bool) {
 	now := time.Now().UnixMilli()
-	// First read under RLock
 	db.mu.RLock()
-	entry, ok := db.M[key]
+	entry, exists := db.M[key]
 	db.mu.RUnlock()
-	if !ok {
-		return nil
+	if !exists {
+		db.recordLookup(false)
+		return DBentry{}, false
 	}
-	// Wrong type
-	if !entry.IsString() {
-		return nil
+
+	if entry.isExpired(now) {
+		// Expired: acquire write lock and delete if still present and expired
+		db.mu.Lock()
+		expired := false
+		if current, ok := db.M[key]; ok && current.isExpired(now) {
+			db.deleteLocked(key)
+			expired = true
+		}
+		db.mu.Unlock()
+		if expired {
+			db.notifyKeyspaceEvent(notifyExpired, "expired", key)
+		}
+		db.recordLookup(false)
+		return DBentry{}, false
 	}
-	// Not expired
-	if entry.ttlMs == -1 || entry.timestamp+entry.ttlMs >= now {
-		// Return current value (note: returning address of copy would be wrong; re-fetch under RLock)
-		db.mu.RLock()
-		defer db.mu.RUnlock()
-		if e2, ok2 := db.M[key]; ok2 && e2.IsString() && (e2.ttlMs == -1 || e2.timestamp+e2.ttlMs >= now) {
-			return &e2.val
+
+	db.recordLookup(true)
+	return entry, true
+}
+
+// GetTyped looks up key like Lookup and checks its type. ok reports whether
+// a live value of type want was found; wrongType reports that the key exists
+// but holds a different type.
+func (db *DataBase) GetTyped(key string, want DataType) (entry DBentry, ok bool, wrongType bool) {
+	entry, exists := db.Lookup(key)
+	if !exists {
+		return DBentry{}, false, false
+	}
+	if entry.dataType != want {
+		return DBentry{}, false, true
+	}
+	return entry, true, false
+}
+
+// Expire sets the expiry of an existing key to the unix millisecond
+// deadline at. A deadline in the past deletes the key. It returns false if
+// the key does not exist.
+func (db *DataBase) Expire(key string, at int64) bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	now := time.Now().UnixMilli()
+	entry, ok := db.M[key]
+	if !ok || entry.isExpired(now) {
+		return false
+	}
+	db.storeLocked(key, entry, at, now)
+	return true
+}
+
+// Persist removes the expiry of key. It returns false if the key is
+// missing or has no expiry.
+func (db *DataBase) Persist(key string) bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	now := time.Now().UnixMilli()
+	entry, ok := db.M[key]
+	if !ok || entry.isExpired(now) || entry.ttlMs == -1 {
+		return false
+	}
+	db.storeLocked(key, entry, -1, now)
+	return true
+}
+
+// ExpiryMillis returns the unix millisecond deadline of key, or -1 if it
+// has no expiry. found is false if the key does not exist.
+func (db *DataBase) ExpiryMillis(key string) (deadline int64, found bool) {
+	entry, ok := db.Lookup(key)
+	if !ok {
+		return 0, false
+	}
+	return entry.deadline(), true
+}
+
+// Exists reports whether key holds a live value, lazily deleting it if it
+// has expired.
+func (db *DataBase) Exists(key string) bool {
+	_, ok := db.Lookup(key)
+	return ok
+}
+
+// Keys returns the live keys matching the glob pattern.
+func (db *DataBase) Keys(pattern string) []string {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	now := time.Now().UnixMilli()
+	var names []string
+	for key, entry := range db.M {
+		if !entry.isExpired(now) && globMatch(pattern, key) {
+			names = append(names, key)
 		}
-		return nil
 	}
-	// Expired: acquire write lock and delete if still present and expired
+	return names
+}
+
+// Size returns the number of keys, like Redis counting keys that have
+// expired but not yet been removed.
+func (db *DataBase) Size() int {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return len(db.M)
+}
+
+// Flush removes every key. The map is replaced rather than cleared so a
+// reader never sees it half emptied.
+func (db *DataBase) Flush() {
 	db.mu.Lock()
 	defer db.mu.Unlock()
-	if e3, ok3 := db.M[key]; ok3 {
-		if e3.IsString() && !(e3.ttlMs == -1 || e3.timestamp+e3.ttlMs >= now) {
-			delete(db.M, key)
+	db.dirty.Add(int64(len(db.M)))
+	db.M = make(map[string]DBentry)
+	db.index = scanIndex{}
+}
+
+// SwapDB exchanges the contents of databases i and j. Connections keep
+// their database index, so each sees the other's keys from then on.
+func (s *Server) SwapDB(i, j int) {
+	if i == j {
+		return
+	}
+	a, b := s.databases[min(i, j)], s.databases[max(i, j)]
+	// Lock in index order so two swaps can't deadlock
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	a.M, b.M = b.M, a.M
+	a.index, b.index = b.index, a.index
+	s.dirty.Add(1)
+	// Clients blocked on a list in either database may now have one to pop
+	for _, db := range []*DataBase{a, b} {
+		for key := range db.listWaiters {
+			db.serveListWaiters(key)
 		}
 	}
-	return nil
 }
 
-func (db *DataBase) GetType(key string) *string {
+// Peek returns the live entry at key without lazily deleting it or counting
+// a keyspace hit or miss. It is meant for introspection commands.
+func (db *DataBase) Peek(key string) (DBentry, bool) {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
-	if entry, ok := db.M[key]; ok {
-		var typeStr string
-		switch entry.dataType {
-		case StringType:
-			typeStr = "string"
-		case ListType:
-			typeStr = "list"
-		default:
-			typeStr = "unknown" // Fallback for any future types not explicitly handled
-		}
-		return &typeStr
+	entry, ok := db.M[key]
+	if !ok || entry.isExpired(time.Now().UnixMilli()) {
+		return DBentry{}, false
+	}
+	return entry, true
+}
+
+// Get returns the string stored at key, or nil if it is missing, expired or
+// holds another type.
+func (db *DataBase) Get(key string) *string {
+	entry, ok, _ := db.GetTyped(key, StringType)
+	if !ok {
+		return nil
+	}
+	return &entry.val
+}
+
+// defaultDatabases is how many numbered databases there are, as in Redis.
+const defaultDatabases = 16
+
+func NewServer(dir, dbfilename, port string, databases int) *Server {
+	s := &Server{
+		dir:        dir,
+		dbfilename: dbfilename,
+		port:       port,
+		rdbVersion: 10,
+
+		closing: make(chan struct{}),
+		runID:   newRunID(),
+
+		activeExpireInterval: defaultActiveExpireInterval,
+	}
+	for i := 0; i < databases; i++ {
+		s.databases = append(s.databases, &DataBase{
+			Server:        s,
+			id:            i,
+			M:             make(map[string]DBentry),
+			streamWaiters: make(map[string][]*StreamWaiter),
+			listWaiters:   make(map[string][]*ListWaiter),
+			readyLists:    make(map[string]struct{}),
+		})
+	}
+	s.protoMaxBulkLen.Store(512 * 1024 * 1024)
+	s.listMaxListpackSize.Store(-2)
+	s.lastSave.Store(time.Now().Unix())
+	s.setSavePoints(defaultSavePoints)
+	return s
+}
+
+// newRunID returns 40 random hex characters, the format Redis uses.
+func newRunID() string {
+	buf := make([]byte, 20)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(buf)
+}
+
+func (s *Server) init() error {
+	if _, err := os.Stat(s.dir + "/" + s.dbfilename); err == nil {
+		return s.LoadRDB()
 	}
 	return nil
 }
 
-func NewDatabase(dir, dbfilename, port string) *DataBase {
-	db := &DataBase{
-		M:             make(map[string]DBentry),
-		dir:           dir,
-		dbfilename:    dbfilename,
-		port:          port,
-		rdbVersion:    10,
-		mu:            sync.RWMutex{},
-		streamWaiters: make(map[string][]*StreamWaiter),
-		waiterMutex:   sync.RWMutex{},
+// Replication support removed: no propagateCommands or listenToMaster
+
+// Delete removes key and reports whether it held a live value.
+func (db *DataBase) Delete(key string) bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	entry, exists := db.M[key]
+	if !exists {
+		return false
 	}
-	return db
+	db.deleteLocked(key)
+	if entry.isExpired(time.Now().UnixMilli()) {
+		return false
+	}
+	db.dirty.Add(1)
+	return true
 }
 
-func (db *DataBase) init() {
-	if _, err := os.Stat(db.dir + "/" + db.dbfilename); err == nil {
-		err := db.LoadRDB()
-		if err != nil {
-			fmt.Printf("Error loading RDB file: %v\n", err)
+// errNoSuchKey is returned when a command's source key doesn't exist.
+var errNoSuchKey = errors.New("ERR no such key")
+
+// Rename moves the value at src, with its expiry, to dst. If nx is set it
+// only does so when dst doesn't exist; renamed reports whether it moved.
+func (db *DataBase) Rename(src, dst string, nx bool) (renamed bool, err error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	now := time.Now().UnixMilli()
+	entry, ok := db.M[src]
+	if !ok || entry.isExpired(now) {
+		return false, errNoSuchKey
+	}
+	if current, exists := db.M[dst]; nx && exists && !current.isExpired(now) {
+		return false, nil
+	}
+	if src == dst {
+		return !nx, nil
+	}
+	db.deleteLocked(src)
+	db.putLocked(dst, entry)
+	db.dirty.Add(1)
+	if entry.IsList() {
+		db.serveListWaiters(dst)
+	}
+	return true, nil
+}
+
+// Copy copies the value at src, with its expiry, to dst. Unless replace is
+// set an existing dst is left alone. It reports whether the copy was made.
+func (db *DataBase) Copy(src, dst string, replace bool) bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	now := time.Now().UnixMilli()
+	entry, ok := db.M[src]
+	if !ok || entry.isExpired(now) {
+		return false
+	}
+	if current, exists := db.M[dst]; exists && !replace && !current.isExpired(now) {
+		return false
+	}
+	entry = entry.clone()
+	db.putLocked(dst, entry)
+	db.dirty.Add(1)
+	if entry.IsList() {
+		db.serveListWaiters(dst)
+	}
+	return true
+}
+
+func (s *Server) SaveRDB() error {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	keyspaces, dirty := s.snapshot()
+	return s.finishSave(keyspaces, dirty)
+}
+
+// BackgroundSave snapshots the keyspace and writes it in a goroutine. It
+// returns false if a save is already running.
+func (s *Server) BackgroundSave() bool {
+	if !s.saveMu.TryLock() {
+		return false
+	}
+	keyspaces, dirty := s.snapshot()
+	go func() {
+		defer s.saveMu.Unlock()
+		if err := s.finishSave(keyspaces, dirty); err != nil {
+			fmt.Printf("Background saving error: %v\n", err)
 		}
+	}()
+	return true
+}
+
+// finishSave writes a snapshot taken when dirty changes were pending.
+// Changes made since the snapshot count towards the next save. The caller
+// must hold saveMu.
+func (s *Server) finishSave(keyspaces []map[string]DBentry, dirty int64) error {
+	if err := s.writeRDB(keyspaces); err != nil {
+		return err
 	}
+	s.dirty.Add(-dirty)
+	s.lastSave.Store(time.Now().Unix())
+	return nil
 }
 
-// Replication support removed: no propagateCommands or listenToMaster
+// snapshot copies the live keyspaces so they can be written without
+// holding any db.mu, and returns them, indexed by database, with the dirty
+// count they reflect. There is no fork to lean on, so the copy is taken
+// with every database read locked at once: strings are immutable and
+// shared, while list and stream entry slices are cloned since later pushes
+// can reuse their backing arrays.
+func (s *Server) snapshot() ([]map[string]DBentry, int64) {
+	for _, db := range s.databases {
+		db.mu.RLock()
+		defer db.mu.RUnlock()
+	}
 
-func (db *DataBase) Delete(key string) {
-	delete(db.M, key)
+	now := time.Now().UnixMilli()
+	keyspaces := make([]map[string]DBentry, len(s.databases))
+	for i, db := range s.databases {
+		keyspace := make(map[string]DBentry, len(db.M))
+		for key, entry := range db.M {
+			if entry.isExpired(now) {
+				continue
+			}
+			switch entry.dataType {
+			case ListType:
+				entry.list = slices.Clone(entry.list)
+			case StreamType:
+				entry.stream = &Stream{Entries: slices.Clone(entry.stream.Entries), LastID: entry.stream.LastID}
+			}
+			keyspace[key] = entry
+		}
+		keyspaces[i] = keyspace
+	}
+	return keyspaces, s.dirty.Load()
 }
 
-func (db *DataBase) SaveRDB() error {
-	err := os.MkdirAll(db.dir, 0755)
+func (s *Server) writeRDB(keyspaces []map[string]DBentry) error {
+	err := os.MkdirAll(s.dir, 0755)
 	if err != nil {
-		return fmt.Errorf("failed to create directory %s: %v", db.dir, err)
+		return fmt.Errorf("failed to create directory %s: %v", s.dir, err)
 	}
 
-	rdbFile := db.dir + "/" + db.dbfilename
+	rdbFile := s.dir + "/" + s.dbfilename
 	f, err := os.Create(rdbFile)
 	if err != nil {
 		return fmt.Errorf("failed to create RDB file: %v", err)
@@ -170,7 +811,6 @@ func (db *DataBase) SaveRDB() error {
 	defer f.Close()
 
 	enc := encoder.NewEncoder(f)
-	now := time.Now().UnixMilli()
 	err = enc.WriteHeader()
 	if err != nil {
 		return fmt.Errorf("failed to write header: %v", err)
@@ -190,195 +830,251 @@ func (db *DataBase) SaveRDB() error {
 		}
 	}
 
-	// Only write DB header and entries if there are actual keys
-	validKeys := 0
-	for _, entry := range db.M {
-		if entry.ttlMs == -1 || entry.ttlMs+entry.timestamp >= now {
-			validKeys++
+	for i, keyspace := range keyspaces {
+		if len(keyspace) == 0 {
+			continue
 		}
-	}
-
-	if validKeys > 0 {
-		err = enc.WriteDBHeader(0, uint64(validKeys), 0) // database 0, key count, TTL count
+		err = enc.WriteDBHeader(uint(i), uint64(len(keyspace)), 0) // database, key count, TTL count
 		if err != nil {
 			return fmt.Errorf("failed to write database header: %w", err)
 		}
 
-		for key, entry := range db.M {
-
-			if entry.ttlMs != -1 && entry.ttlMs+entry.timestamp < now {
-				continue // Skip expired keys
+		for key, entry := range keyspace {
+			if err := writeRDBEntry(enc, key, entry); err != nil {
+				return err
 			}
-			switch entry.dataType {
-			case StringType:
-				if entry.ttlMs != -1 {
-					expiry := entry.timestamp + entry.ttlMs
-					err = enc.WriteStringObject(key, []byte(entry.val), encoder.WithTTL(uint64(expiry)))
-					if err != nil {
-						return fmt.Errorf("failed to write expiry: %v", err)
-					}
-				} else {
-					err = enc.WriteStringObject(key, []byte(entry.val))
-					if err != nil {
-						return fmt.Errorf("failed to write key-value: %v", err)
-					}
-				}
-			case ListType:
-				listValues := make([][]byte, len(entry.list))
-				for i, val := range entry.list {
-					listValues[i] = []byte(val)
-				}
+		}
+	}
 
-				if entry.ttlMs != -1 {
-					expiry := entry.timestamp + entry.ttlMs
-					err = enc.WriteListObject(key, listValues, encoder.WithTTL(uint64(expiry)))
-					if err != nil {
-						return fmt.Errorf("failed to write expiry: %v", err)
-					}
-				} else {
-					err = enc.WriteListObject(key, listValues)
-					if err != nil {
-						return fmt.Errorf("failed to write list: %v", err)
-					}
-				}
+	err = enc.WriteEnd()
+	if err != nil {
+		return fmt.Errorf("failed to finalize RDB file: %v", err)
+	}
 
-			case StreamType:
-				streamData := make([][]byte, 0)
-				for _, streamEntry := range entry.stream.Entries {
-					// Format: "ID:field1=value1,field2=value2"
-					entryData := streamEntry.ID + ":"
-					fieldPairs := make([]string, 0, len(streamEntry.Fields))
-					for field, value := range streamEntry.Fields {
-						fieldPairs = append(fieldPairs, field+"="+value)
-					}
-					entryData += strings.Join(fieldPairs, ",")
-					streamData = append(streamData, []byte(entryData))
-				}
-				if entry.ttlMs != -1 {
-					expiry := entry.timestamp + entry.ttlMs
-					err = enc.WriteListObject(key, streamData, encoder.WithTTL(uint64(expiry)))
-				} else {
-					err = enc.WriteListObject(key, streamData)
-				}
-				if err != nil {
-					return fmt.Errorf("failed to write stream list: %v", err)
-				}
+	return nil
+}
 
-			}
+// writeRDBEntry encodes a single key and its value, plus its expiry if it has
+// one. Streams are stored as lists of "ID:field=value,..." strings.
+func writeRDBEntry(enc *encoder.Encoder, key string, entry DBentry) error {
+	var options []interface{}
+	if entry.ttlMs != -1 {
+		expiry := entry.timestamp + entry.ttlMs
+		options = append(options, encoder.WithTTL(uint64(expiry)))
+	}
 
+	switch entry.dataType {
+	case StringType:
+		if err := enc.WriteStringObject(key, []byte(entry.val), options...); err != nil {
+			return fmt.Errorf("failed to write key-value: %v", err)
+		}
+	case ListType:
+		listValues := make([][]byte, len(entry.list))
+		for i, val := range entry.list {
+			listValues[i] = []byte(val)
+		}
+		if err := enc.WriteListObject(key, listValues, options...); err != nil {
+			return fmt.Errorf("failed to write list: %v", err)
+		}
+	case StreamType:
+		streamData := make([][]byte, 0)
+		for _, streamEntry := range entry.stream.Entries {
+			// Format: "ID:field1=value1,field2=value2"
+			entryData := streamEntry.ID + ":"
+			fieldPairs := make([]string, 0, len(streamEntry.Fields))
+			for field, value := range streamEntry.Fields {
+				fieldPairs = append(fieldPairs, field+"="+value)
+			}
+			entryData += strings.Join(fieldPairs, ",")
+			streamData = append(streamData, []byte(entryData))
 		}
+		if err := enc.WriteListObject(key, streamData, options...); err != nil {
+			return fmt.Errorf("failed to write stream list: %v", err)
+		}
+	}
+	return nil
+}
 
+// rdbObject returns the RDB serialization of entry's value preceded by its
+// type byte, i.e. an RDB key record without the key. This is the body of a
+// DUMP payload.
+func rdbObject(entry DBentry) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := encoder.NewEncoder(&buf)
+	if err := enc.WriteHeader(); err != nil {
+		return nil, err
 	}
+	if err := enc.WriteDBHeader(0, 1, 0); err != nil {
+		return nil, err
+	}
+	start := buf.Len()
 
-	err = enc.WriteEnd()
+	entry.ttlMs = -1
+	if err := writeRDBEntry(enc, "", entry); err != nil {
+		return nil, err
+	}
+	record := buf.Bytes()[start:]
+	// Drop the empty key's length prefix that follows the type byte
+	return append([]byte{record[0]}, record[2:]...), nil
+}
+
+// serializedLength returns the number of bytes the value of entry occupies
+// in an RDB dump, excluding its type, key and expiry.
+func serializedLength(entry DBentry) (int, error) {
+	object, err := rdbObject(entry)
 	if err != nil {
-		return fmt.Errorf("failed to finalize RDB file: %v", err)
+		return 0, err
 	}
+	return len(object) - 1, nil
+}
 
-	return nil
+// Restore stores an entry decoded from a DUMP payload, expiring at the unix
+// millisecond deadline at (-1 for none). Unless replace is set, an existing
+// key is left untouched and false is returned.
+func (db *DataBase) Restore(key string, entry DBentry, at int64, replace bool) bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	now := time.Now().UnixMilli()
+	if current, exists := db.M[key]; exists && !replace && !current.isExpired(now) {
+		return false
+	}
+	db.storeLocked(key, entry, at, now)
+	return true
 }
 
 // sendEmptyRDB removed with replication
 
-func (db *DataBase) LoadRDB() error {
+func (s *Server) LoadRDB() error {
 	// Open the RDB file
-	rdbFilePath := db.dir + "/" + db.dbfilename
+	rdbFilePath := s.dir + "/" + s.dbfilename
 	rdbFile, err := os.Open(rdbFilePath)
 	if err != nil {
 		return fmt.Errorf("failed to open RDB file: %w", err)
 	}
 	defer rdbFile.Close()
 
+	if _, err := readRDBHeader(rdbFile); err != nil {
+		return err
+	}
+	if _, err := rdbFile.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewind RDB file: %w", err)
+	}
+
 	// Create decoder
 	decoder := parser.NewDecoder(rdbFile)
 
-	// Parse the RDB file
+	// Parse into separate maps so a bad file leaves the keyspaces untouched
+	loaded := make([]map[string]DBentry, len(s.databases))
+	var loadErr error
 	err = decoder.Parse(func(o parser.RedisObject) bool {
-		switch o.GetType() {
-		case parser.StringType:
-			str := o.(*parser.StringObject)
-
-			// Check if key has expiration and if it's still valid
-			if str.GetExpiration() != nil {
-				expirationTime := *str.GetExpiration()
-				if time.Now().After(expirationTime) {
-					return true // Skip expired key
-				}
-				db.M[str.Key] = DBentry{
-					dataType:  StringType,
-					val:       string(str.Value),
-					timestamp: time.Now().UnixMilli(),
-					ttlMs:     expirationTime.UnixMilli() - time.Now().UnixMilli(),
-				}
-			} else {
-				db.M[str.Key] = DBentry{
-					dataType:  StringType,
-					val:       string(str.Value),
-					timestamp: time.Now().UnixMilli(),
-					ttlMs:     -1,
-				}
+		index := o.GetDBIndex()
+		if index >= len(s.databases) {
+			loadErr = fmt.Errorf("RDB file uses database %d but only %d databases are configured", index, len(s.databases))
+			return false
+		}
+		entry, ok, err := s.entryFromObject(o)
+		if err != nil {
+			loadErr = err
+			return false
+		}
+		if ok {
+			if loaded[index] == nil {
+				loaded[index] = make(map[string]DBentry)
 			}
+			loaded[index][o.GetKey()] = entry
+		}
+		return true
+	})
 
-		case parser.ListType:
-			listObj := o.(*parser.ListObject)
+	if err == nil {
+		err = loadErr
+	}
+	if err != nil {
+		if strings.Contains(err.Error(), "unknown type flag") {
+			err = fmt.Errorf("unsupported RDB type: %w", err)
+		}
+		return fmt.Errorf("failed to parse RDB file: %w", err)
+	}
 
-			// Check expiration
-			if listObj.GetExpiration() != nil {
-				expirationTime := *listObj.GetExpiration()
-				if time.Now().After(expirationTime) {
-					return true // Skip expired key
-				}
-			}
+	for i, keyspace := range loaded {
+		db := s.databases[i]
+		db.mu.Lock()
+		for key, entry := range keyspace {
+			db.putLocked(key, entry)
+		}
+		db.mu.Unlock()
+	}
+	return nil
+}
 
-			// Check if this is actually a stream stored as a list
-			if db.isStreamData(listObj.Values) {
-				// Parse as stream
-				stream := db.parseStreamFromList(listObj.Values)
+// maxRDBVersion is the newest RDB format the decoder understands.
+const maxRDBVersion = 12
 
-				var expiresAt int64 = -1
-				if listObj.GetExpiration() != nil {
-					expiresAt = listObj.GetExpiration().UnixMilli() - time.Now().UnixMilli()
-				}
+// readRDBHeader validates the "REDIS" magic and format version at the start
+// of an RDB file and returns the version.
+func readRDBHeader(r io.Reader) (int, error) {
+	header := make([]byte, 9)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, fmt.Errorf("failed to read RDB header: %w", err)
+	}
+	if string(header[:5]) != "REDIS" {
+		return 0, errors.New("not an RDB file: bad magic")
+	}
+	version, err := strconv.Atoi(string(header[5:]))
+	if err != nil {
+		return 0, fmt.Errorf("invalid RDB version %q", header[5:])
+	}
+	if version < 1 || version > maxRDBVersion {
+		return 0, fmt.Errorf("unsupported RDB version %d (max %d)", version, maxRDBVersion)
+	}
+	return version, nil
+}
 
-				db.M[listObj.Key] = DBentry{
-					dataType:  StreamType,
-					stream:    stream,
-					timestamp: time.Now().UnixMilli(),
-					ttlMs:     expiresAt,
-				}
-			} else {
-				// Parse as regular list
-				listValues := make([]string, len(listObj.Values))
-				for i, val := range listObj.Values {
-					listValues[i] = string(val)
-				}
+// entryFromObject converts a decoded RDB object into a DBentry. ok is false
+// for objects that have already expired; err is set for value types this
+// server cannot store.
+func (s *Server) entryFromObject(o parser.RedisObject) (entry DBentry, ok bool, err error) {
+	now := time.Now()
+	entry = DBentry{timestamp: now.UnixMilli(), ttlMs: -1}
+
+	// Check if key has expiration and if it's still valid
+	if expiration := o.GetExpiration(); expiration != nil {
+		if now.After(*expiration) {
+			return DBentry{}, false, nil // Skip expired key
+		}
+		entry.ttlMs = expiration.UnixMilli() - now.UnixMilli()
+	}
 
-				var expiresAt int64 = -1
-				if listObj.GetExpiration() != nil {
-					expiresAt = listObj.GetExpiration().UnixMilli() - time.Now().UnixMilli()
-				}
+	switch o.GetType() {
+	case parser.StringType:
+		entry.dataType = StringType
+		entry.val = string(o.(*parser.StringObject).Value)
 
-				db.M[listObj.Key] = DBentry{
-					dataType:  ListType,
-					list:      listValues,
-					timestamp: time.Now().UnixMilli(),
-					ttlMs:     expiresAt,
-				}
+	case parser.ListType:
+		listObj := o.(*parser.ListObject)
+
+		// Check if this is actually a stream stored as a list
+		if s.isStreamData(listObj.Values) {
+			entry.dataType = StreamType
+			entry.stream = s.parseStreamFromList(listObj.Values)
+		} else {
+			entry.dataType = ListType
+			entry.list = make([]string, len(listObj.Values))
+			for i, val := range listObj.Values {
+				entry.list[i] = string(val)
 			}
 		}
 
-		return true
-	})
-
-	if err != nil {
-		return fmt.Errorf("failed to parse RDB file: %w", err)
+	default:
+		return DBentry{}, false, fmt.Errorf("unsupported RDB type %q for key %q", o.GetType(), o.GetKey())
 	}
 
-	return nil
+	return entry, true, nil
 }
 
 // Helper function to detect if list data is actually stream data
-func (db *DataBase) isStreamData(values [][]byte) bool {
+func (s *Server) isStreamData(values [][]byte) bool {
 	if len(values) == 0 {
 		return false
 	}
@@ -392,7 +1088,7 @@ func (db *DataBase) isStreamData(values [][]byte) bool {
 	validStreamEntries := 0
 
 	for i := 0; i < entriesToCheck; i++ {
-		if db.isValidStreamEntry(string(values[i])) {
+		if s.isValidStreamEntry(string(values[i])) {
 			validStreamEntries++
 		}
 	}
@@ -402,7 +1098,7 @@ func (db *DataBase) isStreamData(values [][]byte) bool {
 }
 
 // Helper function to validate if a single entry matches stream format
-func (db *DataBase) isValidStreamEntry(entryData string) bool {
+func (s *Server) isValidStreamEntry(entryData string) bool {
 	// Stream entries should have format: "timestamp-sequence:field1=value1,field2=value2,"
 	colonIndex := strings.Index(entryData, ":")
 	if colonIndex == -1 {
@@ -441,11 +1137,11 @@ func (db *DataBase) isValidStreamEntry(entryData string) bool {
 
 	// Validate the fields part (after colon)
 	fieldsData := entryData[colonIndex+1:]
-	return db.isValidFieldsFormat(fieldsData)
+	return s.isValidFieldsFormat(fieldsData)
 }
 
 // Helper function to validate fields format
-func (db *DataBase) isValidFieldsFormat(fieldsData string) bool {
+func (s *Server) isValidFieldsFormat(fieldsData string) bool {
 	// Remove trailing comma if present
 	fieldsData = strings.TrimSuffix(fieldsData, ",")
 
@@ -479,7 +1175,7 @@ func (db *DataBase) isValidFieldsFormat(fieldsData string) bool {
 }
 
 // Parse stream data from list format (using old StreamEntry structure)
-func (db *DataBase) parseStreamFromList(values [][]byte) *Stream {
+func (s *Server) parseStreamFromList(values [][]byte) *Stream {
 	stream := &Stream{
 		Entries: make([]StreamEntry, 0, len(values)),
 		LastID:  "",
@@ -487,7 +1183,7 @@ func (db *DataBase) parseStreamFromList(values [][]byte) *Stream {
 	}
 
 	for _, value := range values {
-		entry := db.parseStreamEntry(string(value))
+		entry := s.parseStreamEntry(string(value))
 		if entry != nil {
 			stream.Entries = append(stream.Entries, *entry)
 			stream.LastID = entry.ID
@@ -499,7 +1195,7 @@ func (db *DataBase) parseStreamFromList(values [][]byte) *Stream {
 }
 
 // Parse individual stream entry (using old StreamEntry structure)
-func (db *DataBase) parseStreamEntry(entryData string) *StreamEntry {
+func (s *Server) parseStreamEntry(entryData string) *StreamEntry {
 	// Format: "ID:field1=value1,field2=value2,"
 	colonIndex := strings.Index(entryData, ":")
 	if colonIndex == -1 {
@@ -510,7 +1206,7 @@ func (db *DataBase) parseStreamEntry(entryData string) *StreamEntry {
 	fieldsData := entryData[colonIndex+1:]
 
 	// Validate the ID format
-	if !db.isValidStreamID(id) {
+	if !s.isValidStreamID(id) {
 		return nil
 	}
 
@@ -551,7 +1247,7 @@ func (db *DataBase) parseStreamEntry(entryData string) *StreamEntry {
 }
 
 // Helper function to validate stream ID format
-func (db *DataBase) isValidStreamID(id string) bool {
+func (s *Server) isValidStreamID(id string) bool {
 	parts := strings.Split(id, "-")
 	if len(parts) != 2 {
 		return false
@@ -569,19 +1265,39 @@ func (db *DataBase) isValidStreamID(id string) bool {
 	return timestamp >= 0 && sequence >= 0
 }
 
+// liveLocked returns the entry at key, deleting it instead if it has
+// expired, so that write commands treat an expired key as missing. The
+// caller must hold db.mu for writing.
+func (db *DataBase) liveLocked(key string) (DBentry, bool) {
+	entry, exists := db.M[key]
+	if exists && entry.isExpired(time.Now().UnixMilli()) {
+		db.deleteLocked(key)
+		return DBentry{}, false
+	}
+	return entry, exists
+}
+
 func (db *DataBase) LPush(key string, values ...string) int {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
-	entry, exists := db.M[key]
+	// Each value is pushed onto the head in turn, so the last ends up first
+	pushed := make([]string, len(values))
+	for i, value := range values {
+		pushed[len(values)-1-i] = value
+	}
+
+	entry, exists := db.liveLocked(key)
 	if !exists {
 		// Create new list
-		db.M[key] = DBentry{
+		db.putLocked(key, DBentry{
 			dataType:  ListType,
-			list:      append(values, []string{}...),
+			list:      pushed,
 			timestamp: time.Now().UnixMilli(),
 			ttlMs:     -1,
-		}
+		})
+		db.dirty.Add(int64(len(values)))
+		db.serveListWaiters(key)
 		return len(values)
 	}
 
@@ -590,26 +1306,30 @@ func (db *DataBase) LPush(key string, values ...string) int {
 	}
 
 	// Prepend values to existing list
-	newList := append(values, entry.list...)
+	newList := append(pushed, entry.list...)
 	entry.list = newList
-	db.M[key] = entry
+	db.putLocked(key, entry)
+	db.dirty.Add(int64(len(values)))
 
-	return len(entry.list)
+	db.serveListWaiters(key)
+	return len(newList)
 }
 
 func (db *DataBase) RPush(key string, values ...string) int {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
-	entry, exists := db.M[key]
+	entry, exists := db.liveLocked(key)
 	if !exists {
 		// Create new list
-		db.M[key] = DBentry{
+		db.putLocked(key, DBentry{
 			dataType:  ListType,
 			list:      values,
 			timestamp: time.Now().UnixMilli(),
 			ttlMs:     -1,
-		}
+		})
+		db.dirty.Add(int64(len(values)))
+		db.serveListWaiters(key)
 		return len(values)
 	}
 
@@ -619,78 +1339,265 @@ func (db *DataBase) RPush(key string, values ...string) int {
 
 	// Append values to existing list
 	entry.list = append(entry.list, values...)
-	db.M[key] = entry
+	db.putLocked(key, entry)
 
-	return len(entry.list)
+	length := len(entry.list)
+	db.dirty.Add(int64(len(values)))
+	db.serveListWaiters(key)
+	return length
 }
 
-func (db *DataBase) LPop(key string) *string {
+func (db *DataBase) LPop(key string) (*string, error) {
 	db.mu.Lock()
 	defer db.mu.Unlock()
+	return db.popLocked(key, true)
+}
 
-	entry, exists := db.M[key]
-	if !exists || !entry.IsList() || len(entry.list) == 0 {
-		return nil
+func (db *DataBase) RPop(key string) (*string, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.popLocked(key, false)
+}
+
+// popLocked removes an element from the head (left) or tail of a list,
+// deleting the key once the list is empty. The caller must hold db.mu.
+func (db *DataBase) popLocked(key string, left bool) (*string, error) {
+	entry, exists := db.liveLocked(key)
+	if exists && !entry.IsList() {
+		return nil, ErrWrongType
+	}
+	if !exists || len(entry.list) == 0 {
+		return nil, nil
 	}
 
-	value := entry.list[0]
-	entry.list = entry.list[1:]
+	var value string
+	if left {
+		value = entry.list[0]
+		entry.list = entry.list[1:]
+	} else {
+		lastIndex := len(entry.list) - 1
+		value = entry.list[lastIndex]
+		entry.list = entry.list[:lastIndex]
+	}
 
 	if len(entry.list) == 0 {
-		delete(db.M, key)
+		db.deleteLocked(key)
 	} else {
-		db.M[key] = entry
+		db.putLocked(key, entry)
 	}
+	db.dirty.Add(1)
 
-	return &value
+	return &value, nil
 }
 
-func (db *DataBase) RPop(key string) *string {
+// LMove pops an element from src and pushes it onto dst in one step.
+func (db *DataBase) LMove(src, dst string, fromLeft, toLeft bool) (*string, error) {
 	db.mu.Lock()
 	defer db.mu.Unlock()
+	return db.moveLocked(src, dst, fromLeft, toLeft)
+}
 
-	entry, exists := db.M[key]
-	if !exists || !entry.IsList() || len(entry.list) == 0 {
-		return nil
+// moveLocked implements LMOVE with db.mu held. The destination type is
+// checked before anything is popped so a failed move changes nothing.
+func (db *DataBase) moveLocked(src, dst string, fromLeft, toLeft bool) (*string, error) {
+	if entry, exists := db.liveLocked(dst); exists && !entry.IsList() {
+		return nil, ErrWrongType
+	}
+	value, err := db.popLocked(src, fromLeft)
+	if value == nil || err != nil {
+		return value, err
 	}
 
-	lastIndex := len(entry.list) - 1
-	value := entry.list[lastIndex]
-	entry.list = entry.list[:lastIndex]
-
-	if len(entry.list) == 0 {
-		delete(db.M, key)
+	entry, exists := db.M[dst]
+	if !exists {
+		entry = DBentry{dataType: ListType, timestamp: time.Now().UnixMilli(), ttlMs: -1}
+	}
+	if toLeft {
+		entry.list = append([]string{*value}, entry.list...)
 	} else {
-		db.M[key] = entry
+		entry.list = append(entry.list, *value)
 	}
+	db.putLocked(dst, entry)
+	db.dirty.Add(1)
+	db.serveListWaiters(dst)
 
-	return &value
+	return value, nil
 }
 
-func (db *DataBase) LLen(key string) int {
-	db.mu.RLock()
-	defer db.mu.RUnlock()
+// LMPop pops up to count elements from the first non-empty list in keys.
+func (db *DataBase) LMPop(keys []string, left bool, count int) (string, []string, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for _, key := range keys {
+		entry, exists := db.liveLocked(key)
+		if !exists {
+			continue
+		}
+		if !entry.IsList() {
+			return "", nil, ErrWrongType
+		}
+		return key, db.popManyLocked(key, left, count), nil
+	}
+	return "", nil, nil
+}
 
-	entry, exists := db.M[key]
-	if !exists || !entry.IsList() {
+// popManyLocked pops up to count elements from a list known to exist. The
+// caller must hold db.mu.
+func (db *DataBase) popManyLocked(key string, left bool, count int) []string {
+	var values []string
+	for len(values) < count {
+		value, _ := db.popLocked(key, left)
+		if value == nil {
+			break
+		}
+		values = append(values, *value)
+	}
+	return values
+}
+
+// BlockOnLists serves the first non-empty list among keys, or waits until a
+// push makes one available. A negative timeout never blocks, zero blocks
+// until served. The wait ends early if cancel is closed or the server shuts
+// down; a null array is returned when nothing was served.
+func (db *DataBase) BlockOnLists(keys []string, timeout time.Duration, cancel <-chan struct{}, serve func(key string) RespData) RespData {
+	db.mu.Lock()
+	now := time.Now().UnixMilli()
+	for _, key := range keys {
+		entry, exists := db.M[key]
+		if !exists || entry.isExpired(now) {
+			continue
+		}
+		if !entry.IsList() {
+			db.mu.Unlock()
+			return RespData{Type: Error, Str: ErrWrongType.Error()}
+		}
+		if len(entry.list) > 0 {
+			result := serve(key)
+			db.mu.Unlock()
+			return result
+		}
+	}
+	if timeout < 0 {
+		db.mu.Unlock()
+		return RespData{Type: Array, IsNull: true}
+	}
+
+	waiter := &ListWaiter{
+		Keys:     keys,
+		Serve:    serve,
+		Response: make(chan RespData, 1),
+	}
+	for _, key := range keys {
+		db.listWaiters[key] = append(db.listWaiters[key], waiter)
+	}
+	db.mu.Unlock()
+
+	var timer <-chan time.Time
+	if timeout > 0 {
+		timer = time.After(timeout)
+	}
+
+	select {
+	case result := <-waiter.Response:
+		return result
+	case <-timer:
+	case <-cancel:
+	case <-db.closing:
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	// A push may have served the waiter while it was giving up
+	if waiter.served {
+		return <-waiter.Response
+	}
+	db.removeListWaiter(waiter)
+	return RespData{Type: Array, IsNull: true}
+}
+
+// serveListWaiters hands elements of key to blocked clients in the order
+// they blocked, before anyone else can see them. While serving is held the
+// key is only marked ready. The caller must hold db.mu.
+func (db *DataBase) serveListWaiters(key string) {
+	if db.listServesHeld.Load() > 0 {
+		if len(db.listWaiters[key]) > 0 {
+			db.readyLists[key] = struct{}{}
+		}
+		return
+	}
+	for len(db.listWaiters[key]) > 0 {
+		entry, exists := db.M[key]
+		if !exists || !entry.IsList() || len(entry.list) == 0 {
+			return
+		}
+		waiter := db.listWaiters[key][0]
+		db.removeListWaiter(waiter)
+		waiter.served = true
+		waiter.Response <- waiter.Serve(key)
+	}
+}
+
+// holdListServes stops pushes from serving blocked clients until the
+// matching releaseListServes, as Redis serves them only once the command
+// that pushed has finished. Scripts and EXEC hold it while they run.
+func (s *Server) holdListServes() {
+	s.listServesHeld.Add(1)
+}
+
+// releaseListServes ends a holdListServes. The last one to end serves the
+// clients blocked on lists that were pushed to meanwhile.
+func (s *Server) releaseListServes() {
+	if s.listServesHeld.Add(-1) > 0 {
+		return
+	}
+	for _, db := range s.databases {
+		db.mu.Lock()
+		ready := db.readyLists
+		db.readyLists = make(map[string]struct{})
+		for key := range ready {
+			db.serveListWaiters(key)
+		}
+		db.mu.Unlock()
+	}
+}
+
+func (db *DataBase) removeListWaiter(waiter *ListWaiter) {
+	for _, key := range waiter.Keys {
+		var remaining []*ListWaiter
+		for _, w := range db.listWaiters[key] {
+			if w != waiter {
+				remaining = append(remaining, w)
+			}
+		}
+		if len(remaining) == 0 {
+			delete(db.listWaiters, key)
+		} else {
+			db.listWaiters[key] = remaining
+		}
+	}
+}
+
+func (db *DataBase) LLen(key string) int {
+	entry, ok, _ := db.GetTyped(key, ListType)
+	if !ok {
 		return 0
 	}
 
 	return len(entry.list)
 }
 
-func (db *DataBase) LRange(key string, start, stop int) []string {
-	db.mu.RLock()
-	defer db.mu.RUnlock()
-
-	entry, exists := db.M[key]
-	if !exists || !entry.IsList() {
-		return []string{}
+func (db *DataBase) LRange(key string, start, stop int) ([]string, error) {
+	entry, ok, wrongType := db.GetTyped(key, ListType)
+	if wrongType {
+		return nil, ErrWrongType
+	}
+	if !ok {
+		return []string{}, nil
 	}
 
 	listLen := len(entry.list)
 	if listLen == 0 {
-		return []string{}
+		return []string{}, nil
 	}
 
 	// Handle negative indices
@@ -709,10 +1616,10 @@ func (db *DataBase) LRange(key string, start, stop int) []string {
 		stop = listLen - 1
 	}
 	if start > stop {
-		return []string{}
+		return []string{}, nil
 	}
 
-	return entry.list[start : stop+1]
+	return entry.list[start : stop+1], nil
 }
 
 func (entry *DBentry) IsStream() bool {
@@ -726,27 +1633,30 @@ func (entry *DBentry) GetStreamValue() *Stream {
 	return nil
 }
 
-func (db *DataBase) XAdd(key string, id string, fields map[string]string) (string, error) {
+// XAdd appends an entry to the stream at key and returns its ID. When
+// noMkStream is set and the key does not exist, nothing is created and an
+// empty ID is returned.
+func (db *DataBase) XAdd(key string, id string, fields map[string]string, noMkStream bool) (string, error) {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
-	// Generate ID
-	generatedID := generateStreamID(id)
-
 	entry, exists := db.M[key]
 	if !exists {
+		if noMkStream {
+			return "", nil
+		}
 		// Create new stream
 		stream := &Stream{
 			Entries: []StreamEntry{},
 			LastID:  "",
 			Waiters: []*StreamWaiter{},
 		}
-		db.M[key] = DBentry{
+		db.putLocked(key, DBentry{
 			dataType:  StreamType,
 			stream:    stream,
 			timestamp: time.Now().UnixMilli(),
 			ttlMs:     -1,
-		}
+		})
 		entry = db.M[key]
 	}
 
@@ -755,6 +1665,7 @@ func (db *DataBase) XAdd(key string, id string, fields map[string]string) (strin
 	}
 
 	stream := entry.stream
+	generatedID := generateStreamID(id, stream.LastID)
 
 	// Validate ID is greater than last ID
 	if stream.LastID != "" && compareStreamIDs(generatedID, stream.LastID) <= 0 {
@@ -770,7 +1681,8 @@ func (db *DataBase) XAdd(key string, id string, fields map[string]string) (strin
 	stream.Entries = append(stream.Entries, streamEntry)
 	stream.LastID = generatedID
 
-	db.M[key] = entry
+	db.putLocked(key, entry)
+	db.dirty.Add(1)
 
 	// Notify waiting clients
 	go db.notifyWaiters(key, streamEntry)
@@ -821,27 +1733,26 @@ func (db *DataBase) notifyWaiters(key string, newEntry StreamEntry) {
 
 // Get stream length
 func (db *DataBase) XLen(key string) int64 {
-	db.mu.RLock()
-	defer db.mu.RUnlock()
-
-	entry, exists := db.M[key]
-	if !exists || !entry.IsStream() {
+	entry, ok, _ := db.GetTyped(key, StreamType)
+	if !ok {
 		return 0
 	}
 
+	// XADD appends to the shared Stream under db.mu
+	db.mu.RLock()
+	defer db.mu.RUnlock()
 	return int64(len(entry.stream.Entries))
 }
 
 // Read range of entries
 func (db *DataBase) XRange(key string, start, end string, count int) []StreamEntry {
-	db.mu.RLock()
-	defer db.mu.RUnlock()
-
-	entry, exists := db.M[key]
-	if !exists || !entry.IsStream() {
+	entry, ok, _ := db.GetTyped(key, StreamType)
+	if !ok {
 		return []StreamEntry{}
 	}
 
+	db.mu.RLock()
+	defer db.mu.RUnlock()
 	stream := entry.stream
 	var results []StreamEntry
 
@@ -915,7 +1826,14 @@ func (db *DataBase) XRead(keys []string, ids []string, count int) map[string][]S
 	return result
 }
 
-func (db *DataBase) XReadBlocking(keys []string, ids []string, count int, blockMs int64) (map[string][]StreamEntry, error) {
+// Shutdown releases every blocked client so its goroutine can finish.
+func (s *Server) Shutdown() {
+	s.closeOnce.Do(func() { close(s.closing) })
+}
+
+// XReadBlocking waits up to blockMs for new entries. It gives up early when
+// cancel is closed (the client disconnected) or the server shuts down.
+func (db *DataBase) XReadBlocking(keys []string, ids []string, count int, blockMs int64, cancel <-chan struct{}) (map[string][]StreamEntry, error) {
 	// First try non-blocking read
 	result := db.XRead(keys, ids, count)
 	if len(result) > 0 {
@@ -959,6 +1877,12 @@ func (db *DataBase) XReadBlocking(keys []string, ids []string, count int, blockM
 		// Remove waiter on timeout
 		db.removeWaiter(waiter, keys)
 		return map[string][]StreamEntry{}, nil
+	case <-cancel:
+		db.removeWaiter(waiter, keys)
+		return map[string][]StreamEntry{}, nil
+	case <-db.closing:
+		db.removeWaiter(waiter, keys)
+		return map[string][]StreamEntry{}, nil
 	}
 }
 
@@ -0,0 +1,598 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// infoField returns the value INFO reports for field.
+func infoField(t *testing.T, c *ClientConn, field string) string {
+	t.Helper()
+	for _, line := range strings.Split(run(c, "INFO").Str, "\r\n") {
+		if value, ok := strings.CutPrefix(line, field+":"); ok {
+			return value
+		}
+	}
+	t.Fatalf("INFO has no %s field", field)
+	return ""
+}
+
+func TestKeyspaceHitsAndMisses(t *testing.T) {
+	c := newTestClient(t)
+	run(c, "SET", "k", "v")
+
+	expectReply(t, c, bulkReply("v"), "GET", "k")
+	expectReply(t, c, nullReply(), "GET", "missing")
+	if hits := infoField(t, c, "keyspace_hits"); hits != "1" {
+		t.Errorf("keyspace_hits = %s, want 1", hits)
+	}
+	if misses := infoField(t, c, "keyspace_misses"); misses != "1" {
+		t.Errorf("keyspace_misses = %s, want 1", misses)
+	}
+
+	// Writes are not lookups
+	run(c, "SET", "k", "w")
+	run(c, "DELETE", "missing")
+	if hits := infoField(t, c, "keyspace_hits"); hits != "1" {
+		t.Errorf("keyspace_hits after writes = %s, want 1", hits)
+	}
+	if misses := infoField(t, c, "keyspace_misses"); misses != "1" {
+		t.Errorf("keyspace_misses after writes = %s, want 1", misses)
+	}
+}
+
+// TestExpiredKeysAreMisses checks that reads of a list or stream past its
+// deadline see no key and count a miss rather than a hit.
+func TestExpiredKeysAreMisses(t *testing.T) {
+	c := newTestClient(t)
+	run(c, "RPUSH", "l", "a", "b")
+	run(c, "XADD", "s", "1-1", "f", "v")
+	run(c, "PEXPIRE", "l", "1")
+	run(c, "PEXPIRE", "s", "1")
+	time.Sleep(10 * time.Millisecond)
+
+	expectReply(t, c, intReply(0), "LLEN", "l")
+	expectReply(t, c, intReply(0), "XLEN", "s")
+	if got := run(c, "XRANGE", "s", "-", "+"); len(got.Array) != 0 {
+		t.Errorf("XRANGE of an expired stream: got %+v", got)
+	}
+	if hits := infoField(t, c, "keyspace_hits"); hits != "0" {
+		t.Errorf("keyspace_hits = %s, want 0", hits)
+	}
+	if misses := infoField(t, c, "keyspace_misses"); misses != "3" {
+		t.Errorf("keyspace_misses = %s, want 3", misses)
+	}
+	expectReply(t, c, intReply(0), "DBSIZE")
+}
+
+// TestConcurrentIncr checks no increment is lost; run it with -race to
+// catch unsynchronised access too.
+func TestConcurrentIncr(t *testing.T) {
+	newTestClient(t)
+	var wg sync.WaitGroup
+	for range 100 {
+		c := connectTestClient(t)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				run(c, "INCR", "counter")
+			}
+		}()
+	}
+	wg.Wait()
+
+	expectReply(t, connectTestClient(t), bulkReply("10000"), "GET", "counter")
+}
+
+// TestConcurrentAccess mixes reads and writes of strings, lists and streams
+// from many clients; run it with -race to check they are synchronised.
+func TestConcurrentAccess(t *testing.T) {
+	newTestClient(t)
+	var wg sync.WaitGroup
+	for i := range 20 {
+		c := connectTestClient(t)
+		key := fmt.Sprintf("key%d", i%4)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range 200 {
+				run(c, "SET", key, strconv.Itoa(j))
+				run(c, "GET", key)
+				run(c, "INCR", "counter")
+				run(c, "RPUSH", "list", key)
+				run(c, "LPOP", "list")
+				run(c, "XADD", "stream", "*", "f", key)
+				run(c, "XRANGE", "stream", "-", "+", "COUNT", "5")
+				run(c, "TYPE", key)
+				run(c, "DELETE", key)
+			}
+		}()
+	}
+	wg.Wait()
+
+	c := connectTestClient(t)
+	expectReply(t, c, bulkReply("4000"), "GET", "counter")
+	expectReply(t, c, intReply(0), "LLEN", "list")
+	expectReply(t, c, intReply(4000), "XLEN", "stream")
+}
+
+func TestType(t *testing.T) {
+	c := newTestClient(t)
+	run(c, "SET", "s", "v")
+	run(c, "RPUSH", "l", "a")
+	run(c, "XADD", "x", "1-1", "f", "v")
+	run(c, "SET", "gone", "v", "PX", "1")
+	for key, want := range map[string]string{"s": "string", "l": "list", "x": "stream", "missing": "none"} {
+		expectReply(t, c, RespData{Type: SimpleString, Str: want}, "TYPE", key)
+	}
+	time.Sleep(10 * time.Millisecond)
+	expectReply(t, c, RespData{Type: SimpleString, Str: "none"}, "TYPE", "gone")
+	expectReply(t, c, intReply(3), "DBSIZE")
+}
+
+func TestGetTyped(t *testing.T) {
+	c := newTestClient(t)
+	db := c.db
+	run(c, "SET", "str", "v")
+	run(c, "RPUSH", "list", "a")
+	run(c, "SET", "gone", "v", "PX", "1")
+	time.Sleep(5 * time.Millisecond)
+
+	tests := []struct {
+		key       string
+		want      DataType
+		ok        bool
+		wrongType bool
+	}{
+		{"str", StringType, true, false},
+		{"str", ListType, false, true},
+		{"list", ListType, true, false},
+		{"list", StringType, false, true},
+		{"missing", StringType, false, false},
+		{"gone", StringType, false, false},
+	}
+	for _, tt := range tests {
+		entry, ok, wrongType := db.GetTyped(tt.key, tt.want)
+		if ok != tt.ok || wrongType != tt.wrongType {
+			t.Errorf("GetTyped(%s, %d): ok=%v wrongType=%v, want ok=%v wrongType=%v",
+				tt.key, tt.want, ok, wrongType, tt.ok, tt.wrongType)
+		}
+		if ok && entry.dataType != tt.want {
+			t.Errorf("GetTyped(%s, %d) returned a %d", tt.key, tt.want, entry.dataType)
+		}
+	}
+	// The expired key was deleted by the lookup
+	if _, ok := db.Peek("gone"); ok || db.Size() != 2 {
+		t.Errorf("expired key still stored: size %d", db.Size())
+	}
+}
+
+// TestConcurrentMultiKeyWrites runs MSET and RENAME over overlapping keys;
+// run it with -race. It must finish without deadlocking and leave only
+// values that were written.
+func TestConcurrentMultiKeyWrites(t *testing.T) {
+	newTestClient(t)
+	keys := []string{"a", "b", "c", "d"}
+	var wg sync.WaitGroup
+	for i := range 10 {
+		c := connectTestClient(t)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range 200 {
+				from, to := keys[(i+j)%4], keys[(i+j+1)%4]
+				if i%2 == 0 {
+					run(c, "MSET", from, "x", to, "y")
+				} else {
+					run(c, "RENAME", from, to)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	c := connectTestClient(t)
+	if n := run(c, "DBSIZE").Num; n < 1 || n > 4 {
+		t.Errorf("DBSIZE = %d, want 1..4", n)
+	}
+	for _, v := range run(c, "MGET", "a", "b", "c", "d").Array {
+		if !v.IsNull && v.Str != "x" && v.Str != "y" {
+			t.Errorf("unexpected value %q", v.Str)
+		}
+	}
+}
+
+func TestMSetNX(t *testing.T) {
+	c := newTestClient(t)
+	expectReply(t, c, intReply(1), "MSETNX", "a", "1", "b", "2")
+	expectReply(t, c, bulkReply("1"), "GET", "a")
+	expectReply(t, c, bulkReply("2"), "GET", "b")
+
+	// One existing key stops all of them being set
+	expectReply(t, c, intReply(0), "MSETNX", "c", "3", "b", "new")
+	expectReply(t, c, nullReply(), "GET", "c")
+	expectReply(t, c, bulkReply("2"), "GET", "b")
+
+	// Any type counts as existing
+	run(c, "RPUSH", "list", "x")
+	expectReply(t, c, intReply(0), "MSETNX", "list", "v", "d", "4")
+	expectReply(t, c, nullReply(), "GET", "d")
+	expectReply(t, c, errorReply("ERR wrong number of arguments for 'msetnx' command"), "MSETNX", "a", "1", "b")
+}
+
+func TestRunID(t *testing.T) {
+	c := newTestClient(t)
+	id := infoField(t, c, "run_id")
+	if len(id) != 40 || strings.Trim(id, "0123456789abcdef") != "" {
+		t.Errorf("run_id = %q, want 40 hex characters", id)
+	}
+	if again := infoField(t, c, "run_id"); again != id {
+		t.Errorf("run_id changed from %s to %s", id, again)
+	}
+	expectReply(t, c, bulkReply(id), "CLUSTER", "MYID")
+}
+
+func TestLoadRDBRejectsUnsupported(t *testing.T) {
+	tests := []struct {
+		name, file, wantErr string
+	}{
+		{"unknown type", "REDIS0011\xfe\x00\x00\x01a\x01v\x63\x01b\x01w\xff", "unsupported RDB type"},
+		{"newer version", "REDIS0099\xff", "unsupported RDB version 99"},
+		{"bad magic", "RADIS0011\xff", "not an RDB file"},
+		{"short header", "REDIS", "failed to read RDB header"},
+	}
+	for _, tt := range tests {
+		c := newTestClient(t)
+		run(c, "SET", "existing", "1")
+		if err := os.WriteFile(filepath.Join(server.dir, server.dbfilename), []byte(tt.file), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		err := server.LoadRDB()
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: got error %v, want %q", tt.name, err, tt.wantErr)
+		}
+		// Nothing from the file was loaded
+		expectReply(t, c, intReply(1), "DBSIZE")
+	}
+}
+
+func TestIncrWrongType(t *testing.T) {
+	c := newTestClient(t)
+	run(c, "LPUSH", "list", "1")
+	run(c, "XADD", "stream", "1-1", "f", "1")
+	wrongType := errorReply("WRONGTYPE Operation against a key holding the wrong kind of value")
+	for _, key := range []string{"list", "stream"} {
+		expectReply(t, c, wrongType, "INCR", key)
+		expectReply(t, c, wrongType, "INCRBY", key, "2")
+		expectReply(t, c, wrongType, "DECR", key)
+		expectReply(t, c, wrongType, "INCRBYFLOAT", key, "1.5")
+	}
+	// The values are untouched
+	expectReply(t, c, intReply(1), "LLEN", "list")
+	expectReply(t, c, intReply(1), "XLEN", "stream")
+}
+
+func TestCopyStreamAndTTL(t *testing.T) {
+	c := newTestClient(t)
+	run(c, "XADD", "s", "1-1", "f", "v")
+	run(c, "XGROUP", "CREATE", "s", "g", "0")
+	run(c, "PEXPIRE", "s", "100000")
+	expectReply(t, c, intReply(1), "COPY", "s", "copy")
+
+	if ttl := run(c, "PTTL", "copy").Num; ttl <= 99000 || ttl > 100000 {
+		t.Errorf("PTTL of the copy = %d, want the source's TTL", ttl)
+	}
+
+	// Mutating the source's entries or groups leaves the copy alone
+	run(c, "XADD", "s", "1-2", "f", "v")
+	run(c, "XREADGROUP", "GROUP", "g", "alice", "STREAMS", "s", ">")
+	expectReply(t, c, intReply(1), "XLEN", "copy")
+	groups := run(c, "XINFO", "GROUPS", "copy")
+	if len(groups.Array) != 1 || groups.Array[0].Array[3].Num != 0 || groups.Array[0].Array[7].Str != "0-0" {
+		t.Errorf("XINFO GROUPS copy: got %+v, want g untouched", groups)
+	}
+
+	// A copy of a key without a TTL has none
+	run(c, "SET", "k", "v")
+	run(c, "COPY", "k", "k2")
+	expectReply(t, c, intReply(-1), "TTL", "k2")
+}
+
+func TestSelectBounds(t *testing.T) {
+	c := newTestClient(t)
+	outOfRange := errorReply("ERR DB index is out of range")
+	expectReply(t, c, okReply(), "SELECT", "15")
+	expectReply(t, c, outOfRange, "SELECT", "16")
+	expectReply(t, c, outOfRange, "SELECT", "-1")
+	expectReply(t, c, errorReply("ERR value is not an integer or out of range"), "SELECT", "one")
+
+	server = NewServer(t.TempDir(), "dump.rdb", "6379", 4)
+	c = connectTestClient(t)
+	expectReply(t, c, okReply(), "SELECT", "3")
+	expectReply(t, c, outOfRange, "SELECT", "4")
+	expectReply(t, c, outOfRange, "SELECT", "5")
+	expectReply(t, c, RespData{Type: Array, Array: []RespData{bulkReply("databases"), bulkReply("4")}},
+		"CONFIG", "GET", "databases")
+}
+
+func TestIncrByFloat(t *testing.T) {
+	c := newTestClient(t)
+	expectReply(t, c, bulkReply("10.5"), "INCRBYFLOAT", "f", "10.5")
+	expectReply(t, c, bulkReply("10.6"), "INCRBYFLOAT", "f", "0.1")
+	expectReply(t, c, bulkReply("5.6"), "INCRBYFLOAT", "f", "-5")
+	expectReply(t, c, bulkReply("5.6"), "GET", "f")
+
+	run(c, "SET", "g", "3.0")
+	expectReply(t, c, bulkReply("4"), "INCRBYFLOAT", "g", "1.000000000000000005")
+	run(c, "SET", "e", "5.0e3")
+	expectReply(t, c, bulkReply("5200"), "INCRBYFLOAT", "e", "2.0e2")
+
+	notFloat := errorReply("ERR value is not a valid float")
+	expectReply(t, c, notFloat, "INCRBYFLOAT", "f", "abc")
+	expectReply(t, c, notFloat, "INCRBYFLOAT", "f", "nan")
+	run(c, "SET", "s", "text")
+	expectReply(t, c, notFloat, "INCRBYFLOAT", "s", "1")
+
+	nanOrInf := errorReply("ERR increment would produce NaN or Infinity")
+	expectReply(t, c, nanOrInf, "INCRBYFLOAT", "f", "inf")
+	run(c, "SET", "big", "1.7e308")
+	expectReply(t, c, nanOrInf, "INCRBYFLOAT", "big", "1.7e308")
+	expectReply(t, c, bulkReply("5.6"), "GET", "f")
+}
+
+func TestGetRange(t *testing.T) {
+	c := newTestClient(t)
+	run(c, "SET", "k", "This is a string")
+	tests := []struct {
+		start, end, want string
+	}{
+		{"0", "3", "This"},
+		{"-3", "-1", "ing"},
+		{"0", "-1", "This is a string"},
+		{"10", "100", "string"},
+		{"-100", "3", "This"},
+		{"5", "3", ""},
+		{"-1", "-5", ""},
+		{"16", "20", ""},
+	}
+	for _, tt := range tests {
+		expectReply(t, c, bulkReply(tt.want), "GETRANGE", "k", tt.start, tt.end)
+	}
+	expectReply(t, c, bulkReply(""), "GETRANGE", "missing", "0", "-1")
+	expectReply(t, c, errorReply("ERR value is not an integer or out of range"), "GETRANGE", "k", "a", "1")
+	run(c, "RPUSH", "list", "a")
+	expectReply(t, c, errorReply("WRONGTYPE Operation against a key holding the wrong kind of value"), "GETRANGE", "list", "0", "1")
+}
+
+func TestMGet(t *testing.T) {
+	c := newTestClient(t)
+	run(c, "SET", "a", "1")
+	run(c, "SET", "b", "2")
+	run(c, "RPUSH", "list", "x")
+	run(c, "SET", "gone", "v", "PX", "1")
+	time.Sleep(5 * time.Millisecond)
+
+	got := run(c, "MGET", "a", "missing", "list", "b", "gone", "a")
+	want := []RespData{bulkReply("1"), nullReply(), nullReply(), bulkReply("2"), nullReply(), bulkReply("1")}
+	if got.Type != Array || len(got.Array) != len(want) {
+		t.Fatalf("MGET: got %+v", got)
+	}
+	for i := range want {
+		if got.Array[i].Type != want[i].Type || got.Array[i].IsNull != want[i].IsNull || got.Array[i].Str != want[i].Str {
+			t.Errorf("MGET element %d: got %+v, want %+v", i, got.Array[i], want[i])
+		}
+	}
+	expectReply(t, c, errorReply("ERR wrong number of arguments for 'mget' command"), "MGET")
+}
+
+func TestMSet(t *testing.T) {
+	c := newTestClient(t)
+	run(c, "SET", "a", "old", "EX", "100")
+	expectReply(t, c, okReply(), "MSET", "a", "1", "b", "2", "c", "3")
+	expectReply(t, c, bulkReply("1"), "GET", "a")
+	expectReply(t, c, bulkReply("3"), "GET", "c")
+	// Overwriting clears the TTL
+	expectReply(t, c, intReply(-1), "TTL", "a")
+
+	wrongArgs := errorReply("ERR wrong number of arguments for 'mset' command")
+	expectReply(t, c, wrongArgs, "MSET", "a")
+	expectReply(t, c, wrongArgs, "MSET", "a", "x", "b")
+	expectReply(t, c, bulkReply("1"), "GET", "a")
+
+	// The last value given for a repeated key wins
+	expectReply(t, c, okReply(), "MSET", "d", "1", "d", "2")
+	expectReply(t, c, bulkReply("2"), "GET", "d")
+}
+
+func TestExists(t *testing.T) {
+	c := newTestClient(t)
+	run(c, "SET", "a", "1")
+	run(c, "RPUSH", "list", "x")
+	run(c, "SET", "gone", "v", "PX", "1")
+	time.Sleep(5 * time.Millisecond)
+
+	expectReply(t, c, intReply(1), "EXISTS", "a")
+	expectReply(t, c, intReply(0), "EXISTS", "missing")
+	expectReply(t, c, intReply(2), "EXISTS", "a", "a")
+	expectReply(t, c, intReply(2), "EXISTS", "a", "missing", "list", "gone")
+	expectReply(t, c, intReply(0), "EXISTS", "gone")
+	// The expired key was removed when it was looked up
+	expectReply(t, c, intReply(2), "DBSIZE")
+	expectReply(t, c, errorReply("ERR wrong number of arguments for 'exists' command"), "EXISTS")
+}
+
+func TestStringGrowthLimit(t *testing.T) {
+	c := newTestClient(t)
+	tooLong := errorReply("ERR string exceeds maximum allowed size (proto-max-bulk-len)")
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	expectReply(t, c, tooLong, "SETRANGE", "k", "536870911", "ab")
+	runtime.ReadMemStats(&after)
+	if grown := after.TotalAlloc - before.TotalAlloc; grown > 1<<20 {
+		t.Errorf("rejected SETRANGE allocated %d bytes", grown)
+	}
+	expectReply(t, c, intReply(0), "EXISTS", "k")
+
+	run(c, "CONFIG", "SET", "proto-max-bulk-len", "100")
+	expectReply(t, c, intReply(100), "SETRANGE", "k", "99", "x")
+	expectReply(t, c, tooLong, "SETRANGE", "k", "100", "x")
+	expectReply(t, c, tooLong, "APPEND", "k", "y")
+	expectReply(t, c, intReply(100), "SETRANGE", "k", "0", "")
+	expectReply(t, c, errorReply("ERR offset is out of range"), "SETRANGE", "k", "-1", "x")
+}
+
+func TestDeleteMultipleKeys(t *testing.T) {
+	c := newTestClient(t)
+	run(c, "SET", "a", "1")
+	run(c, "SET", "b", "2")
+	run(c, "RPUSH", "list", "x")
+	run(c, "SET", "gone", "v", "PX", "1")
+	time.Sleep(5 * time.Millisecond)
+
+	expectReply(t, c, intReply(3), "DELETE", "a", "missing", "b", "list", "gone")
+	expectReply(t, c, intReply(0), "DBSIZE")
+	expectReply(t, c, intReply(0), "DELETE", "a")
+
+	// A key named twice is only deleted once
+	run(c, "SET", "a", "1")
+	expectReply(t, c, intReply(1), "DELETE", "a", "a")
+	expectReply(t, c, errorReply("ERR wrong number of arguments for 'delete' command"), "DELETE")
+}
+
+func TestRename(t *testing.T) {
+	c := newTestClient(t)
+	expectReply(t, c, errorReply("ERR no such key"), "RENAME", "missing", "dst")
+	expectReply(t, c, errorReply("ERR no such key"), "RENAMENX", "missing", "dst")
+
+	// The value keeps its type and remaining TTL
+	run(c, "RPUSH", "src", "a", "b")
+	run(c, "EXPIRE", "src", "100")
+	expectReply(t, c, okReply(), "RENAME", "src", "dst")
+	expectReply(t, c, intReply(0), "EXISTS", "src")
+	expectReply(t, c, intReply(2), "LLEN", "dst")
+	expectReply(t, c, intReply(100), "TTL", "dst")
+
+	// RENAME overwrites the destination, which takes the source's TTL
+	run(c, "SET", "other", "v", "EX", "50")
+	expectReply(t, c, okReply(), "RENAME", "other", "dst")
+	expectReply(t, c, bulkReply("v"), "GET", "dst")
+	expectReply(t, c, intReply(50), "TTL", "dst")
+	run(c, "SET", "plain", "p")
+	expectReply(t, c, okReply(), "RENAME", "plain", "dst")
+	expectReply(t, c, intReply(-1), "TTL", "dst")
+
+	// RENAMENX leaves an existing destination alone
+	run(c, "SET", "a", "1")
+	expectReply(t, c, intReply(0), "RENAMENX", "a", "dst")
+	expectReply(t, c, bulkReply("p"), "GET", "dst")
+	expectReply(t, c, bulkReply("1"), "GET", "a")
+	expectReply(t, c, intReply(1), "RENAMENX", "a", "b")
+	expectReply(t, c, bulkReply("1"), "GET", "b")
+	expectReply(t, c, intReply(0), "EXISTS", "a")
+
+	// Renaming a key to itself keeps it
+	expectReply(t, c, okReply(), "RENAME", "b", "b")
+	expectReply(t, c, intReply(0), "RENAMENX", "b", "b")
+	expectReply(t, c, bulkReply("1"), "GET", "b")
+}
+
+func TestCopyList(t *testing.T) {
+	c := newTestClient(t)
+	expectReply(t, c, intReply(0), "COPY", "missing", "dst")
+	expectReply(t, c, intReply(0), "EXISTS", "dst")
+
+	run(c, "RPUSH", "src", "a", "b")
+	expectReply(t, c, intReply(1), "COPY", "src", "dst")
+	// Mutating the source leaves the copy alone
+	run(c, "RPUSH", "src", "c")
+	run(c, "LPOP", "src")
+	got := run(c, "LRANGE", "dst", "0", "-1")
+	if len(got.Array) != 2 || got.Array[0].Str != "a" || got.Array[1].Str != "b" {
+		t.Errorf("copy after mutating the source: got %+v, want [a b]", got)
+	}
+
+	// An existing destination needs REPLACE
+	run(c, "SET", "taken", "v")
+	expectReply(t, c, intReply(0), "COPY", "src", "taken")
+	expectReply(t, c, bulkReply("v"), "GET", "taken")
+	expectReply(t, c, intReply(1), "COPY", "src", "taken", "REPLACE")
+	expectReply(t, c, intReply(2), "LLEN", "taken")
+
+	expectReply(t, c, errorReply("ERR source and destination objects are the same"), "COPY", "src", "src")
+	expectReply(t, c, errorReply("ERR syntax error"), "COPY", "src", "x", "NOW")
+}
+
+func TestFlushDB(t *testing.T) {
+	c := newTestClient(t)
+	run(c, "SET", "a", "1")
+	run(c, "SET", "b", "2", "EX", "100")
+	run(c, "RPUSH", "l", "x")
+	run(c, "SELECT", "1")
+	run(c, "SET", "other", "v")
+	run(c, "SELECT", "0")
+
+	expectReply(t, c, intReply(3), "DBSIZE")
+	expectReply(t, c, okReply(), "FLUSHDB")
+	expectReply(t, c, intReply(0), "DBSIZE")
+	expectReply(t, c, nullReply(), "GET", "a")
+	expectReply(t, c, intReply(-2), "TTL", "b")
+	expectReply(t, c, intReply(0), "LLEN", "l")
+
+	// Only the selected database is flushed
+	run(c, "SELECT", "1")
+	expectReply(t, c, bulkReply("v"), "GET", "other")
+	expectReply(t, c, okReply(), "FLUSHDB", "ASYNC")
+	expectReply(t, c, intReply(0), "DBSIZE")
+
+	expectReply(t, c, okReply(), "FLUSHDB", "sync")
+	expectReply(t, c, errorReply("ERR syntax error"), "FLUSHDB", "LATER")
+	expectReply(t, c, errorReply("ERR syntax error"), "FLUSHDB", "ASYNC", "SYNC")
+}
+
+func TestSwapDB(t *testing.T) {
+	c0 := newTestClient(t)
+	c1 := connectTestClient(t)
+	run(c1, "SELECT", "1")
+	run(c0, "SET", "zero", "0")
+	run(c0, "RPUSH", "l", "a")
+	run(c1, "SET", "one", "1", "EX", "100")
+
+	expectReply(t, c0, okReply(), "SWAPDB", "0", "1")
+	expectReply(t, c0, bulkReply("1"), "GET", "one")
+	expectReply(t, c0, intReply(100), "TTL", "one")
+	expectReply(t, c0, intReply(0), "EXISTS", "zero", "l")
+	expectReply(t, c1, bulkReply("0"), "GET", "zero")
+	expectReply(t, c1, intReply(1), "LLEN", "l")
+	expectReply(t, c1, intReply(0), "EXISTS", "one")
+	expectReply(t, c1, okReply(), "SWAPDB", "1", "1")
+	expectReply(t, c1, intReply(2), "DBSIZE")
+
+	expectReply(t, c0, errorReply("ERR DB index is out of range"), "SWAPDB", "0", "16")
+	expectReply(t, c0, errorReply("ERR DB index is out of range"), "SWAPDB", "-1", "0")
+	expectReply(t, c0, errorReply("ERR invalid first DB index"), "SWAPDB", "x", "0")
+	expectReply(t, c0, errorReply("ERR invalid second DB index"), "SWAPDB", "0", "y")
+
+	// A client blocked on a list is served by the list swapped in
+	run(c1, "SELECT", "3")
+	run(c1, "RPUSH", "q", "x")
+	blocked := connectTestClient(t)
+	run(blocked, "SELECT", "2")
+	reply := make(chan RespData, 1)
+	go func() { reply <- run(blocked, "BLPOP", "q", "0") }()
+	waitFor(t, "BLPOP to block", func() bool { return listWaiterCount(blocked.db, "q") == 1 })
+	expectReply(t, c0, okReply(), "SWAPDB", "2", "3")
+	got := <-reply
+	if len(got.Array) != 2 || got.Array[0].Str != "q" || got.Array[1].Str != "x" {
+		t.Errorf("BLPOP: got %+v, want [q x]", got)
+	}
+	expectReply(t, c1, intReply(0), "EXISTS", "q")
+}
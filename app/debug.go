@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func handleDebugCommand(cmd Command, db *DataBase) RespData {
+	if len(cmd.args) < 1 {
+		return RespData{Type: Error, Str: "ERR wrong number of arguments for 'debug' command"}
+	}
+
+	switch strings.ToLower(cmd.args[0]) {
+	case "object":
+		return handleDebugObject(cmd.args[1:], db)
+	case "sort-replies":
+		return handleDebugSortReplies(cmd.args[1:])
+	case "sleep":
+		return handleDebugSleep(cmd.args[1:])
+	default:
+		return RespData{Type: Error, Str: "ERR unknown subcommand '" + cmd.args[0] + "'. Try DEBUG HELP."}
+	}
+}
+
+// handleDebugSleep pauses for the given number of seconds. Unlike Redis,
+// where the whole server stalls, only the calling connection waits: the
+// command runs outside commandMu and holds no db lock while sleeping.
+func handleDebugSleep(args []string) RespData {
+	if len(args) != 1 {
+		return RespData{Type: Error, Str: "ERR wrong number of arguments for 'debug|sleep' command"}
+	}
+
+	seconds, err := strconv.ParseFloat(args[0], 64)
+	if err != nil || seconds < 0 || math.IsNaN(seconds) || math.IsInf(seconds, 0) {
+		return RespData{Type: Error, Str: "ERR value is not a valid float"}
+	}
+	time.Sleep(time.Duration(seconds * float64(time.Second)))
+	return RespData{Type: SimpleString, Str: "OK"}
+}
+
+func handleDebugObject(args []string, db *DataBase) RespData {
+	if len(args) != 1 {
+		return RespData{Type: Error, Str: "ERR wrong number of arguments for 'debug|object' command"}
+	}
+
+	entry, ok := db.Peek(args[0])
+	if !ok {
+		return RespData{Type: Error, Str: "ERR no such key"}
+	}
+
+	length, err := serializedLength(entry)
+	if err != nil {
+		return RespData{Type: Error, Str: fmt.Sprintf("ERR %v", err)}
+	}
+
+	encoding := encodingOf(entry)
+	info := fmt.Sprintf("refcount:1 encoding:%s serializedlength:%d", encoding, length)
+	if encoding == "quicklist" {
+		limit := int(server.listMaxListpackSize.Load())
+		nodes := quicklistNodes(entry.list, limit)
+		size := 0
+		for _, val := range entry.list {
+			size += len(val)
+		}
+		info += fmt.Sprintf(" ql_nodes:%d ql_avg_node:%.2f ql_listpack_max:%d ql_compressed:0 ql_uncompressed_size:%d",
+			nodes, float64(len(entry.list))/float64(nodes), limit, size)
+	}
+
+	return RespData{Type: SimpleString, Str: info}
+}
+
+// handleDebugSortReplies toggles sorting of replies whose order would
+// otherwise depend on map iteration, so tests can compare them verbatim.
+func handleDebugSortReplies(args []string) RespData {
+	if len(args) != 1 {
+		return RespData{Type: Error, Str: "ERR wrong number of arguments for 'debug|sort-replies' command"}
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "on":
+		server.sortReplies.Store(true)
+	case "off":
+		server.sortReplies.Store(false)
+	default:
+		return RespData{Type: Error, Str: "ERR syntax error"}
+	}
+	return RespData{Type: SimpleString, Str: "OK"}
+}
+
+// quicklistNodes counts the nodes Redis would split list into for the given
+// list-max-listpack-size: at most limit entries per node if positive,
+// otherwise at most 4kb, 8kb, 16kb, 32kb or 64kb of data for -1 to -5. A
+// count-limited node is still capped at 8kb like Redis's safety limit, so an
+// element larger than the byte limit always gets a node of its own.
+func quicklistNodes(list []string, limit int) int {
+	if len(list) == 0 {
+		return 0
+	}
+
+	maxEntries, maxBytes := limit, 8192
+	if limit < 0 {
+		maxEntries, maxBytes = len(list), 4096<<(-limit-1)
+	}
+	nodes, entries, size := 1, 0, 0
+	for _, val := range list {
+		if entries > 0 && (entries >= maxEntries || size+len(val) > maxBytes) {
+			nodes++
+			entries, size = 0, 0
+		}
+		entries++
+		size += len(val)
+	}
+	return nodes
+}
+
+// handleObjectCommand handles OBJECT ENCODING key.
+func handleObjectCommand(cmd Command, db *DataBase) RespData {
+	if len(cmd.args) < 1 {
+		return RespData{Type: Error, Str: "ERR wrong number of arguments for 'object' command"}
+	}
+
+	switch strings.ToLower(cmd.args[0]) {
+	case "encoding":
+		if len(cmd.args) != 2 {
+			return RespData{Type: Error, Str: "ERR wrong number of arguments for 'object|encoding' command"}
+		}
+		entry, ok := db.Peek(cmd.args[1])
+		if !ok {
+			return RespData{Type: BulkString, IsNull: true}
+		}
+		return RespData{Type: BulkString, Str: encodingOf(entry)}
+	default:
+		return RespData{Type: Error, Str: "ERR unknown subcommand '" + cmd.args[0] + "'. Try OBJECT HELP."}
+	}
+}
+
+// encodingOf reports the encoding Redis would use for entry's value. It is
+// the one place encodings are decided, for OBJECT ENCODING, DEBUG OBJECT
+// and MEMORY USAGE alike.
+func encodingOf(entry DBentry) string {
+	switch entry.dataType {
+	case StringType:
+		if len(entry.val) <= 20 {
+			if _, err := strconv.ParseInt(entry.val, 10, 64); err == nil {
+				return "int"
+			}
+		}
+		if len(entry.val) <= 44 {
+			return "embstr"
+		}
+		return "raw"
+	case ListType:
+		// Redis keeps a list that fits in one quicklist node (8kb with the
+		// default list-max-listpack-size of -2) in a single listpack
+		if quicklistNodes(entry.list, int(server.listMaxListpackSize.Load())) <= 1 {
+			return "listpack"
+		}
+		return "quicklist"
+	case StreamType:
+		return "stream"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func listOf(n int, elem string) []string {
+	list := make([]string, n)
+	for i := range list {
+		list[i] = elem
+	}
+	return list
+}
+
+func TestEncodingOf(t *testing.T) {
+	str := func(s string) DBentry { return DBentry{dataType: StringType, val: s, ttlMs: -1} }
+	list := func(l []string) DBentry { return DBentry{dataType: ListType, list: l, ttlMs: -1} }
+
+	tests := []struct {
+		name     string
+		limit    int // list-max-listpack-size
+		entry    DBentry
+		encoding string
+	}{
+		{"small integer", -2, str("12"), "int"},
+		{"negative integer", -2, str("-5"), "int"},
+		{"largest int64", -2, str("9223372036854775807"), "int"},
+		{"beyond int64", -2, str("9223372036854775808"), "embstr"},
+		{"21 digits", -2, str("123456789012345678901"), "embstr"},
+		{"padded number", -2, str(" 5"), "embstr"},
+		{"empty string", -2, str(""), "embstr"},
+		{"44 bytes", -2, str(strings.Repeat("x", 44)), "embstr"},
+		{"45 bytes", -2, str(strings.Repeat("x", 45)), "raw"},
+
+		{"empty list", -2, list(nil), "listpack"},
+		{"list within 8kb", -2, list(listOf(8, strings.Repeat("x", 1024))), "listpack"},
+		{"list over 8kb", -2, list(listOf(9, strings.Repeat("x", 1024))), "quicklist"},
+		{"list within 4kb at -1", -1, list(listOf(4, strings.Repeat("x", 1024))), "listpack"},
+		{"list over 4kb at -1", -1, list(listOf(5, strings.Repeat("x", 1024))), "quicklist"},
+		{"list at entry limit", 3, list(listOf(3, "a")), "listpack"},
+		{"list over entry limit", 3, list(listOf(4, "a")), "quicklist"},
+		{"one huge element", 128, list([]string{strings.Repeat("x", 9000)}), "listpack"},
+
+		{"stream", -2, DBentry{dataType: StreamType, stream: &Stream{}, ttlMs: -1}, "stream"},
+	}
+
+	newTestClient(t)
+	for _, tt := range tests {
+		server.listMaxListpackSize.Store(int64(tt.limit))
+		if got := encodingOf(tt.entry); got != tt.encoding {
+			t.Errorf("%s: encodingOf = %q, want %q", tt.name, got, tt.encoding)
+		}
+	}
+}
+
+func TestObjectEncoding(t *testing.T) {
+	c := newTestClient(t)
+	expectReply(t, c, errorReply("ERR wrong number of arguments for 'object' command"), "OBJECT")
+	expectReply(t, c, errorReply("ERR wrong number of arguments for 'object|encoding' command"), "OBJECT", "ENCODING")
+	expectReply(t, c, nullReply(), "OBJECT", "ENCODING", "missing")
+
+	run(c, "SET", "n", "100")
+	run(c, "RPUSH", "l", "a")
+	expectReply(t, c, bulkReply("int"), "OBJECT", "ENCODING", "n")
+	expectReply(t, c, bulkReply("listpack"), "OBJECT", "ENCODING", "l")
+}
+
+// debugObjectField returns field from the DEBUG OBJECT reply for key.
+func debugObjectField(t *testing.T, c *ClientConn, key, field string) string {
+	t.Helper()
+	reply := run(c, "DEBUG", "OBJECT", key)
+	for _, kv := range strings.Fields(reply.Str) {
+		if value, ok := strings.CutPrefix(kv, field+":"); ok {
+			return value
+		}
+	}
+	t.Fatalf("DEBUG OBJECT %s has no %s: %+v", key, field, reply)
+	return ""
+}
+
+// TestSerializedLength checks DEBUG OBJECT's serializedlength against the
+// DUMP payload, which is the same value behind a type byte and followed by
+// a 10-byte footer.
+func TestSerializedLength(t *testing.T) {
+	c := newTestClient(t)
+	run(c, "SET", "int", "12345")
+	run(c, "SET", "str", "hello world")
+	run(c, "SET", "long", strings.Repeat("x", 1000))
+	run(c, "RPUSH", "list", "a", "bb", "ccc")
+
+	for _, key := range []string{"int", "str", "long", "list"} {
+		payload := run(c, "DUMP", key).Str
+		want := strconv.Itoa(len(payload) - 11)
+		if got := debugObjectField(t, c, key, "serializedlength"); got != want {
+			t.Errorf("%s: serializedlength %s, want %s", key, got, want)
+		}
+	}
+	expectReply(t, c, errorReply("ERR no such key"), "DEBUG", "OBJECT", "missing")
+}
+
+func TestSortReplies(t *testing.T) {
+	c := newTestClient(t)
+	var want []string
+	for i := range 50 {
+		key := fmt.Sprintf("key%02d", i)
+		run(c, "SET", key, "v")
+		want = append(want, key)
+	}
+
+	expectReply(t, c, okReply(), "DEBUG", "SORT-REPLIES", "ON")
+	for _, args := range [][]string{{"KEYS", "*"}, {"SCAN", "0", "COUNT", "1000"}} {
+		reply := run(c, args...)
+		if args[0] == "SCAN" {
+			reply = reply.Array[1]
+		}
+		var got []string
+		for _, key := range reply.Array {
+			got = append(got, key.Str)
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("%v with sorting on: got %v", args, got)
+		}
+	}
+
+	expectReply(t, c, okReply(), "DEBUG", "SORT-REPLIES", "OFF")
+	if got := run(c, "KEYS", "*"); len(got.Array) != 50 {
+		t.Errorf("KEYS with sorting off: got %d keys", len(got.Array))
+	}
+	expectReply(t, c, errorReply("ERR syntax error"), "DEBUG", "SORT-REPLIES", "maybe")
+}
+
+// TestIntEncodingWithByteCommands interleaves INCR with byte-level string
+// commands. The encoding follows the stored value, so it returns to int as
+// soon as the bytes parse as an integer again.
+func TestIntEncodingWithByteCommands(t *testing.T) {
+	c := newTestClient(t)
+	expectReply(t, c, intReply(10), "INCRBY", "n", "10")
+	expectReply(t, c, bulkReply("int"), "OBJECT", "ENCODING", "n")
+	expectReply(t, c, bulkReply("0"), "GETRANGE", "n", "-1", "-1")
+
+	expectReply(t, c, intReply(3), "APPEND", "n", "x")
+	expectReply(t, c, bulkReply("embstr"), "OBJECT", "ENCODING", "n")
+	expectReply(t, c, errorReply("ERR value is not an integer or out of range"), "INCR", "n")
+
+	expectReply(t, c, intReply(3), "SETRANGE", "n", "2", "5")
+	expectReply(t, c, bulkReply("int"), "OBJECT", "ENCODING", "n")
+	expectReply(t, c, intReply(106), "INCR", "n")
+	expectReply(t, c, intReply(4), "APPEND", "n", "7")
+	expectReply(t, c, intReply(1068), "INCR", "n")
+	expectReply(t, c, bulkReply("1068"), "GET", "n")
+}
+
+func TestListMaxListpackSize(t *testing.T) {
+	c := newTestClient(t)
+	expectReply(t, c, okReply(), "CONFIG", "SET", "list-max-listpack-size", "1")
+	run(c, "RPUSH", "l", "a", "b", "c", "d", "e")
+	expectReply(t, c, bulkReply("quicklist"), "OBJECT", "ENCODING", "l")
+	if nodes := debugObjectField(t, c, "l", "ql_nodes"); nodes != "5" {
+		t.Errorf("ql_nodes = %s, want 5", nodes)
+	}
+	if max := debugObjectField(t, c, "l", "ql_listpack_max"); max != "1" {
+		t.Errorf("ql_listpack_max = %s, want 1", max)
+	}
+
+	expectReply(t, c, okReply(), "CONFIG", "SET", "list-max-listpack-size", "2")
+	if nodes := debugObjectField(t, c, "l", "ql_nodes"); nodes != "3" {
+		t.Errorf("ql_nodes at 2 per node = %s, want 3", nodes)
+	}
+	expectReply(t, c, okReply(), "CONFIG", "SET", "list-max-listpack-size", "-2")
+	expectReply(t, c, bulkReply("listpack"), "OBJECT", "ENCODING", "l")
+	expectReply(t, c, errorReply("ERR Invalid argument '-6' for CONFIG SET 'list-max-listpack-size'"),
+		"CONFIG", "SET", "list-max-listpack-size", "-6")
+}
+
+func TestLargeListElementOwnNode(t *testing.T) {
+	c := newTestClient(t)
+	big := strings.Repeat("x", 9000)
+	run(c, "RPUSH", "l", "a", "b")
+	expectReply(t, c, bulkReply("listpack"), "OBJECT", "ENCODING", "l")
+
+	run(c, "RPUSH", "l", big, "c", "d")
+	expectReply(t, c, bulkReply("quicklist"), "OBJECT", "ENCODING", "l")
+	// [a b] [big] [c d]
+	if nodes := debugObjectField(t, c, "l", "ql_nodes"); nodes != "3" {
+		t.Errorf("ql_nodes = %s, want 3", nodes)
+	}
+
+	// A count limit doesn't let the large element share a node either
+	run(c, "CONFIG", "SET", "list-max-listpack-size", "128")
+	if nodes := debugObjectField(t, c, "l", "ql_nodes"); nodes != "3" {
+		t.Errorf("ql_nodes at 128 entries per node = %s, want 3", nodes)
+	}
+	if got := run(c, "LRANGE", "l", "2", "2"); len(got.Array) != 1 || got.Array[0].Str != big {
+		t.Errorf("LRANGE l 2 2 did not return the large element")
+	}
+}
+
+func TestDebugSleepBlocksOnlyCaller(t *testing.T) {
+	sleeper, sleeperR := dialTestServer(t)
+	other, otherR := dialTestConn(t)
+
+	start := time.Now()
+	if _, err := io.WriteString(sleeper, "DEBUG SLEEP 0.3\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	// Give the sleeper time to start sleeping
+	time.Sleep(20 * time.Millisecond)
+	if _, err := io.WriteString(other, "SET k v\r\nPING\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	expectLine(t, otherR, "+OK\r\n")
+	expectLine(t, otherR, "+PONG\r\n")
+	if elapsed := time.Since(start); elapsed >= 300*time.Millisecond {
+		t.Errorf("other client waited %v for the sleeper", elapsed)
+	}
+
+	expectLine(t, sleeperR, "+OK\r\n")
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Errorf("DEBUG SLEEP 0.3 returned after %v", elapsed)
+	}
+}
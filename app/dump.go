@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hdt3213/rdb/crc64jones"
+	"github.com/hdt3213/rdb/parser"
+)
+
+// A DUMP payload is an RDB object (type byte and value) followed by a 2-byte
+// little-endian RDB version and an 8-byte little-endian CRC64 (Jones) of
+// everything before it, the same layout Redis uses.
+
+var errBadPayload = errors.New("ERR DUMP payload version or checksum are wrong")
+
+func createDumpPayload(entry DBentry) ([]byte, error) {
+	payload, err := rdbObject(entry)
+	if err != nil {
+		return nil, err
+	}
+	payload = binary.LittleEndian.AppendUint16(payload, uint16(server.rdbVersion))
+	crc := crc64jones.New()
+	crc.Write(payload)
+	return binary.LittleEndian.AppendUint64(payload, crc.Sum64()), nil
+}
+
+// verifyDumpPayload checks the footer of payload and returns the RDB object
+// it carries.
+func verifyDumpPayload(payload []byte) ([]byte, error) {
+	if len(payload) < 10 {
+		return nil, errBadPayload
+	}
+	footer := len(payload) - 10
+	version := binary.LittleEndian.Uint16(payload[footer:])
+	if int(version) > server.rdbVersion {
+		return nil, errBadPayload
+	}
+	crc := crc64jones.New()
+	crc.Write(payload[:footer+2])
+	if crc.Sum64() != binary.LittleEndian.Uint64(payload[footer+2:]) {
+		return nil, errBadPayload
+	}
+	return payload[:footer], nil
+}
+
+// decodeDumpObject wraps an RDB object in a minimal RDB file so the regular
+// decoder can parse it.
+func decodeDumpObject(object []byte) (DBentry, error) {
+	if len(object) < 2 {
+		return DBentry{}, errors.New("ERR Bad data format")
+	}
+	var file bytes.Buffer
+	file.WriteString("REDIS0011")
+	file.Write([]byte{0xFE, 0x00}) // SELECTDB 0
+	file.WriteByte(object[0])      // value type
+	file.WriteByte(0x00)           // empty key
+	file.Write(object[1:])         // value
+	file.WriteByte(0xFF)           // EOF
+	file.Write(make([]byte, 8))    // checksum, not verified by the decoder
+
+	var entry DBentry
+	found := false
+	err := parser.NewDecoder(&file).Parse(func(o parser.RedisObject) bool {
+		var convErr error
+		entry, found, convErr = server.entryFromObject(o)
+		found = found && convErr == nil
+		return false
+	})
+	if err != nil || !found {
+		return DBentry{}, errors.New("ERR Bad data format")
+	}
+	return entry, nil
+}
+
+func handleDumpCommand(cmd Command, db *DataBase) RespData {
+	if len(cmd.args) != 1 {
+		return RespData{Type: Error, Str: "ERR wrong number of arguments for 'dump' command"}
+	}
+
+	entry, ok := db.Lookup(cmd.args[0])
+	if !ok {
+		return RespData{Type: BulkString, IsNull: true}
+	}
+	payload, err := createDumpPayload(entry)
+	if err != nil {
+		return RespData{Type: Error, Str: "ERR " + err.Error()}
+	}
+	return RespData{Type: BulkString, Str: string(payload)}
+}
+
+func handleRestoreCommand(cmd Command, db *DataBase) RespData {
+	if len(cmd.args) < 3 {
+		return RespData{Type: Error, Str: "ERR wrong number of arguments for 'restore' command"}
+	}
+
+	key := cmd.args[0]
+	ttl, err := strconv.ParseInt(cmd.args[1], 10, 64)
+	if err != nil {
+		return RespData{Type: Error, Str: "ERR value is not an integer or out of range"}
+	}
+	if ttl < 0 {
+		return RespData{Type: Error, Str: "ERR Invalid TTL value, must be >= 0"}
+	}
+
+	replace, absTTL := false, false
+	for _, opt := range cmd.args[3:] {
+		switch strings.ToLower(opt) {
+		case "replace":
+			replace = true
+		case "absttl":
+			absTTL = true
+		default:
+			return RespData{Type: Error, Str: "ERR syntax error"}
+		}
+	}
+
+	object, err := verifyDumpPayload([]byte(cmd.args[2]))
+	if err != nil {
+		return RespData{Type: Error, Str: err.Error()}
+	}
+	entry, err := decodeDumpObject(object)
+	if err != nil {
+		return RespData{Type: Error, Str: err.Error()}
+	}
+
+	var at int64 = -1
+	if ttl > 0 {
+		at = ttl
+		if !absTTL {
+			at += time.Now().UnixMilli()
+		}
+	}
+
+	if !db.Restore(key, entry, at, replace) {
+		return RespData{Type: Error, Str: "BUSYKEY Target key name already exists."}
+	}
+	db.notifyKeyspaceEvent(notifyGeneric, "restore", key)
+	return RespData{Type: SimpleString, Str: "OK"}
+}
@@ -0,0 +1,52 @@
+package main
+
+import "testing"
+
+func TestDumpRestoreRoundTrip(t *testing.T) {
+	c := newTestClient(t)
+	run(c, "SET", "str", "hello")
+	run(c, "SET", "num", "12345")
+	run(c, "RPUSH", "list", "a", "b", "c")
+
+	for _, key := range []string{"str", "num", "list"} {
+		payload := run(c, "DUMP", key)
+		if payload.Type != BulkString || payload.IsNull {
+			t.Fatalf("DUMP %s: got %+v", key, payload)
+		}
+		expectReply(t, c, okReply(), "RESTORE", key+"-copy", "0", payload.Str)
+	}
+	expectReply(t, c, bulkReply("hello"), "GET", "str-copy")
+	expectReply(t, c, bulkReply("12345"), "GET", "num-copy")
+	got := run(c, "LRANGE", "list-copy", "0", "-1")
+	if len(got.Array) != 3 || got.Array[0].Str != "a" || got.Array[1].Str != "b" || got.Array[2].Str != "c" {
+		t.Errorf("LRANGE list-copy: got %+v", got)
+	}
+
+	payload := run(c, "DUMP", "str").Str
+	expectReply(t, c, errorReply("BUSYKEY Target key name already exists."), "RESTORE", "str-copy", "0", payload)
+	expectReply(t, c, okReply(), "RESTORE", "str-copy", "0", payload, "REPLACE")
+}
+
+// TestRestoreRedisPayload restores what Redis 7 returns for DUMP of the
+// string "10", checking the footer is computed the same way.
+func TestRestoreRedisPayload(t *testing.T) {
+	c := newTestClient(t)
+	expectReply(t, c, okReply(), "RESTORE", "k", "0", "\x00\xc0\n\n\x00n\x9fWE\x0e\xaec\xbb")
+	expectReply(t, c, bulkReply("10"), "GET", "k")
+}
+
+func TestRestoreRejectsCorruptPayload(t *testing.T) {
+	c := newTestClient(t)
+	run(c, "SET", "k", "hello")
+	payload := []byte(run(c, "DUMP", "k").Str)
+
+	for i := range payload {
+		corrupt := append([]byte(nil), payload...)
+		corrupt[i] ^= 0x01
+		expectReply(t, c, errorReply("ERR DUMP payload version or checksum are wrong"),
+			"RESTORE", "copy", "0", string(corrupt))
+	}
+	expectReply(t, c, errorReply("ERR DUMP payload version or checksum are wrong"),
+		"RESTORE", "copy", "0", "short")
+	expectReply(t, c, intReply(0), "EXISTS", "copy")
+}
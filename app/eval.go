@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// Scripts run in gopher-lua with the base, table, string and math
+// libraries. Anything that reaches outside the server is removed, and the
+// globals table refuses new or unknown names, as in Redis. Each EVAL gets a
+// fresh interpreter, so nothing a script does outlives it.
+//
+// Lua values map to replies following Redis's rules: numbers become
+// integers (truncated), strings bulk strings, true 1 and false or nil a
+// null bulk string. A table with an ok or err field becomes a status or
+// error reply; any other table an array ending at its first nil.
+
+// scriptTimeLimit bounds how long a script may run. Scripts hold
+// commandMu exclusively, so a runaway loop would otherwise stall every
+// client; past the limit the script is aborted with an error.
+var scriptTimeLimit = 5 * time.Second
+
+// scriptChunkName names scripts in Lua error messages.
+const scriptChunkName = "user_script"
+
+// unsafeGlobals are base library functions removed from the sandbox:
+// loading code from files or modules, and changing function environments
+// to get around the globals protection.
+var unsafeGlobals = []string{
+	"dofile", "loadfile", "require", "module", "getfenv", "setfenv",
+	"print", "_printregs",
+}
+
+// notAllowedInScripts lists commands a script may not run through redis.call.
+var notAllowedInScripts = map[string]bool{
+	"multi": true, "exec": true, "discard": true,
+	"subscribe": true, "unsubscribe": true,
+	"eval": true, "evalsha": true, "fcall": true, "fcall_ro": true,
+	"script": true, "function": true, "client": true, "debug": true,
+	"xread": true,
+}
+
+// compileScript parses and compiles a script body without running it.
+func compileScript(body string) (*lua.FunctionProto, error) {
+	chunk, err := parse.Parse(strings.NewReader(body), scriptChunkName)
+	if err != nil {
+		return nil, err
+	}
+	return lua.Compile(chunk, scriptChunkName)
+}
+
+// newScriptState returns an interpreter with the sandboxed libraries and
+// the KEYS, ARGV and redis globals. Commands run through redis.call act as
+// client.
+func newScriptState(keys, argv []string, client *ClientConn) *lua.LState {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	for _, name := range unsafeGlobals {
+		L.SetGlobal(name, lua.LNil)
+	}
+
+	L.SetGlobal("KEYS", stringsToLua(L, keys))
+	L.SetGlobal("ARGV", stringsToLua(L, argv))
+	redis := L.NewTable()
+	L.SetField(redis, "call", L.NewFunction(func(L *lua.LState) int {
+		return redisCall(L, client, false)
+	}))
+	L.SetField(redis, "pcall", L.NewFunction(func(L *lua.LState) int {
+		return redisCall(L, client, true)
+	}))
+	L.SetField(redis, "status_reply", L.NewFunction(func(L *lua.LState) int {
+		L.Push(replyTable(L, "ok", L.CheckString(1)))
+		return 1
+	}))
+	L.SetField(redis, "error_reply", L.NewFunction(func(L *lua.LState) int {
+		L.Push(replyTable(L, "err", L.CheckString(1)))
+		return 1
+	}))
+	L.SetGlobal("redis", redis)
+
+	// From here on the globals are fixed
+	protect := L.NewTable()
+	L.SetField(protect, "__newindex", L.NewFunction(func(L *lua.LState) int {
+		L.RaiseError("Script attempted to create global variable '%s'", L.Get(2).String())
+		return 0
+	}))
+	L.SetField(protect, "__index", L.NewFunction(func(L *lua.LState) int {
+		L.RaiseError("Script attempted to access nonexistent global variable '%s'", L.Get(2).String())
+		return 0
+	}))
+	L.SetMetatable(L.Get(lua.GlobalsIndex), protect)
+	return L
+}
+
+// redisCall implements redis.call and redis.pcall. A failing command
+// raises its error reply from redis.call, so the script stops and the
+// client gets the reply verbatim unless the script catches it; redis.pcall
+// returns it as an error table instead.
+func redisCall(L *lua.LState, client *ClientConn, protected bool) int {
+	n := L.GetTop()
+	if n == 0 {
+		L.RaiseError("Please specify at least one argument for this redis lib call")
+	}
+	args := make([]string, n)
+	for i := range args {
+		switch v := L.Get(i + 1).(type) {
+		case lua.LString:
+			args[i] = string(v)
+		case lua.LNumber:
+			args[i] = v.String()
+		default:
+			L.RaiseError("Lua redis lib command arguments must be strings or integers")
+		}
+	}
+	cmd := Command{cmd: args[0], args: args[1:]}
+
+	var reply RespData
+	if notAllowedInScripts[strings.ToLower(cmd.cmd)] {
+		reply = RespData{Type: Error, Str: "ERR This Redis command is not allowed from script"}
+	} else if errReply, ok := validateCommand(cmd); !ok {
+		reply = errReply
+	} else {
+		// EVAL isn't itself a write: each write the script makes is
+		// propagated on its own, with the database it ran against
+		dbIndex := client.db.id
+		reply = executeCommand(cmd, client, true)
+		propagateWrite(dbIndex, cmd, reply)
+	}
+	if reply.Type == Error && !protected {
+		L.Error(replyTable(L, "err", reply.Str), 1)
+		return 0
+	}
+	L.Push(respToLua(L, reply))
+	return 1
+}
+
+func stringsToLua(L *lua.LState, values []string) *lua.LTable {
+	table := L.CreateTable(len(values), 0)
+	for _, v := range values {
+		table.Append(lua.LString(v))
+	}
+	return table
+}
+
+// replyTable returns the {ok = msg} or {err = msg} table standing for a
+// status or error reply.
+func replyTable(L *lua.LState, field, msg string) *lua.LTable {
+	table := L.NewTable()
+	table.RawSetString(field, lua.LString(msg))
+	return table
+}
+
+// respToLua converts a command reply into the value redis.call returns.
+func respToLua(L *lua.LState, r RespData) lua.LValue {
+	switch r.Type {
+	case Integer:
+		return lua.LNumber(r.Num)
+	case SimpleString:
+		return replyTable(L, "ok", r.Str)
+	case Error:
+		return replyTable(L, "err", r.Str)
+	case BulkString:
+		if r.IsNull {
+			return lua.LFalse
+		}
+		return lua.LString(r.Str)
+	case Array, Map:
+		if r.IsNull {
+			return lua.LFalse
+		}
+		table := L.CreateTable(len(r.Array), 0)
+		for _, item := range r.Array {
+			table.Append(respToLua(L, item))
+		}
+		return table
+	default:
+		return lua.LFalse
+	}
+}
+
+// luaToResp converts a script's return value into the reply sent to the
+// client.
+func luaToResp(v lua.LValue) RespData {
+	switch val := v.(type) {
+	case lua.LNumber:
+		return RespData{Type: Integer, Num: int64(val)}
+	case lua.LString:
+		return RespData{Type: BulkString, Str: string(val)}
+	case lua.LBool:
+		if val {
+			return RespData{Type: Integer, Num: 1}
+		}
+		return RespData{Type: BulkString, IsNull: true}
+	case *lua.LTable:
+		if ok, isStr := val.RawGetString("ok").(lua.LString); isStr {
+			return RespData{Type: SimpleString, Str: string(ok)}
+		}
+		if err, isStr := val.RawGetString("err").(lua.LString); isStr {
+			return RespData{Type: Error, Str: string(err)}
+		}
+		arr := []RespData{}
+		for i := 1; ; i++ {
+			item := val.RawGetInt(i)
+			if item == lua.LNil {
+				break
+			}
+			arr = append(arr, luaToResp(item))
+		}
+		return RespData{Type: Array, Array: arr}
+	default:
+		return RespData{Type: BulkString, IsNull: true}
+	}
+}
+
+// runScript executes a script with the given KEYS and ARGV tables. Its
+// commands run against the caller's selected database.
+func runScript(script string, keys, argv []string, caller *ClientConn) RespData {
+	proto, err := compileScript(script)
+	if err != nil {
+		return RespData{Type: Error, Str: "ERR Error compiling script: " + err.Error()}
+	}
+
+	scriptClient := &ClientConn{subscriptions: make(map[string]struct{}), db: caller.db}
+	L := newScriptState(keys, argv, scriptClient)
+	defer L.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), scriptTimeLimit)
+	defer cancel()
+	L.SetContext(ctx)
+
+	L.Push(L.NewFunctionFromProto(proto))
+	if err := L.PCall(0, 1, nil); err != nil {
+		if apiErr, ok := err.(*lua.ApiError); ok {
+			if table, ok := apiErr.Object.(*lua.LTable); ok {
+				if reply, ok := table.RawGetString("err").(lua.LString); ok {
+					return RespData{Type: Error, Str: string(reply)}
+				}
+			}
+			return RespData{Type: Error, Str: "ERR Error running script: " + apiErr.Object.String()}
+		}
+		return RespData{Type: Error, Str: "ERR Error running script: " + err.Error()}
+	}
+	return luaToResp(L.Get(-1))
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScripts(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		keys   []string
+		argv   []string
+		want   RespData
+	}{
+		{"empty script", "", nil, nil, nullReply()},
+		{"string", `return "hi"`, nil, nil, bulkReply("hi")},
+		{"integer", "return 42", nil, nil, intReply(42)},
+		{"fraction truncates", "return 3.9", nil, nil, intReply(3)},
+		{"nil", "return nil", nil, nil, nullReply()},
+		{"true", "return true", nil, nil, intReply(1)},
+		{"false", "return false", nil, nil, nullReply()},
+		{"keys and argv", "return KEYS[2] .. ARGV[1]", []string{"k1", "k2"}, []string{"a"}, bulkReply("k2a")},
+		{"length", "return #KEYS + #ARGV", []string{"a", "b"}, []string{"c"}, intReply(3)},
+		{"arithmetic", "return tonumber(ARGV[1]) * 2 + 1", nil, []string{"20"}, intReply(41)},
+		{"loop", "local n = 0 for i = 1, 10 do n = n + i end return n", nil, nil, intReply(55)},
+		{"function", "local function sq(x) return x * x end return sq(7)", nil, nil, intReply(49)},
+		{"string library", "return string.upper(ARGV[1])", nil, []string{"abc"}, bulkReply("ABC")},
+		{"status reply", "return redis.status_reply('PONG')", nil, nil, RespData{Type: SimpleString, Str: "PONG"}},
+		{"error reply", "return redis.error_reply('ERR boom')", nil, nil, errorReply("ERR boom")},
+		{"redis.call", "redis.call('set', KEYS[1], ARGV[1]) return redis.call('get', KEYS[1])", []string{"k"}, []string{"v"}, bulkReply("v")},
+		{"compare and set", "if redis.call('get', KEYS[1]) == ARGV[1] then return redis.call('set', KEYS[1], ARGV[2]) end return false",
+			[]string{"word"}, []string{"abc", "new"}, okReply()},
+		{"compare and set mismatch", "if redis.call('get', KEYS[1]) == ARGV[1] then return redis.call('set', KEYS[1], ARGV[2]) end return false",
+			[]string{"word"}, []string{"xyz", "new"}, nullReply()},
+		{"missing key is false", "return redis.call('get', 'missing') == false", nil, nil, intReply(1)},
+		{"number arguments", "redis.call('set', 'n', 5) return redis.call('incrby', 'n', 2)", nil, nil, intReply(7)},
+		{"status from call", "return redis.call('set', 'k', 'v').ok", nil, nil, bulkReply("OK")},
+		{"redis.call error aborts", "redis.call('incr', 'word') return 1", nil, nil, errorReply("ERR value is not an integer or out of range")},
+		{"redis.pcall error is returned", "return redis.pcall('incr', 'word')", nil, nil, errorReply("ERR value is not an integer or out of range")},
+		{"pcall catches redis.call", "local ok, err = pcall(redis.call, 'incr', 'word') return err.err", nil, nil,
+			bulkReply("ERR value is not an integer or out of range")},
+		{"command not allowed", "return redis.call('multi')", nil, nil, errorReply("ERR This Redis command is not allowed from script")},
+		{"unknown command", "return redis.call('nosuch')", nil, nil, errorReply("ERR unknown command 'nosuch'")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(t)
+			run(c, "SET", "word", "abc")
+			got := runScript(tt.script, tt.keys, tt.argv, c)
+			if got.Type != tt.want.Type || got.Str != tt.want.Str || got.Num != tt.want.Num || got.IsNull != tt.want.IsNull {
+				t.Errorf("%q: got %+v, want %+v", tt.script, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestScriptErrors checks scripts that fail to compile or run, including
+// those that try to leave the sandbox.
+func TestScriptErrors(t *testing.T) {
+	tests := []struct {
+		script string
+		want   string // prefix and substring of the error
+	}{
+		{"return 'abc", "ERR Error compiling script"},
+		{"return 1 +", "ERR Error compiling script"},
+		{"error('boom')", "boom"},
+		{"x = 1", "Script attempted to create global variable 'x'"},
+		{"return undefined", "Script attempted to access nonexistent global variable 'undefined'"},
+		{"return os.time()", "nonexistent global variable 'os'"},
+		{"return io.open('/etc/passwd')", "nonexistent global variable 'io'"},
+		{"return loadfile('/etc/passwd')", "nonexistent global variable 'loadfile'"},
+		{"return require('os')", "nonexistent global variable 'require'"},
+		{"redis.call()", "Please specify at least one argument for this redis lib call"},
+		{"redis.call('get', {})", "Lua redis lib command arguments must be strings or integers"},
+	}
+
+	c := newTestClient(t)
+	for _, tt := range tests {
+		got := runScript(tt.script, nil, nil, c)
+		if got.Type != Error || !strings.HasPrefix(got.Str, "ERR ") || !strings.Contains(got.Str, tt.want) {
+			t.Errorf("%q: got %+v, want an error containing %q", tt.script, got, tt.want)
+		}
+	}
+}
+
+func TestScriptTimeLimit(t *testing.T) {
+	c := newTestClient(t)
+	saved := scriptTimeLimit
+	scriptTimeLimit = 50 * time.Millisecond
+	t.Cleanup(func() { scriptTimeLimit = saved })
+
+	start := time.Now()
+	got := runScript("while true do end", nil, nil, c)
+	if got.Type != Error || !strings.HasPrefix(got.Str, "ERR Error running script") {
+		t.Errorf("got %+v, want a script error", got)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("script ran for %v", elapsed)
+	}
+}
+
+func TestScriptTables(t *testing.T) {
+	c := newTestClient(t)
+	got := runScript("return {1, 'two', {3}, nil, 5}", nil, nil, c)
+	if got.Type != Array || len(got.Array) != 3 {
+		t.Fatalf("got %+v, want a 3 element array ending at the nil", got)
+	}
+	if got.Array[0].Num != 1 || got.Array[1].Str != "two" || got.Array[2].Type != Array || got.Array[2].Array[0].Num != 3 {
+		t.Errorf("got %+v", got.Array)
+	}
+
+	run(c, "RPUSH", "l", "a", "b")
+	got = runScript("local t = redis.call('lrange', 'l', 0, -1) table.insert(t, #t) return t", nil, nil, c)
+	if len(got.Array) != 3 || got.Array[0].Str != "a" || got.Array[1].Str != "b" || got.Array[2].Num != 2 {
+		t.Errorf("lrange table: got %+v", got)
+	}
+}
+
+func TestEvalArity(t *testing.T) {
+	c := newTestClient(t)
+	expectReply(t, c, errorReply("ERR wrong number of arguments for 'eval' command"), "EVAL")
+	expectReply(t, c, errorReply("ERR wrong number of arguments for 'eval' command"), "EVAL", "return 1")
+	expectReply(t, c, errorReply("ERR Number of keys can't be greater than number of args"), "EVAL", "return 1", "2", "k")
+	expectReply(t, c, intReply(1), "EVAL", "return 1", "0")
+}
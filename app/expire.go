@@ -0,0 +1,82 @@
+package main
+
+import "time"
+
+// Active expiry, after Redis's active expire cycle. Lookups delete expired
+// keys lazily, but a key nobody reads again would stay in memory forever,
+// so a background goroutine also samples keys with a TTL and deletes the
+// expired ones. Each sample holds db.mu only briefly, and a cycle stops
+// once few of the sampled keys were expired or its time budget runs out.
+
+const (
+	defaultActiveExpireInterval = 100 * time.Millisecond
+
+	// activeExpireSamples is how many keys with a TTL one sample checks
+	activeExpireSamples = 20
+	// activeExpireMaxVisits bounds the keys looked at per sample, so a
+	// keyspace with few TTLs can't turn a sample into a full scan
+	activeExpireMaxVisits = 20 * activeExpireSamples
+	// activeExpireStalePercent is the share of expired keys in a sample
+	// above which another sample is taken straight away
+	activeExpireStalePercent = 10
+)
+
+// runActiveExpire runs an expire cycle over every database each
+// activeExpireInterval until shutdown. A cycle may take up to a quarter of
+// the interval.
+func (s *Server) runActiveExpire() {
+	ticker := time.NewTicker(s.activeExpireInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.closing:
+			return
+		case now := <-ticker.C:
+			budget := now.Add(s.activeExpireInterval / 4)
+			for _, db := range s.databases {
+				db.activeExpire(budget)
+			}
+		}
+	}
+}
+
+// activeExpire samples db until a sample finds few expired keys or the
+// budget deadline passes.
+func (db *DataBase) activeExpire(budget time.Time) {
+	for {
+		expired, sampled := db.expireSample()
+		for _, key := range expired {
+			db.notifyKeyspaceEvent(notifyExpired, "expired", key)
+		}
+		if len(expired)*100 <= sampled*activeExpireStalePercent || time.Now().After(budget) {
+			return
+		}
+	}
+}
+
+// expireSample checks up to activeExpireSamples keys with a TTL, starting
+// at the random position map iteration begins at, and deletes those past
+// their deadline. It returns the deleted keys and how many were checked.
+func (db *DataBase) expireSample() (expired []string, sampled int) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	now := time.Now().UnixMilli()
+	visited := 0
+	for key, entry := range db.M {
+		if visited++; visited > activeExpireMaxVisits {
+			break
+		}
+		if entry.ttlMs == -1 {
+			continue
+		}
+		if entry.isExpired(now) {
+			db.deleteLocked(key)
+			expired = append(expired, key)
+		}
+		if sampled++; sampled == activeExpireSamples {
+			break
+		}
+	}
+	return expired, sampled
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestPastDeadlines checks every way of setting an expiry against Redis:
+// a relative timeout of zero or less is an error for SET, SETEX and GETEX
+// but deletes the key for the EXPIRE family, and an absolute deadline in
+// the past always deletes the key.
+func TestPastDeadlines(t *testing.T) {
+	tests := []struct {
+		args []string
+		want RespData
+	}{
+		{[]string{"SET", "k", "v", "EX", "0"}, errorReply("ERR invalid expire time in 'set' command")},
+		{[]string{"SET", "k", "v", "PX", "-1"}, errorReply("ERR invalid expire time in 'set' command")},
+		{[]string{"SET", "k", "v", "EXAT", "1"}, okReply()},
+		{[]string{"SET", "k", "v", "PXAT", "1"}, okReply()},
+		{[]string{"SETEX", "k", "0", "v"}, errorReply("ERR invalid expire time in 'setex' command")},
+		{[]string{"PSETEX", "k", "-5", "v"}, errorReply("ERR invalid expire time in 'psetex' command")},
+		{[]string{"GETEX", "k", "EX", "0"}, errorReply("ERR invalid expire time in 'getex' command")},
+		{[]string{"GETEX", "k", "EXAT", "1"}, bulkReply("old")},
+		{[]string{"GETEX", "k", "PXAT", "1"}, bulkReply("old")},
+		{[]string{"EXPIRE", "k", "0"}, intReply(1)},
+		{[]string{"EXPIRE", "k", "-10"}, intReply(1)},
+		{[]string{"PEXPIRE", "k", "-1"}, intReply(1)},
+		{[]string{"EXPIREAT", "k", "1"}, intReply(1)},
+		{[]string{"PEXPIREAT", "k", "-1"}, intReply(1)},
+	}
+	for _, tt := range tests {
+		c := newTestClient(t)
+		run(c, "SET", "k", "old")
+		expectReply(t, c, tt.want, tt.args...)
+		want := intReply(1) // errors leave the key alone
+		if tt.want.Type != Error {
+			want = intReply(0)
+		}
+		expectReply(t, c, want, "EXISTS", "k")
+	}
+}
+
+func TestExpireFamily(t *testing.T) {
+	c := newTestClient(t)
+	expectReply(t, c, intReply(0), "EXPIRE", "missing", "100")
+	expectReply(t, c, intReply(0), "EXPIRE", "missing", "0")
+	expectReply(t, c, errorReply("ERR value is not an integer or out of range"), "EXPIRE", "k", "soon")
+	expectReply(t, c, errorReply("ERR invalid expire time in 'expire' command"), "EXPIRE", "k", "9223372036854775807")
+
+	run(c, "SET", "k", "v")
+	expectReply(t, c, intReply(1), "EXPIRE", "k", "100")
+	expectReply(t, c, intReply(100), "TTL", "k")
+	expectReply(t, c, intReply(1), "PEXPIRE", "k", "200000")
+	expectReply(t, c, intReply(200), "TTL", "k")
+
+	at := time.Now().Unix() + 300
+	expectReply(t, c, intReply(1), "EXPIREAT", "k", strconv.FormatInt(at, 10))
+	if ttl := run(c, "TTL", "k").Num; ttl < 299 || ttl > 300 {
+		t.Errorf("TTL after EXPIREAT now+300: got %d", ttl)
+	}
+	expectReply(t, c, intReply(1), "PEXPIREAT", "k", strconv.FormatInt(at*1000+100000, 10))
+	if ttl := run(c, "TTL", "k").Num; ttl < 399 || ttl > 400 {
+		t.Errorf("TTL after PEXPIREAT now+400s: got %d", ttl)
+	}
+
+	expectReply(t, c, intReply(1), "PERSIST", "k")
+	expectReply(t, c, intReply(-1), "TTL", "k")
+	expectReply(t, c, intReply(0), "PERSIST", "k")
+}
+
+func TestSetExpiry(t *testing.T) {
+	c := newTestClient(t)
+	expectReply(t, c, okReply(), "SET", "k", "v", "EX", "1")
+	expectReply(t, c, intReply(1), "TTL", "k")
+	expectReply(t, c, okReply(), "SET", "p", "v", "PX", "1000")
+	time.Sleep(1100 * time.Millisecond)
+	expectReply(t, c, nullReply(), "GET", "k")
+	expectReply(t, c, nullReply(), "GET", "p")
+
+	// Options may come in any order, but only one expiry is allowed
+	expectReply(t, c, okReply(), "SET", "k", "v", "EX", "10", "NX")
+	expectReply(t, c, okReply(), "SET", "k", "w", "XX", "EX", "20")
+	expectReply(t, c, intReply(20), "TTL", "k")
+	expectReply(t, c, errorReply("ERR syntax error"), "SET", "k", "v", "EX", "1", "PX", "100")
+	expectReply(t, c, errorReply("ERR syntax error"), "SET", "k", "v", "PX", "100", "EX", "1")
+	expectReply(t, c, errorReply("ERR syntax error"), "SET", "k", "v", "EX")
+	expectReply(t, c, errorReply("ERR value is not an integer or out of range"), "SET", "k", "v", "EX", "soon")
+	expectReply(t, c, errorReply("ERR invalid expire time in 'set' command"), "SET", "k", "v", "EX", "-5")
+	expectReply(t, c, bulkReply("w"), "GET", "k")
+}
+
+func TestSetKeepTTL(t *testing.T) {
+	c := newTestClient(t)
+	run(c, "SET", "k", "v", "PX", "5000")
+	expectReply(t, c, okReply(), "SET", "k", "w", "KEEPTTL")
+	expectReply(t, c, bulkReply("w"), "GET", "k")
+	if ms := run(c, "PTTL", "k").Num; ms <= 0 || ms > 5000 {
+		t.Errorf("PTTL after KEEPTTL: got %d, want 1..5000", ms)
+	}
+	expectReply(t, c, okReply(), "SET", "k", "x")
+	expectReply(t, c, intReply(-1), "PTTL", "k")
+
+	expectReply(t, c, okReply(), "SET", "new", "v", "KEEPTTL")
+	expectReply(t, c, intReply(-1), "PTTL", "new")
+	expectReply(t, c, errorReply("ERR syntax error"), "SET", "k", "v", "KEEPTTL", "EX", "10")
+	expectReply(t, c, errorReply("ERR syntax error"), "SET", "k", "v", "PX", "10", "KEEPTTL")
+}
+
+func TestTTLAndPTTL(t *testing.T) {
+	c := newTestClient(t)
+	expectReply(t, c, intReply(-2), "TTL", "missing")
+	expectReply(t, c, intReply(-2), "PTTL", "missing")
+
+	run(c, "SET", "k", "v")
+	expectReply(t, c, intReply(-1), "TTL", "k")
+	expectReply(t, c, intReply(-1), "PTTL", "k")
+
+	run(c, "SET", "k", "v", "EX", "100")
+	expectReply(t, c, intReply(100), "TTL", "k")
+	if ms := run(c, "PTTL", "k").Num; ms <= 99000 || ms > 100000 {
+		t.Errorf("PTTL: got %d, want about 100000", ms)
+	}
+	run(c, "RPUSH", "l", "a")
+	run(c, "PEXPIRE", "l", "50000")
+	expectReply(t, c, intReply(50), "TTL", "l")
+	expectReply(t, c, errorReply("ERR wrong number of arguments for 'ttl' command"), "TTL")
+}
+
+func TestExpireAndPExpire(t *testing.T) {
+	c := newTestClient(t)
+	expectReply(t, c, intReply(0), "EXPIRE", "missing", "10")
+	expectReply(t, c, intReply(0), "PEXPIRE", "missing", "10000")
+
+	run(c, "SET", "k", "v")
+	expectReply(t, c, intReply(1), "EXPIRE", "k", "10")
+	expectReply(t, c, intReply(10), "TTL", "k")
+	// A second call replaces the expiry, longer or shorter
+	expectReply(t, c, intReply(1), "PEXPIRE", "k", "30000")
+	expectReply(t, c, intReply(30), "TTL", "k")
+	expectReply(t, c, intReply(1), "EXPIRE", "k", "5")
+	expectReply(t, c, intReply(5), "TTL", "k")
+	expectReply(t, c, bulkReply("v"), "GET", "k")
+
+	// Zero or negative timeouts delete the key
+	expectReply(t, c, intReply(1), "EXPIRE", "k", "0")
+	expectReply(t, c, intReply(0), "EXISTS", "k")
+	run(c, "SET", "k", "v")
+	expectReply(t, c, intReply(1), "PEXPIRE", "k", "-1")
+	expectReply(t, c, intReply(0), "EXISTS", "k")
+
+	run(c, "SET", "k", "v")
+	expectReply(t, c, intReply(1), "PEXPIRE", "k", "20")
+	time.Sleep(40 * time.Millisecond)
+	expectReply(t, c, nullReply(), "GET", "k")
+}
+
+func TestPersist(t *testing.T) {
+	c := newTestClient(t)
+	expectReply(t, c, intReply(0), "PERSIST", "missing")
+	run(c, "SET", "plain", "v")
+	expectReply(t, c, intReply(0), "PERSIST", "plain")
+
+	run(c, "SET", "k", "v", "PX", "500")
+	expectReply(t, c, intReply(1), "PERSIST", "k")
+	expectReply(t, c, intReply(-1), "TTL", "k")
+	time.Sleep(600 * time.Millisecond)
+	expectReply(t, c, bulkReply("v"), "GET", "k")
+	expectReply(t, c, intReply(0), "PERSIST", "k")
+}
+
+func TestGetEx(t *testing.T) {
+	c := newTestClient(t)
+	expectReply(t, c, nullReply(), "GETEX", "missing")
+	expectReply(t, c, nullReply(), "GETEX", "missing", "EX", "10")
+	expectReply(t, c, intReply(0), "EXISTS", "missing")
+
+	// No option reads like GET and keeps the TTL
+	run(c, "SET", "k", "v", "EX", "100")
+	expectReply(t, c, bulkReply("v"), "GETEX", "k")
+	expectReply(t, c, intReply(100), "TTL", "k")
+
+	expectReply(t, c, bulkReply("v"), "GETEX", "k", "EX", "200")
+	expectReply(t, c, intReply(200), "TTL", "k")
+	expectReply(t, c, bulkReply("v"), "GETEX", "k", "PX", "300000")
+	expectReply(t, c, intReply(300), "TTL", "k")
+	expectReply(t, c, bulkReply("v"), "GETEX", "k", "PERSIST")
+	expectReply(t, c, intReply(-1), "TTL", "k")
+
+	expectReply(t, c, errorReply("ERR syntax error"), "GETEX", "k", "EX", "10", "PERSIST")
+	expectReply(t, c, errorReply("ERR syntax error"), "GETEX", "k", "KEEPTTL")
+	run(c, "RPUSH", "list", "a")
+	expectReply(t, c, errorReply("WRONGTYPE Operation against a key holding the wrong kind of value"), "GETEX", "list")
+}
+
+// TestLargeKeyExpiresImmediately checks that a big list is gone from every
+// read as soon as its deadline passes, whether or not the active expiry
+// sweep has removed it yet.
+func TestLargeKeyExpiresImmediately(t *testing.T) {
+	c := newTestClient(t)
+	values := make([]string, 100000)
+	for i := range values {
+		values[i] = strconv.Itoa(i)
+	}
+	run(c, append([]string{"RPUSH", "big"}, values...)...)
+	run(c, "XADD", "s", "*", "f", "v")
+	run(c, "PEXPIRE", "big", "20")
+	run(c, "PEXPIRE", "s", "20")
+	time.Sleep(40 * time.Millisecond)
+
+	expectReply(t, c, intReply(0), "EXISTS", "big", "s")
+	expectReply(t, c, intReply(0), "LLEN", "big")
+	expectReply(t, c, RespData{Type: SimpleString, Str: "none"}, "TYPE", "big")
+	expectReply(t, c, nullReply(), "LPOP", "big")
+	expectReply(t, c, intReply(0), "XLEN", "s")
+	expectReply(t, c, intReply(-2), "PTTL", "big")
+}
+
+// TestTTLRounding checks that TTL rounds the remaining time to the nearest
+// second, as Redis does, while PTTL reports it in milliseconds.
+func TestTTLRounding(t *testing.T) {
+	for _, tt := range []struct{ ms, want int64 }{
+		{0, 0}, {1, 0}, {499, 0}, {500, 1}, {1000, 1}, {1400, 1},
+		{1499, 1}, {1500, 2}, {1501, 2}, {99999, 100},
+	} {
+		if got := ttlSeconds(tt.ms); got != tt.want {
+			t.Errorf("ttlSeconds(%d) = %d, want %d", tt.ms, got, tt.want)
+		}
+	}
+
+	// Against a live key, with room for the time the commands take
+	c := newTestClient(t)
+	run(c, "SET", "k", "v", "PX", "1500")
+	if ms := run(c, "PTTL", "k").Num; ms <= 1400 || ms > 1500 {
+		t.Errorf("PTTL after PX 1500: got %d, want about 1500", ms)
+	}
+	run(c, "SET", "k", "v", "PX", "1800")
+	expectReply(t, c, intReply(2), "TTL", "k")
+	run(c, "SET", "k", "v", "PX", "1400")
+	expectReply(t, c, intReply(1), "TTL", "k")
+	run(c, "SET", "k", "v", "PX", "400")
+	expectReply(t, c, intReply(0), "TTL", "k")
+}
+
+func TestActiveExpireRemovesUnreadKeys(t *testing.T) {
+	c := newTestClient(t)
+	s := server
+	s.activeExpireInterval = 10 * time.Millisecond
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.runActiveExpire()
+	}()
+	t.Cleanup(func() {
+		s.Shutdown()
+		<-done
+	})
+
+	for i := range 100 {
+		run(c, "SET", "k"+strconv.Itoa(i), "v", "PX", "20")
+	}
+	run(c, "SET", "kept", "v", "EX", "100")
+	run(c, "SET", "plain", "v")
+	db := c.db
+	// Nothing reads the keys, so only the sweeper can remove them
+	waitFor(t, "expired keys to be swept", func() bool {
+		db.mu.RLock()
+		defer db.mu.RUnlock()
+		return len(db.M) == 2
+	})
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	for _, key := range []string{"kept", "plain"} {
+		if _, ok := db.M[key]; !ok {
+			t.Errorf("%s was removed", key)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+// globMatch reports whether str matches the Redis glob pattern: '*' matches
+// any run of bytes, '?' any single byte, '[...]' a set of bytes (with '^'
+// to negate and 'a-z' ranges), and '\' escapes the next byte. Matching is
+// on bytes, like Redis.
+func globMatch(pattern, str string) bool {
+	p, s := 0, 0
+	// Where to resume after the last '*' if the rest fails to match
+	starP, starS := -1, 0
+	for s < len(str) {
+		if p < len(pattern) {
+			switch pattern[p] {
+			case '*':
+				for p < len(pattern) && pattern[p] == '*' {
+					p++
+				}
+				if p == len(pattern) {
+					return true
+				}
+				starP, starS = p, s
+				continue
+			case '?':
+				p++
+				s++
+				continue
+			case '[':
+				if end, ok := matchClass(pattern, p, str[s]); ok {
+					p = end
+					s++
+					continue
+				}
+			case '\\':
+				if p+1 < len(pattern) {
+					if pattern[p+1] == str[s] {
+						p += 2
+						s++
+						continue
+					}
+					break
+				}
+				fallthrough
+			default:
+				if pattern[p] == str[s] {
+					p++
+					s++
+					continue
+				}
+			}
+		}
+		if starP == -1 {
+			return false
+		}
+		starS++
+		p, s = starP, starS
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// matchClass matches c against the '[...]' set starting at pattern[start]
+// and returns the index just past the set. An unterminated set runs to the
+// end of the pattern, as in Redis.
+func matchClass(pattern string, start int, c byte) (int, bool) {
+	p := start + 1
+	negate := p < len(pattern) && pattern[p] == '^'
+	if negate {
+		p++
+	}
+	matched := false
+	for p < len(pattern) && pattern[p] != ']' {
+		switch {
+		case pattern[p] == '\\' && p+1 < len(pattern):
+			p++
+			if pattern[p] == c {
+				matched = true
+			}
+		case p+2 < len(pattern) && pattern[p+1] == '-' && pattern[p+2] != ']':
+			lo, hi := pattern[p], pattern[p+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			if c >= lo && c <= hi {
+				matched = true
+			}
+			p += 2
+		default:
+			if pattern[p] == c {
+				matched = true
+			}
+		}
+		p++
+	}
+	if p < len(pattern) {
+		p++ // closing ']'
+	}
+	return p, matched != negate
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestGlobMatch(t *testing.T) {
+	tests := []struct {
+		pattern, str string
+		want         bool
+	}{
+		{"*", "", true},
+		{"*", "anything", true},
+		{"user:*", "user:1", true},
+		{"user:*", "users:1", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h*llo", "heeeello", true},
+		{"h*llo", "hello world", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"h[a-c]llo", "hdllo", false},
+		{`h\*llo`, "h*llo", true},
+		{`h\*llo`, "hello", false},
+		{`\[x]`, "[x]", true},
+		{"a*b*c", "aXbYc", true},
+		{"a*b*c", "aXbY", false},
+		{"", "", true},
+		{"", "x", false},
+	}
+	for _, tt := range tests {
+		if got := globMatch(tt.pattern, tt.str); got != tt.want {
+			t.Errorf("globMatch(%q, %q) = %v, want %v", tt.pattern, tt.str, got, tt.want)
+		}
+	}
+}
+
+func TestKeysPattern(t *testing.T) {
+	c := newTestClient(t)
+	expectReply(t, c, okReply(), "DEBUG", "SORT-REPLIES", "ON")
+	run(c, "MSET", "user:1", "a", "user:2", "b", "users", "c", "other", "d")
+
+	keys := func(pattern string) []string {
+		var names []string
+		for _, k := range run(c, "KEYS", pattern).Array {
+			names = append(names, k.Str)
+		}
+		return names
+	}
+	if got := keys("user:*"); !slices.Equal(got, []string{"user:1", "user:2"}) {
+		t.Errorf("KEYS user:*: got %v", got)
+	}
+	if got := keys("user?"); !slices.Equal(got, []string{"users"}) {
+		t.Errorf("KEYS user?: got %v", got)
+	}
+	if got := keys("*"); len(got) != 4 {
+		t.Errorf("KEYS *: got %v", got)
+	}
+	// No match is an empty array, not a null one
+	expectReply(t, c, RespData{Type: Array, Array: []RespData{}}, "KEYS", "nomatch*")
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// newTestClient installs a fresh server with its RDB file in a temporary
+// directory and returns a client connected to database 0.
+func newTestClient(t *testing.T) *ClientConn {
+	t.Helper()
+	server = NewServer(t.TempDir(), "dump.rdb", "6379", defaultDatabases)
+	return connectTestClient(t)
+}
+
+// connectTestClient returns another client of the current server. The
+// client sits on one end of an in-memory pipe whose other end discards what
+// it reads; commands are run with run rather than over the wire.
+func connectTestClient(t *testing.T) *ClientConn {
+	t.Helper()
+	conn, peer := net.Pipe()
+	// Drain anything written to the client, such as pub/sub messages
+	go io.Copy(io.Discard, peer)
+	c := NewClientConn(conn, NewRESPreader(conn))
+	t.Cleanup(func() {
+		pubsub.UnsubscribeAll(c)
+		conn.Close()
+		peer.Close()
+	})
+	return c
+}
+
+// waitFor polls cond until it holds, failing the test after a few seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// run executes a command for c and returns its reply.
+func run(c *ClientConn, args ...string) RespData {
+	return executeCommand(Command{cmd: args[0], args: args[1:]}, c, false)
+}
+
+// expectReply runs a command and fails the test unless the reply has the
+// given type and string or integer value.
+func expectReply(t *testing.T, c *ClientConn, want RespData, args ...string) {
+	t.Helper()
+	got := run(c, args...)
+	if got.Type != want.Type || got.Str != want.Str || got.Num != want.Num || got.IsNull != want.IsNull {
+		t.Errorf("%v: got %+v, want %+v", args, got, want)
+	}
+}
+
+func okReply() RespData              { return RespData{Type: SimpleString, Str: "OK"} }
+func bulkReply(s string) RespData    { return RespData{Type: BulkString, Str: s} }
+func nullReply() RespData            { return RespData{Type: BulkString, IsNull: true} }
+func intReply(n int64) RespData      { return RespData{Type: Integer, Num: n} }
+func errorReply(msg string) RespData { return RespData{Type: Error, Str: msg} }
+
+// dialTestServer installs a fresh server and serves one connection over an
+// in-memory pipe, returning the client's end and a reader for its replies.
+func dialTestServer(t *testing.T) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	server = NewServer(t.TempDir(), "dump.rdb", "6379", defaultDatabases)
+	return dialTestConn(t)
+}
+
+// dialTestConn serves another connection of the current server, like
+// dialTestServer.
+func dialTestConn(t *testing.T) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, peer := net.Pipe()
+	served := make(chan struct{})
+	go func() {
+		handleConnection(peer)
+		close(served)
+	}()
+	// Later tests replace server, so the connection must be gone by then
+	t.Cleanup(func() {
+		conn.Close()
+		<-served
+	})
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return conn, bufio.NewReader(conn)
+}
+
+// expectLine reads one reply line from r and compares it with want.
+func expectLine(t *testing.T, r *bufio.Reader, want string) {
+	t.Helper()
+	line, err := r.ReadString('\n')
+	if err != nil {
+		t.Fatalf("reading reply: %v", err)
+	}
+	if line != want {
+		t.Errorf("got %q, want %q", line, want)
+	}
+}
+
+// testSubscriber is a client of the current server in subscriber mode whose
+// incoming messages are collected rather than discarded.
+type testSubscriber struct {
+	c        *ClientConn
+	messages chan [2]string // channel and message
+}
+
+// testSyncChannel is published to by received to mark how far it has read.
+const testSyncChannel = "__test_sync__"
+
+// subscribeTestClient returns a client of the current server subscribed to
+// channels.
+func subscribeTestClient(t *testing.T, channels ...string) *testSubscriber {
+	t.Helper()
+	conn, peer := net.Pipe()
+	s := &testSubscriber{
+		c:        NewClientConn(conn, NewRESPreader(conn)),
+		messages: make(chan [2]string, 64),
+	}
+	go func() {
+		defer close(s.messages)
+		r := NewRESPreader(peer)
+		for {
+			frame, _, err := r.Read()
+			if err != nil {
+				return
+			}
+			if len(frame.Array) == 3 && frame.Array[0].Str == "message" {
+				s.messages <- [2]string{frame.Array[1].Str, frame.Array[2].Str}
+			}
+		}
+	}()
+	t.Cleanup(func() {
+		pubsub.UnsubscribeAll(s.c)
+		conn.Close()
+		peer.Close()
+	})
+	run(s.c, append([]string{"SUBSCRIBE", testSyncChannel}, channels...)...)
+	return s
+}
+
+// received returns the messages delivered to s since the last call.
+func (s *testSubscriber) received(t *testing.T) [][2]string {
+	t.Helper()
+	pubsub.Publish(testSyncChannel, "")
+	var got [][2]string
+	for msg := range s.messages {
+		if msg[0] == testSyncChannel {
+			return got
+		}
+		got = append(got, msg)
+	}
+	t.Fatal("subscriber connection closed")
+	return nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// serveHTTP exposes /health for liveness probes and /metrics with the INFO
+// stats counters in the Prometheus text format.
+func serveHTTP(addr string) error {
+	return http.ListenAndServe(addr, httpHandler())
+}
+
+func httpHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /health", handleHealth)
+	mux.HandleFunc("GET /metrics", handleMetrics)
+	return mux
+}
+
+// handleHealth is only reachable once the RESP listener is bound, so
+// answering at all means the server is accepting commands.
+func handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "OK")
+}
+
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	var sb strings.Builder
+	for _, stat := range server.statsFields() {
+		name := "redis_" + stat.name
+		fmt.Fprintf(&sb, "# TYPE %s counter\n%s %d\n", name, name, stat.value)
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(sb.String()))
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func httpGet(t *testing.T, url string) (int, string) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+func TestHTTPEndpoints(t *testing.T) {
+	c := newTestClient(t)
+	ts := httptest.NewServer(httpHandler())
+	defer ts.Close()
+
+	if code, _ := httpGet(t, ts.URL+"/health"); code != http.StatusOK {
+		t.Errorf("/health: status %d, want 200", code)
+	}
+
+	run(c, "SET", "k", "v")
+	run(c, "GET", "k")
+	code, body := httpGet(t, ts.URL+"/metrics")
+	if code != http.StatusOK {
+		t.Errorf("/metrics: status %d, want 200", code)
+	}
+	want := "redis_keyspace_hits " + infoField(t, c, "keyspace_hits") + "\n"
+	if !strings.Contains(body, "redis_total_commands_processed ") || !strings.Contains(body, want) {
+		t.Errorf("/metrics is missing counters:\n%s", body)
+	}
+
+	if code, _ := httpGet(t, ts.URL+"/nope"); code != http.StatusNotFound {
+		t.Errorf("/nope: status %d, want 404", code)
+	}
+}
@@ -1,8 +1,22 @@
 package main
 
-import "strconv"
+import (
+	"strconv"
+	"strings"
+	"time"
+)
 
-func handleLPushCommand(cmd Command) RespData {
+// ListWaiter is a client blocked in BLPOP, BRPOP, BLMOVE or BLMPOP on one
+// or more lists.
+type ListWaiter struct {
+	Keys []string
+	// Serve takes the client's element from key; it runs with db.mu held
+	Serve    func(key string) RespData
+	Response chan RespData
+	served   bool
+}
+
+func handleLPushCommand(cmd Command, db *DataBase) RespData {
 	if len(cmd.args) < 2 {
 		return RespData{Type: Error, Str: "ERR wrong number of arguments for 'lpush' command"}
 	}
@@ -14,11 +28,12 @@ func handleLPushCommand(cmd Command) RespData {
 	if count == -1 {
 		return RespData{Type: Error, Str: "WRONGTYPE Operation against a key holding the wrong kind of value"}
 	}
+	db.notifyKeyspaceEvent(notifyList, "lpush", key)
 
 	return RespData{Type: Integer, Num: int64(count)}
 }
 
-func handleRPushCommand(cmd Command) RespData {
+func handleRPushCommand(cmd Command, db *DataBase) RespData {
 	if len(cmd.args) < 2 {
 		return RespData{Type: Error, Str: "ERR wrong number of arguments for 'rpush' command"}
 	}
@@ -30,37 +45,299 @@ func handleRPushCommand(cmd Command) RespData {
 	if count == -1 {
 		return RespData{Type: Error, Str: "WRONGTYPE Operation against a key holding the wrong kind of value"}
 	}
+	db.notifyKeyspaceEvent(notifyList, "rpush", key)
 
 	return RespData{Type: Integer, Num: int64(count)}
 }
 
-func handleLPopCommand(cmd Command) RespData {
+func handleLPopCommand(cmd Command, db *DataBase) RespData {
 	if len(cmd.args) != 1 {
 		return RespData{Type: Error, Str: "ERR wrong number of arguments for 'lpop' command"}
 	}
 
-	value := db.LPop(cmd.args[0])
+	value, err := db.LPop(cmd.args[0])
+	if err != nil {
+		return RespData{Type: Error, Str: err.Error()}
+	}
 	if value == nil {
 		return RespData{Type: BulkString, IsNull: true}
 	}
+	db.notifyKeyspaceEvent(notifyList, "lpop", cmd.args[0])
 
 	return RespData{Type: BulkString, Str: *value}
 }
 
-func handleRPopCommand(cmd Command) RespData {
+func handleRPopCommand(cmd Command, db *DataBase) RespData {
 	if len(cmd.args) != 1 {
 		return RespData{Type: Error, Str: "ERR wrong number of arguments for 'rpop' command"}
 	}
 
-	value := db.RPop(cmd.args[0])
+	value, err := db.RPop(cmd.args[0])
+	if err != nil {
+		return RespData{Type: Error, Str: err.Error()}
+	}
 	if value == nil {
 		return RespData{Type: BulkString, IsNull: true}
 	}
+	db.notifyKeyspaceEvent(notifyList, "rpop", cmd.args[0])
 
 	return RespData{Type: BulkString, Str: *value}
 }
 
-func handleLLenCommand(cmd Command) RespData {
+func handleBLPopCommand(cmd Command, clientConn *ClientConn) RespData {
+	return handleBlockingPop(cmd, clientConn, true)
+}
+
+func handleBRPopCommand(cmd Command, clientConn *ClientConn) RespData {
+	return handleBlockingPop(cmd, clientConn, false)
+}
+
+func handleBlockingPop(cmd Command, clientConn *ClientConn, left bool) RespData {
+	db := clientConn.db
+	name := "brpop"
+	if left {
+		name = "blpop"
+	}
+	if len(cmd.args) < 2 {
+		return RespData{Type: Error, Str: "ERR wrong number of arguments for '" + name + "' command"}
+	}
+
+	keys := cmd.args[:len(cmd.args)-1]
+	timeout, errReply := parseBlockingTimeout(cmd.args[len(cmd.args)-1], clientConn)
+	if errReply != nil {
+		return *errReply
+	}
+
+	closed, stop := clientConn.watchClose()
+	defer stop()
+	served := ""
+	reply := db.BlockOnLists(keys, timeout, closed, func(key string) RespData {
+		value, _ := db.popLocked(key, left)
+		served = key
+		return RespData{
+			Type: Array,
+			Array: []RespData{
+				{Type: BulkString, Str: key},
+				{Type: BulkString, Str: *value},
+			},
+		}
+	})
+	if served != "" {
+		db.notifyKeyspaceEvent(notifyList, popEvent(left), served)
+	}
+	return reply
+}
+
+func handleLMoveCommand(cmd Command, db *DataBase) RespData {
+	if len(cmd.args) != 4 {
+		return RespData{Type: Error, Str: "ERR wrong number of arguments for 'lmove' command"}
+	}
+
+	fromLeft, toLeft, ok := parseMoveDirections(cmd.args[2], cmd.args[3])
+	if !ok {
+		return RespData{Type: Error, Str: "ERR syntax error"}
+	}
+
+	value, err := db.LMove(cmd.args[0], cmd.args[1], fromLeft, toLeft)
+	if err != nil {
+		return RespData{Type: Error, Str: err.Error()}
+	}
+	if value == nil {
+		return RespData{Type: BulkString, IsNull: true}
+	}
+	db.notifyMove(cmd.args[0], cmd.args[1], fromLeft, toLeft)
+	return RespData{Type: BulkString, Str: *value}
+}
+
+func handleBLMoveCommand(cmd Command, clientConn *ClientConn) RespData {
+	db := clientConn.db
+	if len(cmd.args) != 5 {
+		return RespData{Type: Error, Str: "ERR wrong number of arguments for 'blmove' command"}
+	}
+
+	src, dst := cmd.args[0], cmd.args[1]
+	fromLeft, toLeft, ok := parseMoveDirections(cmd.args[2], cmd.args[3])
+	if !ok {
+		return RespData{Type: Error, Str: "ERR syntax error"}
+	}
+	timeout, errReply := parseBlockingTimeout(cmd.args[4], clientConn)
+	if errReply != nil {
+		return *errReply
+	}
+
+	closed, stop := clientConn.watchClose()
+	defer stop()
+	moved := false
+	reply := db.BlockOnLists([]string{src}, timeout, closed, func(key string) RespData {
+		value, err := db.moveLocked(src, dst, fromLeft, toLeft)
+		if err != nil {
+			return RespData{Type: Error, Str: err.Error()}
+		}
+		moved = true
+		return RespData{Type: BulkString, Str: *value}
+	})
+	if moved {
+		db.notifyMove(src, dst, fromLeft, toLeft)
+	}
+	return reply
+}
+
+func handleLMPopCommand(cmd Command, db *DataBase) RespData {
+	keys, left, count, errReply := parseMPopArgs(cmd.args, "lmpop")
+	if errReply != nil {
+		return *errReply
+	}
+
+	key, values, err := db.LMPop(keys, left, count)
+	if err != nil {
+		return RespData{Type: Error, Str: err.Error()}
+	}
+	if len(values) == 0 {
+		return RespData{Type: Array, IsNull: true}
+	}
+	db.notifyKeyspaceEvent(notifyList, popEvent(left), key)
+	return mpopReply(key, values)
+}
+
+func handleBLMPopCommand(cmd Command, clientConn *ClientConn) RespData {
+	db := clientConn.db
+	if len(cmd.args) < 1 {
+		return RespData{Type: Error, Str: "ERR wrong number of arguments for 'blmpop' command"}
+	}
+
+	keys, left, count, errReply := parseMPopArgs(cmd.args[1:], "blmpop")
+	if errReply != nil {
+		return *errReply
+	}
+	timeout, errReply := parseBlockingTimeout(cmd.args[0], clientConn)
+	if errReply != nil {
+		return *errReply
+	}
+
+	closed, stop := clientConn.watchClose()
+	defer stop()
+	served := ""
+	reply := db.BlockOnLists(keys, timeout, closed, func(key string) RespData {
+		served = key
+		return mpopReply(key, db.popManyLocked(key, left, count))
+	})
+	if served != "" {
+		db.notifyKeyspaceEvent(notifyList, popEvent(left), served)
+	}
+	return reply
+}
+
+// popEvent and pushEvent name the keyspace events for the end of a list
+// an element left or joined. Serve callbacks run under db.mu, so handlers
+// send these after BlockOnLists returns.
+func popEvent(left bool) string {
+	if left {
+		return "lpop"
+	}
+	return "rpop"
+}
+
+func pushEvent(left bool) string {
+	if left {
+		return "lpush"
+	}
+	return "rpush"
+}
+
+// notifyMove sends the events for an element moved by LMOVE or BLMOVE.
+func (db *DataBase) notifyMove(src, dst string, fromLeft, toLeft bool) {
+	db.notifyKeyspaceEvent(notifyList, popEvent(fromLeft), src)
+	db.notifyKeyspaceEvent(notifyList, pushEvent(toLeft), dst)
+}
+
+func mpopReply(key string, values []string) RespData {
+	elements := make([]RespData, len(values))
+	for i, v := range values {
+		elements[i] = RespData{Type: BulkString, Str: v}
+	}
+	return RespData{
+		Type: Array,
+		Array: []RespData{
+			{Type: BulkString, Str: key},
+			{Type: Array, Array: elements},
+		},
+	}
+}
+
+// parseMoveDirections parses the LEFT|RIGHT pair of LMOVE and BLMOVE.
+func parseMoveDirections(from, to string) (fromLeft, toLeft, ok bool) {
+	parse := func(dir string) (bool, bool) {
+		switch strings.ToLower(dir) {
+		case "left":
+			return true, true
+		case "right":
+			return false, true
+		}
+		return false, false
+	}
+	fromLeft, ok1 := parse(from)
+	toLeft, ok2 := parse(to)
+	return fromLeft, toLeft, ok1 && ok2
+}
+
+// parseMPopArgs parses "numkeys key [key ...] LEFT|RIGHT [COUNT count]".
+func parseMPopArgs(args []string, name string) ([]string, bool, int, *RespData) {
+	if len(args) < 3 {
+		return nil, false, 0, &RespData{Type: Error, Str: "ERR wrong number of arguments for '" + name + "' command"}
+	}
+
+	numKeys, err := strconv.Atoi(args[0])
+	if err != nil || numKeys <= 0 {
+		return nil, false, 0, &RespData{Type: Error, Str: "ERR numkeys should be greater than 0"}
+	}
+	if len(args) < numKeys+2 {
+		return nil, false, 0, &RespData{Type: Error, Str: "ERR syntax error"}
+	}
+	keys := args[1 : numKeys+1]
+	rest := args[numKeys+1:]
+
+	var left bool
+	switch strings.ToLower(rest[0]) {
+	case "left":
+		left = true
+	case "right":
+	default:
+		return nil, false, 0, &RespData{Type: Error, Str: "ERR syntax error"}
+	}
+
+	count := 1
+	rest = rest[1:]
+	if len(rest) > 0 {
+		if len(rest) != 2 || strings.ToLower(rest[0]) != "count" {
+			return nil, false, 0, &RespData{Type: Error, Str: "ERR syntax error"}
+		}
+		count, err = strconv.Atoi(rest[1])
+		if err != nil || count <= 0 {
+			return nil, false, 0, &RespData{Type: Error, Str: "ERR count should be greater than 0"}
+		}
+	}
+
+	return keys, left, count, nil
+}
+
+// parseBlockingTimeout reads a timeout in seconds as used by the blocking
+// list commands. Inside MULTI or a script the command must not block, which
+// is reported as a negative timeout.
+func parseBlockingTimeout(arg string, clientConn *ClientConn) (time.Duration, *RespData) {
+	seconds, err := strconv.ParseFloat(arg, 64)
+	if err != nil {
+		return 0, &RespData{Type: Error, Str: "ERR timeout is not a float or out of range"}
+	}
+	if seconds < 0 {
+		return 0, &RespData{Type: Error, Str: "ERR timeout is negative"}
+	}
+	if clientConn.isTransaction || clientConn.conn == nil {
+		return -1, nil
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+func handleLLenCommand(cmd Command, db *DataBase) RespData {
 	if len(cmd.args) != 1 {
 		return RespData{Type: Error, Str: "ERR wrong number of arguments for 'llen' command"}
 	}
@@ -69,7 +346,7 @@ func handleLLenCommand(cmd Command) RespData {
 	return RespData{Type: Integer, Num: int64(length)}
 }
 
-func handleLRangeCommand(cmd Command) RespData {
+func handleLRangeCommand(cmd Command, db *DataBase) RespData {
 	if len(cmd.args) != 3 {
 		return RespData{Type: Error, Str: "ERR wrong number of arguments for 'lrange' command"}
 	}
@@ -81,7 +358,10 @@ func handleLRangeCommand(cmd Command) RespData {
 		return RespData{Type: Error, Str: "ERR value is not an integer or out of range"}
 	}
 
-	values := db.LRange(cmd.args[0], start, stop)
+	values, err := db.LRange(cmd.args[0], start, stop)
+	if err != nil {
+		return RespData{Type: Error, Str: err.Error()}
+	}
 
 	respArray := make([]RespData, len(values))
 	for i, val := range values {
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+// listWaiterCount reports how many clients are blocked on key.
+func listWaiterCount(db *DataBase, key string) int {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return len(db.listWaiters[key])
+}
+
+func TestPushServesBlockedClientsInOrder(t *testing.T) {
+	pusher := newTestClient(t)
+	db := pusher.db
+
+	var replies [2]chan RespData
+	for i := range replies {
+		replies[i] = make(chan RespData, 1)
+		c := connectTestClient(t)
+		go func() { replies[i] <- run(c, "BLPOP", "list", "0") }()
+		// Block one client at a time so the order is known
+		waitFor(t, "BLPOP to block", func() bool { return listWaiterCount(db, "list") == i+1 })
+	}
+
+	expectReply(t, pusher, intReply(2), "LPUSH", "list", "a", "b")
+	for i, want := range []string{"b", "a"} {
+		got := <-replies[i]
+		if len(got.Array) != 2 || got.Array[0].Str != "list" || got.Array[1].Str != want {
+			t.Errorf("client %d: got %+v, want [list %s]", i, got, want)
+		}
+	}
+	// Both elements went to the blocked clients
+	expectReply(t, pusher, intReply(0), "LLEN", "list")
+	expectReply(t, pusher, intReply(0), "EXISTS", "list")
+}
+
+func TestBlockedClientDisconnectDoesNotLeak(t *testing.T) {
+	before := runtime.NumGoroutine()
+	conn, _ := dialTestServer(t)
+	db := server.databases[0]
+	if _, err := conn.Write([]byte("*3\r\n$5\r\nBLPOP\r\n$4\r\nlist\r\n$1\r\n0\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "BLPOP to block", func() bool { return listWaiterCount(db, "list") == 1 })
+
+	conn.Close()
+	waitFor(t, "the blocked client to be released", func() bool { return listWaiterCount(db, "list") == 0 })
+	waitFor(t, "the connection's goroutines to exit", func() bool { return runtime.NumGoroutine() <= before })
+}
+
+func TestShutdownReleasesBlockedClients(t *testing.T) {
+	c := newTestClient(t)
+	reply := make(chan RespData, 1)
+	go func() { reply <- run(c, "BLPOP", "list", "0") }()
+	waitFor(t, "BLPOP to block", func() bool { return listWaiterCount(c.db, "list") == 1 })
+
+	server.Shutdown()
+	if got := <-reply; got.Type != Array || !got.IsNull {
+		t.Errorf("BLPOP after shutdown: got %+v, want null array", got)
+	}
+}
+
+func TestPushOrder(t *testing.T) {
+	c := newTestClient(t)
+	expectReply(t, c, intReply(3), "LPUSH", "list", "a", "b", "c")
+	expectReply(t, c, intReply(5), "LPUSH", "list", "d", "e")
+	expectReply(t, c, intReply(7), "RPUSH", "list", "f", "g")
+	got := run(c, "LRANGE", "list", "0", "-1")
+	want := []string{"e", "d", "c", "b", "a", "f", "g"}
+	if len(got.Array) != len(want) {
+		t.Fatalf("LRANGE: got %+v, want %v", got, want)
+	}
+	for i := range want {
+		if got.Array[i].Str != want[i] {
+			t.Fatalf("LRANGE: got %+v, want %v", got, want)
+		}
+	}
+}
+
+func TestPopWrongType(t *testing.T) {
+	conn, r := dialTestServer(t)
+	// One write: the pipe is unbuffered, so the replies must be read before
+	// a second write could complete
+	if _, err := conn.Write([]byte("*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$1\r\nv\r\n" +
+		"*2\r\n$4\r\nLPOP\r\n$1\r\nk\r\n" +
+		"*2\r\n$4\r\nRPOP\r\n$1\r\nk\r\n" +
+		"*1\r\n$4\r\nPING\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	expectLine(t, r, "+OK\r\n")
+	expectLine(t, r, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+	expectLine(t, r, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+	expectLine(t, r, "+PONG\r\n")
+}
+
+// TestListCommandsOnExpiredString checks that list commands treat a string
+// past its deadline as a missing key rather than the wrong type.
+func TestListCommandsOnExpiredString(t *testing.T) {
+	c := newTestClient(t)
+	for _, key := range []string{"a", "b", "c", "d", "e", "f"} {
+		run(c, "SET", key, "v", "PX", "1")
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	expectReply(t, c, nullReply(), "LPOP", "a")
+	expectReply(t, c, nullReply(), "RPOP", "b")
+	expectReply(t, c, RespData{Type: Array, IsNull: true}, "LMPOP", "1", "c", "LEFT")
+	expectReply(t, c, intReply(1), "LPUSH", "d", "x")
+	expectReply(t, c, intReply(2), "RPUSH", "e", "y", "z")
+	expectReply(t, c, bulkReply("x"), "LMOVE", "d", "f", "LEFT", "LEFT")
+	expectReply(t, c, intReply(1), "LLEN", "f")
+	expectReply(t, c, intReply(-1), "TTL", "e")
+	expectReply(t, c, intReply(2), "DBSIZE")
+}
+
+func TestBLMoveUnblockedByPush(t *testing.T) {
+	pusher := newTestClient(t)
+	db := pusher.db
+	c := connectTestClient(t)
+	reply := make(chan RespData, 1)
+	go func() { reply <- run(c, "BLMOVE", "src", "dst", "LEFT", "RIGHT", "0") }()
+	waitFor(t, "BLMOVE to block", func() bool { return listWaiterCount(db, "src") == 1 })
+
+	run(pusher, "RPUSH", "dst", "old")
+	expectReply(t, pusher, intReply(1), "RPUSH", "src", "x")
+	if got := <-reply; got.Type != BulkString || got.Str != "x" {
+		t.Errorf("BLMOVE: got %+v, want x", got)
+	}
+	expectReply(t, pusher, intReply(0), "EXISTS", "src")
+	got := run(pusher, "LRANGE", "dst", "0", "-1")
+	if len(got.Array) != 2 || got.Array[0].Str != "old" || got.Array[1].Str != "x" {
+		t.Errorf("dst: got %+v, want [old x]", got)
+	}
+}
+
+func TestBlockingListTimeouts(t *testing.T) {
+	c := newTestClient(t)
+	// Like Redis, a timed out blocking command replies with a null array
+	expectReply(t, c, RespData{Type: Array, IsNull: true}, "BLMOVE", "src", "dst", "LEFT", "LEFT", "0.01")
+	expectReply(t, c, RespData{Type: Array, IsNull: true}, "BLMPOP", "0.01", "2", "a", "b", "LEFT")
+
+	run(c, "RPUSH", "b", "1", "2", "3")
+	got := run(c, "BLMPOP", "0", "2", "a", "b", "RIGHT", "COUNT", "2")
+	if len(got.Array) != 2 || got.Array[0].Str != "b" || len(got.Array[1].Array) != 2 ||
+		got.Array[1].Array[0].Str != "3" || got.Array[1].Array[1].Str != "2" {
+		t.Errorf("BLMPOP: got %+v, want [b [3 2]]", got)
+	}
+}
+
+func TestPushServesLongestWaiterOnly(t *testing.T) {
+	pusher := newTestClient(t)
+	db := pusher.db
+
+	var replies [3]chan RespData
+	for i := range replies {
+		replies[i] = make(chan RespData, 1)
+		c := connectTestClient(t)
+		go func() { replies[i] <- run(c, "BLPOP", "list", "0") }()
+		waitFor(t, "BLPOP to block", func() bool { return listWaiterCount(db, "list") == i+1 })
+	}
+
+	expectReply(t, pusher, intReply(1), "LPUSH", "list", "a")
+	if got := <-replies[0]; len(got.Array) != 2 || got.Array[1].Str != "a" {
+		t.Errorf("first client: got %+v, want [list a]", got)
+	}
+	if n := listWaiterCount(db, "list"); n != 2 {
+		t.Errorf("%d clients still blocked, want 2", n)
+	}
+	select {
+	case got := <-replies[1]:
+		t.Errorf("second client served early: %+v", got)
+	case got := <-replies[2]:
+		t.Errorf("third client served early: %+v", got)
+	default:
+	}
+
+	// The next push goes to the next client in line
+	run(pusher, "RPUSH", "list", "b")
+	if got := <-replies[1]; len(got.Array) != 2 || got.Array[1].Str != "b" {
+		t.Errorf("second client: got %+v, want [list b]", got)
+	}
+	run(pusher, "RPUSH", "list", "c")
+	<-replies[2]
+}
+
+// TestScriptPushesServeBlockedClientsAfter checks that a client blocked on
+// a list isn't served until the script or transaction that pushed to it
+// has finished, so they see their own pushes.
+func TestScriptPushesServeBlockedClientsAfter(t *testing.T) {
+	c := newTestClient(t)
+	blocked := connectTestClient(t)
+	reply := make(chan RespData, 1)
+	go func() { reply <- run(blocked, "BLPOP", "q", "0") }()
+	waitFor(t, "BLPOP to block", func() bool { return listWaiterCount(c.db, "q") == 1 })
+
+	expectReply(t, c, bulkReply("x"), "EVAL", "redis.call('rpush', KEYS[1], 'x') return redis.call('lpop', KEYS[1])", "1", "q")
+	run(c, "MULTI")
+	run(c, "RPUSH", "q", "y")
+	run(c, "LPOP", "q")
+	if got := run(c, "EXEC"); len(got.Array) != 2 || got.Array[1].Str != "y" {
+		t.Errorf("EXEC: got %+v, want [1 y]", got)
+	}
+	if n := listWaiterCount(c.db, "q"); n != 1 {
+		t.Fatalf("%d clients blocked on q, want 1", n)
+	}
+
+	expectReply(t, c, intReply(1), "EVAL", "return redis.call('rpush', KEYS[1], 'z')", "1", "q")
+	if got := <-reply; len(got.Array) != 2 || got.Array[1].Str != "z" {
+		t.Errorf("BLPOP: got %+v, want [q z]", got)
+	}
+	expectReply(t, c, intReply(0), "LLEN", "q")
+}
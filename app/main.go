@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"log"
@@ -8,25 +9,48 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 )
 
-var db *DataBase
+var server *Server
 
 func main() {
 	var (
-		dir        string
-		dbfilename string
-		port       string
+		dir               string
+		dbfilename        string
+		port              string
+		maxQueuedCommands int
+		commandsPerSecond int
+		httpPort          string
+		savePoints        string
+		bind              string
+		databases         int
 	)
 	// You can use print statements as follows for debugging, they'll be visible when running tests.
 	flag.StringVar(&dir, "dir", "~/redisdb", "location of database")
 	flag.StringVar(&dbfilename, "dbfilename", "data.rdb", "name of rdb file")
 	flag.StringVar(&port, "port", "6379", "port number for the server")
+	flag.StringVar(&bind, "bind", "0.0.0.0", "space separated addresses to listen on, as host or host:port (host uses --port)")
+	flag.IntVar(&databases, "databases", defaultDatabases, "number of databases SELECT can switch between")
+	flag.IntVar(&maxQueuedCommands, "max-queued-commands", 0, "maximum commands queued in a MULTI (0 for no limit)")
+	flag.IntVar(&commandsPerSecond, "commands-per-second", 0, "per-connection command rate limit (0 for no limit)")
+	flag.StringVar(&savePoints, "save", defaultSavePoints, "RDB save points as \"<seconds> <changes> ...\" (empty to disable)")
+	flag.StringVar(&httpPort, "http-port", "", "port for the /health and /metrics HTTP endpoints (disabled if empty)")
 	flag.Parse()
 	fmt.Println("Logs from your program will appear here!")
-	db = NewDatabase(dir, dbfilename, port)
+	if databases < 1 {
+		fmt.Println("Invalid --databases: must be at least 1")
+		os.Exit(1)
+	}
+	server = NewServer(dir, dbfilename, port, databases)
+	server.maxQueuedCommands.Store(int64(maxQueuedCommands))
+	server.commandsPerSecond.Store(int64(commandsPerSecond))
+	if err := server.setSavePoints(savePoints); err != nil {
+		fmt.Println("Invalid --save:", err)
+		os.Exit(1)
+	}
 
 	// Setup signal handling for graceful shutdown
 	sigChan := make(chan os.Signal, 1)
@@ -34,22 +58,17 @@ func main() {
 	go func() {
 		<-sigChan
 		fmt.Println("Saving database and shutting down...")
-		if err := db.SaveRDB(); err != nil {
+		server.Shutdown()
+		if err := server.SaveRDB(); err != nil {
 			fmt.Printf("Error saving RDB file: %v\n", err)
 		}
 		os.Exit(0)
 	}()
 
-	// Start periodic RDB saving
-	go func() {
-		ticker := time.NewTicker(1 * time.Minute)
-		defer ticker.Stop()
-		for range ticker.C {
-			if err := db.SaveRDB(); err != nil {
-				fmt.Printf("Error during periodic RDB save: %v\n", err)
-			}
-		}
-	}()
+	// Save automatically whenever a save point is met
+	go server.runAutosave()
+	// Delete expired keys that are never read again
+	go server.runActiveExpire()
 
 	// Expand home directory if needed
 	if dir[:2] == "~/" {
@@ -67,17 +86,64 @@ func main() {
 		os.Exit(1)
 	}
 
-	db.init()
-
-	l, err := net.Listen("tcp", "0.0.0.0:"+port)
-	if err != nil {
-		log.Println("Failed to bind to port" + port)
+	// Refuse to start on a bad RDB file; carrying on would overwrite it with
+	// an empty keyspace on the next save
+	if err := server.init(); err != nil {
+		fmt.Printf("Error loading RDB file: %v\n", err)
 		os.Exit(1)
 	}
+
+	var listeners []net.Listener
+	for _, addr := range listenAddrs(bind, port) {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			log.Println("Failed to bind to " + addr)
+			os.Exit(1)
+		}
+		listeners = append(listeners, l)
+	}
+
+	if httpPort != "" {
+		go func() {
+			if err := serveHTTP("0.0.0.0:" + httpPort); err != nil {
+				log.Println("HTTP listener stopped: ", err)
+			}
+		}()
+	}
+	for _, l := range listeners[1:] {
+		go acceptConnections(l)
+	}
+	acceptConnections(listeners[0])
+}
+
+// listenAddrs expands the --bind list into listen addresses. Entries
+// without a port use the --port value.
+func listenAddrs(bind, port string) []string {
+	var addrs []string
+	for _, entry := range strings.FieldsFunc(bind, func(r rune) bool { return r == ' ' || r == ',' }) {
+		if _, _, err := net.SplitHostPort(entry); err == nil {
+			addrs = append(addrs, entry)
+		} else {
+			addrs = append(addrs, net.JoinHostPort(entry, port))
+		}
+	}
+	if len(addrs) == 0 {
+		addrs = append(addrs, net.JoinHostPort("0.0.0.0", port))
+	}
+	return addrs
+}
+
+// acceptConnections serves every client accepted on l. All listeners share
+// the same keyspace.
+func acceptConnections(l net.Listener) {
 	for {
 		conn, err := l.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
 		if err != nil {
 			log.Println("Error accepting connection: ", err.Error())
+			continue
 		}
 		go handleConnection(conn)
 	}
@@ -85,19 +151,33 @@ func main() {
 
 func handleConnection(conn net.Conn) {
 	r := NewRESPreader(conn)
-	clientConn := ClientConn{conn: conn, isTransaction: false}
+	clientConn := NewClientConn(conn, r)
+	registerClient(clientConn)
+	defer unregisterClient(clientConn)
 	for {
+		r.maxBulkLen = server.protoMaxBulkLen.Load()
 		val, _, err := r.Read()
 		if err != nil {
+			if errors.Is(err, ErrInlineTooBig) || errors.Is(err, ErrMultiBulkLength) || errors.Is(err, ErrArrayTooDeep) {
+				r.WriteError("ERR " + err.Error())
+			}
+			pubsub.UnsubscribeAll(clientConn)
 			conn.Close()
 			return
 		}
+		// Redis silently ignores empty multi-bulk requests
+		if val.Type == Array && len(val.Array) == 0 {
+			continue
+		}
 		cmd, er := parseCmd(val)
 		log.Printf("Received command: %s", cmd.cmd)
 		if er != nil {
 			log.Println("Error parsing command: ", er)
 		}
-		handleCommand(cmd, r, &clientConn)
+		clientConn.lastActive = time.Now()
+		clientConn.lastCmd = strings.ToLower(cmd.cmd)
+		handleCommand(cmd, r, clientConn)
+		clientConn.publishStatus()
 	}
 
 }
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestEmptyMultiBulkIgnored(t *testing.T) {
+	conn, r := dialTestServer(t)
+	if _, err := conn.Write([]byte("*0\r\n*1\r\n$4\r\nPING\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	expectLine(t, r, "+PONG\r\n")
+
+	// So is a blank inline command
+	if _, err := conn.Write([]byte("\r\n*2\r\n$4\r\nECHO\r\n$2\r\nhi\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	expectLine(t, r, "$2\r\n")
+	expectLine(t, r, "hi\r\n")
+}
+
+func TestListenAddrs(t *testing.T) {
+	tests := []struct {
+		bind string
+		want []string
+	}{
+		{"", []string{"0.0.0.0:6379"}},
+		{"127.0.0.1", []string{"127.0.0.1:6379"}},
+		{"127.0.0.1 ::1", []string{"127.0.0.1:6379", "[::1]:6379"}},
+		{"127.0.0.1,127.0.0.1:7000", []string{"127.0.0.1:6379", "127.0.0.1:7000"}},
+	}
+	for _, tt := range tests {
+		if got := listenAddrs(tt.bind, "6379"); !slices.Equal(got, tt.want) {
+			t.Errorf("listenAddrs(%q) = %v, want %v", tt.bind, got, tt.want)
+		}
+	}
+}
+
+func TestListenersShareKeyspace(t *testing.T) {
+	server = NewServer(t.TempDir(), "dump.rdb", "6379", defaultDatabases)
+	// Later tests replace server, so the connections must be gone by then.
+	// Cleanups run last first, so this runs after they are closed.
+	t.Cleanup(func() {
+		waitFor(t, "the connections to close", func() bool {
+			clients.Lock()
+			defer clients.Unlock()
+			return len(clients.m) == 0
+		})
+	})
+	var conns []net.Conn
+	for _, addr := range listenAddrs("127.0.0.1:0 127.0.0.1:0", "6379") {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { l.Close() })
+		go acceptConnections(l)
+
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		conns = append(conns, conn)
+	}
+	if _, err := conns[0].Write([]byte("*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$1\r\nv\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	expectLine(t, bufio.NewReader(conns[0]), "+OK\r\n")
+
+	if _, err := conns[1].Write([]byte("*2\r\n$3\r\nGET\r\n$1\r\nk\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	r := bufio.NewReader(conns[1])
+	expectLine(t, r, "$1\r\n")
+	expectLine(t, r, "v\r\n")
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// MEMORY USAGE reports an estimate in the spirit of Redis: the allocations a
+// key would take there, not what the Go runtime actually holds. Aggregates
+// larger than the sample count are estimated from their first elements.
+
+const defaultMemorySamples = 5
+
+func handleMemoryCommand(cmd Command, db *DataBase) RespData {
+	if len(cmd.args) < 1 {
+		return RespData{Type: Error, Str: "ERR wrong number of arguments for 'memory' command"}
+	}
+
+	switch strings.ToLower(cmd.args[0]) {
+	case "usage":
+		return handleMemoryUsage(cmd.args[1:], db)
+	default:
+		return RespData{Type: Error, Str: "ERR unknown subcommand '" + cmd.args[0] + "'. Try MEMORY HELP."}
+	}
+}
+
+func handleMemoryUsage(args []string, db *DataBase) RespData {
+	if len(args) != 1 && len(args) != 3 {
+		return RespData{Type: Error, Str: "ERR syntax error"}
+	}
+
+	samples := defaultMemorySamples
+	if len(args) == 3 {
+		if strings.ToLower(args[1]) != "samples" {
+			return RespData{Type: Error, Str: "ERR syntax error"}
+		}
+		num, err := strconv.Atoi(args[2])
+		if err != nil {
+			return RespData{Type: Error, Str: "ERR value is not an integer or out of range"}
+		}
+		if num < 0 {
+			return RespData{Type: Error, Str: "ERR syntax error"}
+		}
+		samples = num
+	}
+
+	entry, ok := db.Peek(args[0])
+	if !ok {
+		return RespData{Type: BulkString, IsNull: true}
+	}
+	return RespData{Type: Integer, Num: int64(memoryUsage(args[0], entry, samples))}
+}
+
+// memoryUsage estimates the bytes used by key and its value. samples is the
+// number of elements of a list or stream measured; 0 measures all of them.
+func memoryUsage(key string, entry DBentry, samples int) int {
+	// dict entry and value object, plus the key itself
+	size := 24 + 16 + sdsSize(key)
+
+	switch entry.dataType {
+	case StringType:
+		if encodingOf(entry) != "int" {
+			size += sdsSize(entry.val)
+		}
+	case ListType:
+		nodes := quicklistNodes(entry.list, int(server.listMaxListpackSize.Load()))
+		size += 40 + nodes*(32+7) // quicklist, nodes and listpack headers
+		size += sampledSize(len(entry.list), samples, func(i int) int {
+			return len(entry.list[i]) + 2
+		})
+	case StreamType:
+		size += 64
+		size += sampledSize(len(entry.stream.Entries), samples, func(i int) int {
+			e := entry.stream.Entries[i]
+			n := 16 // entry ID
+			for field, val := range e.Fields {
+				n += len(field) + len(val) + 4
+			}
+			return n
+		})
+	}
+	return size
+}
+
+// sampledSize sums elemSize over n elements, or extrapolates from the first
+// samples elements when there are more than that.
+func sampledSize(n, samples int, elemSize func(i int) int) int {
+	measured := n
+	if samples > 0 && samples < n {
+		measured = samples
+	}
+	total := 0
+	for i := 0; i < measured; i++ {
+		total += elemSize(i)
+	}
+	if measured == 0 {
+		return 0
+	}
+	return total * n / measured
+}
+
+// sdsSize is the allocation of a Redis dynamic string of s.
+func sdsSize(s string) int {
+	switch {
+	case len(s) < 1<<8:
+		return len(s) + 3 + 1
+	case len(s) < 1<<16:
+		return len(s) + 5 + 1
+	default:
+		return len(s) + 9 + 1
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMemoryUsageSamples(t *testing.T) {
+	c := newTestClient(t)
+	// Element sizes repeat every 5, so the first 5 are representative
+	args := []string{"RPUSH", "big"}
+	for i := range 1000 {
+		args = append(args, strings.Repeat("x", 10+10*(i%5)))
+	}
+	run(c, args...)
+
+	exact := run(c, "MEMORY", "USAGE", "big", "SAMPLES", "0").Num
+	sampled := run(c, "MEMORY", "USAGE", "big", "SAMPLES", "5").Num
+	if exact <= 0 || sampled <= 0 {
+		t.Fatalf("MEMORY USAGE: exact %d, sampled %d", exact, sampled)
+	}
+	if ratio := float64(sampled) / float64(exact); ratio < 0.5 || ratio > 2 {
+		t.Errorf("SAMPLES 5 estimate %d is too far from the exact %d", sampled, exact)
+	}
+	expectReply(t, c, intReply(sampled), "MEMORY", "USAGE", "big")
+
+	// A list no longer than the sample count is measured exactly
+	run(c, "RPUSH", "small", "a", "bb", "ccc")
+	exact = run(c, "MEMORY", "USAGE", "small", "SAMPLES", "0").Num
+	expectReply(t, c, intReply(exact), "MEMORY", "USAGE", "small")
+	expectReply(t, c, intReply(exact), "MEMORY", "USAGE", "small", "SAMPLES", "3")
+
+	expectReply(t, c, nullReply(), "MEMORY", "USAGE", "missing")
+	expectReply(t, c, errorReply("ERR syntax error"), "MEMORY", "USAGE", "big", "COUNT", "5")
+	expectReply(t, c, errorReply("ERR syntax error"), "MEMORY", "USAGE", "big", "SAMPLES", "-1")
+	expectReply(t, c, errorReply("ERR value is not an integer or out of range"),
+		"MEMORY", "USAGE", "big", "SAMPLES", "five")
+}
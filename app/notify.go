@@ -0,0 +1,100 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Keyspace notification classes, selected with the notify-keyspace-events
+// config using the same letters as Redis.
+const (
+	notifyKeyspace = 1 << iota // K: __keyspace@<db>__:<key> channel
+	notifyKeyevent             // E: __keyevent@<db>__:<event> channel
+	notifyGeneric              // g: DEL, EXPIRE, RENAME, ...
+	notifyString               // $: string commands
+	notifyList                 // l: list commands
+	notifyStream               // t: stream commands
+	notifyExpired              // x: keys deleted because their TTL passed
+
+	notifyAll = notifyGeneric | notifyString | notifyList | notifyStream | notifyExpired // A
+)
+
+var notifyFlagChars = []struct {
+	char byte
+	flag int
+}{
+	{'K', notifyKeyspace},
+	{'E', notifyKeyevent},
+	{'g', notifyGeneric},
+	{'$', notifyString},
+	{'l', notifyList},
+	{'t', notifyStream},
+	{'x', notifyExpired},
+}
+
+// parseNotifyFlags converts a notify-keyspace-events string to flags. ok is
+// false if it contains an unknown class.
+func parseNotifyFlags(classes string) (flags int, ok bool) {
+	for i := 0; i < len(classes); i++ {
+		if classes[i] == 'A' {
+			flags |= notifyAll
+			continue
+		}
+		found := false
+		for _, fc := range notifyFlagChars {
+			if fc.char == classes[i] {
+				flags |= fc.flag
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, false
+		}
+	}
+	return flags, true
+}
+
+// notifyFlagsString renders flags back in notify-keyspace-events form.
+func notifyFlagsString(flags int) string {
+	var sb strings.Builder
+	if flags&notifyAll == notifyAll {
+		sb.WriteByte('A')
+	}
+	for _, fc := range notifyFlagChars {
+		if flags&fc.flag == 0 || (fc.flag&notifyAll != 0 && flags&notifyAll == notifyAll) {
+			continue
+		}
+		sb.WriteByte(fc.char)
+	}
+	return sb.String()
+}
+
+// notifyKeyspaceEvent publishes event on key to the keyspace and keyevent
+// channels of db enabled by notify-keyspace-events. class is the
+// notification class the event belongs to. It must be called without db.mu
+// held.
+func (db *DataBase) notifyKeyspaceEvent(class int, event, key string) {
+	flags := int(db.notifyFlags.Load())
+	if flags&class == 0 {
+		return
+	}
+	index := strconv.Itoa(db.id)
+	if flags&notifyKeyspace != 0 {
+		pubsub.Publish("__keyspace@"+index+"__:"+key, event)
+	}
+	if flags&notifyKeyevent != 0 {
+		pubsub.Publish("__keyevent@"+index+"__:"+event, key)
+	}
+}
+
+// expireEvent names the event for giving a key the unix millisecond
+// deadline at: "expire", or "del" if the deadline has passed and the key
+// was deleted instead.
+func expireEvent(at int64) string {
+	if at <= time.Now().UnixMilli() {
+		return "del"
+	}
+	return "expire"
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestKeyEventsUseDatabaseIndex(t *testing.T) {
+	c := newTestClient(t)
+	run(c, "CONFIG", "SET", "notify-keyspace-events", "E$")
+	sub := subscribeTestClient(t, "__keyevent@2__:set", "__keyevent@0__:set")
+
+	run(c, "SELECT", "2")
+	run(c, "SET", "k", "v")
+	want := [][2]string{{"__keyevent@2__:set", "k"}}
+	if got := sub.received(t); !slices.Equal(got, want) {
+		t.Errorf("SET in db 2: got %v, want %v", got, want)
+	}
+
+	run(c, "SELECT", "0")
+	run(c, "SET", "k", "v")
+	want = [][2]string{{"__keyevent@0__:set", "k"}}
+	if got := sub.received(t); !slices.Equal(got, want) {
+		t.Errorf("SET in db 0: got %v, want %v", got, want)
+	}
+}
+
+func TestStringAndGenericEvents(t *testing.T) {
+	c := newTestClient(t)
+	run(c, "CONFIG", "SET", "notify-keyspace-events", "KA")
+	sub := subscribeTestClient(t, "__keyspace@0__:k", "__keyspace@0__:k2")
+
+	tests := []struct {
+		args []string
+		want []string // events on k, then k2
+	}{
+		{[]string{"SET", "k", "1"}, []string{"k:set"}},
+		{[]string{"SET", "k", "1", "NX"}, nil},
+		{[]string{"SET", "k", "1", "EX", "100"}, []string{"k:set", "k:expire"}},
+		{[]string{"PERSIST", "k"}, []string{"k:persist"}},
+		{[]string{"PERSIST", "k"}, nil},
+		{[]string{"INCR", "k"}, []string{"k:incrby"}},
+		{[]string{"DECRBY", "k", "5"}, []string{"k:incrby"}},
+		{[]string{"INCRBYFLOAT", "k", "1.5"}, []string{"k:incrbyfloat"}},
+		{[]string{"APPEND", "k", "x"}, []string{"k:append"}},
+		{[]string{"SETRANGE", "k", "0", "y"}, []string{"k:setrange"}},
+		{[]string{"SETRANGE", "k", "0", ""}, nil},
+		{[]string{"SETEX", "k", "100", "v"}, []string{"k:set", "k:expire"}},
+		{[]string{"GETEX", "k", "PERSIST"}, []string{"k:persist"}},
+		{[]string{"GETEX", "k", "PX", "100000"}, []string{"k:expire"}},
+		{[]string{"EXPIRE", "k", "100"}, []string{"k:expire"}},
+		{[]string{"COPY", "k", "k2"}, []string{"k2:copy_to"}},
+		{[]string{"MSET", "k", "1", "k2", "2"}, []string{"k:set", "k2:set"}},
+		{[]string{"MSETNX", "k", "1"}, nil},
+		{[]string{"RENAME", "k", "k2"}, []string{"k:rename_from", "k2:rename_to"}},
+		{[]string{"GETDEL", "k2"}, []string{"k2:del"}},
+		{[]string{"SET", "k", "v"}, []string{"k:set"}},
+		{[]string{"DELETE", "k", "k2"}, []string{"k:del"}},
+		{[]string{"SET", "k", "v"}, []string{"k:set"}},
+		{[]string{"EXPIRE", "k", "0"}, []string{"k:del"}},
+	}
+	for _, tt := range tests {
+		if reply := run(c, tt.args...); reply.Type == Error {
+			t.Fatalf("%v: %s", tt.args, reply.Str)
+		}
+		var got []string
+		for _, msg := range sub.received(t) {
+			got = append(got, msg[0][len("__keyspace@0__:"):]+":"+msg[1])
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%v: got events %v, want %v", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestExpiredEvents(t *testing.T) {
+	c := newTestClient(t)
+	run(c, "CONFIG", "SET", "notify-keyspace-events", "Ex")
+	sub := subscribeTestClient(t, "__keyevent@0__:expired")
+	want := func(key string) [][2]string { return [][2]string{{"__keyevent@0__:expired", key}} }
+
+	// Lazily, when the key is next looked up
+	run(c, "SET", "lazy", "v", "PX", "10")
+	time.Sleep(20 * time.Millisecond)
+	expectReply(t, c, nullReply(), "GET", "lazy")
+	if got := sub.received(t); !slices.Equal(got, want("lazy")) {
+		t.Errorf("lazy expiry: got %v, want %v", got, want("lazy"))
+	}
+
+	// Actively, by the background sweep
+	run(c, "SET", "active", "v", "PX", "10")
+	time.Sleep(20 * time.Millisecond)
+	c.db.activeExpire(time.Now().Add(time.Second))
+	if got := sub.received(t); !slices.Equal(got, want("active")) {
+		t.Errorf("active expiry: got %v, want %v", got, want("active"))
+	}
+	expectReply(t, c, intReply(0), "DBSIZE")
+}
+
+func TestListEvents(t *testing.T) {
+	c := newTestClient(t)
+	run(c, "CONFIG", "SET", "notify-keyspace-events", "KA")
+	sub := subscribeTestClient(t, "__keyspace@0__:l", "__keyspace@0__:l2")
+
+	tests := []struct {
+		args []string
+		want []string // events on l, then l2
+	}{
+		{[]string{"RPUSH", "l", "a", "b", "c"}, []string{"l:rpush"}},
+		{[]string{"LPUSH", "l", "z"}, []string{"l:lpush"}},
+		{[]string{"LPOP", "l"}, []string{"l:lpop"}},
+		{[]string{"RPOP", "l"}, []string{"l:rpop"}},
+		{[]string{"LMOVE", "l", "l2", "RIGHT", "LEFT"}, []string{"l:rpop", "l2:lpush"}},
+		{[]string{"LMPOP", "1", "l", "LEFT"}, []string{"l:lpop"}},
+		{[]string{"LPOP", "l"}, nil},
+		{[]string{"RPOP", "l2"}, []string{"l2:rpop"}},
+	}
+	for _, tt := range tests {
+		if reply := run(c, tt.args...); reply.Type == Error {
+			t.Fatalf("%v: %s", tt.args, reply.Str)
+		}
+		var got []string
+		for _, msg := range sub.received(t) {
+			got = append(got, msg[0][len("__keyspace@0__:"):]+":"+msg[1])
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%v: got events %v, want %v", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestRenameEvents(t *testing.T) {
+	c := newTestClient(t)
+	run(c, "CONFIG", "SET", "notify-keyspace-events", "KEg")
+	sub := subscribeTestClient(t, "__keyspace@0__:src", "__keyspace@0__:dst",
+		"__keyevent@0__:rename_from", "__keyevent@0__:rename_to")
+
+	run(c, "SET", "src", "v")
+	run(c, "RENAME", "src", "dst")
+	want := [][2]string{
+		{"__keyspace@0__:src", "rename_from"},
+		{"__keyevent@0__:rename_from", "src"},
+		{"__keyspace@0__:dst", "rename_to"},
+		{"__keyevent@0__:rename_to", "dst"},
+	}
+	if got := sub.received(t); !slices.Equal(got, want) {
+		t.Errorf("RENAME: got %v, want %v", got, want)
+	}
+
+	run(c, "SET", "src", "v")
+	run(c, "RENAMENX", "src", "dst")
+	if got := sub.received(t); len(got) != 0 {
+		t.Errorf("RENAMENX onto an existing key: got events %v", got)
+	}
+	run(c, "RENAME", "missing", "dst")
+	if got := sub.received(t); len(got) != 0 {
+		t.Errorf("RENAME of a missing key: got events %v", got)
+	}
+}
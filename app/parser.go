@@ -7,6 +7,8 @@ import (
 	"io"
 	"net"
 	"strconv"
+	"strings"
+	"sync"
 )
 
 // RespType represents the type of RESP data
@@ -18,6 +20,8 @@ const (
 	Integer                      // :
 	BulkString                   // $
 	Array                        // *
+	Map                          // % (RESP3)
+	Batch                        // several replies written back to back
 )
 
 // RespData represents a RESP data structure
@@ -25,7 +29,7 @@ type RespData struct {
 	Type   RespType
 	Str    string     // for SimpleString, Error, and BulkString
 	Num    int64      // for Integer
-	Array  []RespData // for Array
+	Array  []RespData // for Array and Batch, and alternating keys and values for Map
 	IsNull bool       // for null bulk strings ($-1) or null arrays (*-1)
 }
 
@@ -48,6 +52,10 @@ func (r RespData) String() string {
 			return "Array(null)"
 		}
 		return fmt.Sprintf("%v", r.Array)
+	case Map:
+		return fmt.Sprintf("Map%v", r.Array)
+	case Batch:
+		return fmt.Sprintf("Batch%v", r.Array)
 	default:
 		return "Unknown"
 	}
@@ -61,8 +69,31 @@ func (r RespData) IsError() bool {
 type RESPreader struct {
 	reader *bufio.Reader
 	writer *bufio.Writer
+	// writeMu serializes replies with pub/sub messages written by other
+	// connections' goroutines
+	writeMu sync.Mutex
+	// maxBulkLen caps bulk string and inline request sizes; 0 means no limit
+	maxBulkLen int64
 }
 
+// ErrInlineTooBig is returned by Read when an inline request exceeds the
+// configured maximum length.
+var ErrInlineTooBig = errors.New("Protocol error: too big inline request")
+
+// Limits on the arrays a request may contain. Commands are flat arrays, so
+// nesting only shows up in malformed or hostile input.
+const (
+	maxMultiBulkLen = 1024 * 1024
+	maxArrayDepth   = 8
+)
+
+// ErrMultiBulkLength and ErrArrayTooDeep are returned by Read when a request
+// exceeds the array limits above.
+var (
+	ErrMultiBulkLength = errors.New("Protocol error: invalid multibulk length")
+	ErrArrayTooDeep    = errors.New("Protocol error: too deeply nested array")
+)
+
 func NewRESPreader(conn net.Conn) *RESPreader {
 	return &RESPreader{
 		reader: bufio.NewReader(conn),
@@ -70,7 +101,52 @@ func NewRESPreader(conn net.Conn) *RESPreader {
 	}
 }
 
+// Read reads a request, which is either a RESP value or an inline command
+// such as "PING\r\n" sent by telnet-style clients.
 func (r *RESPreader) Read() (RespData, int, error) {
+	firstByte, err := r.reader.Peek(1)
+	if err != nil {
+		return RespData{}, 0, err
+	}
+	switch firstByte[0] {
+	case '+', '-', ':', '$', '*':
+		return r.readValue(0)
+	default:
+		return r.readInline()
+	}
+}
+
+// readInline reads a line of space-separated arguments and returns it as an
+// array of bulk strings, like a multi-bulk request.
+func (r *RESPreader) readInline() (RespData, int, error) {
+	bytesRead := 0
+	line := make([]byte, 0, 64)
+	for {
+		curr, err := r.reader.ReadByte()
+		if err != nil {
+			return RespData{}, 0, err
+		}
+		bytesRead++
+		if curr == '\n' {
+			break
+		}
+		line = append(line, curr)
+		if r.maxBulkLen > 0 && int64(len(line)) > r.maxBulkLen {
+			return RespData{}, 0, ErrInlineTooBig
+		}
+	}
+
+	fields := strings.Fields(strings.TrimSuffix(string(line), "\r"))
+	arr := make([]RespData, len(fields))
+	for i, field := range fields {
+		arr[i] = RespData{Type: BulkString, Str: field}
+	}
+	return RespData{Type: Array, Array: arr}, bytesRead, nil
+}
+
+// readValue reads one RESP value. depth is the number of arrays it is
+// nested in.
+func (r *RESPreader) readValue(depth int) (RespData, int, error) {
 	bytesRead := 0
 	firstByte, err := r.reader.ReadByte()
 	if err != nil {
@@ -99,7 +175,7 @@ func (r *RESPreader) Read() (RespData, int, error) {
 		return RespData{Type: BulkString, Str: str, IsNull: isNull}, bytesRead, err
 
 	case '*':
-		arr, n, isNull, err := r.ReadArray()
+		arr, n, isNull, err := r.ReadArray(depth + 1)
 		bytesRead += n
 		return RespData{Type: Array, Array: arr, IsNull: isNull}, bytesRead, err
 
@@ -109,9 +185,14 @@ func (r *RESPreader) Read() (RespData, int, error) {
 
 }
 
-func (r *RESPreader) ReadArray() ([]RespData, int, bool, error) {
+// ReadArray reads the elements of an array at the given nesting depth,
+// starting with 1 for a top-level array.
+func (r *RESPreader) ReadArray(depth int) ([]RespData, int, bool, error) {
 	// log.Println("Reading array")
 	bytesRead := 0
+	if depth > maxArrayDepth {
+		return nil, 0, false, ErrArrayTooDeep
+	}
 	count, n, err := r.ReadInt()
 	if err != nil {
 		return nil, 0, false, err
@@ -119,14 +200,15 @@ func (r *RESPreader) ReadArray() ([]RespData, int, bool, error) {
 	if count == -1 {
 		return nil, 0, true, nil // Null array
 	}
-	if count < 0 {
-		return nil, 0, false, fmt.Errorf("invalid array length: %d", count)
+	if count < 0 || count > maxMultiBulkLen {
+		return nil, 0, false, ErrMultiBulkLength
 	}
 	bytesRead += n
 
-	result := make([]RespData, 0, count)
+	// Grow as elements arrive rather than trusting the declared length
+	result := make([]RespData, 0, min(count, 1024))
 	for range count {
-		item, n, err := r.Read()
+		item, n, err := r.readValue(depth)
 		bytesRead += n
 		if err != nil {
 			return nil, 0, false, err
@@ -148,7 +230,7 @@ func (r *RESPreader) ReadBulk() (string, int, bool, error) {
 	if length == -1 {
 		return "", 0, true, nil // Null bulk string
 	}
-	if length < 0 {
+	if length < 0 || (r.maxBulkLen > 0 && int64(length) > r.maxBulkLen) {
 		return "", 0, false, fmt.Errorf("invalid bulk string length: %d", length)
 	}
 	buf := make([]byte, length)
@@ -259,91 +341,43 @@ func (r *RESPreader) ReadString() (string, int, error) {
 	}
 }
 
+// Write serializes data, recursing into nested arrays and maps, and flushes
+// it to the connection.
 func (w *RESPreader) Write(data RespData) error {
-	switch data.Type {
-	case SimpleString:
-		return w.WriteSimpleString(data.Str)
-	case Error:
-		return w.WriteError(data.Str)
-	case Integer:
-		return w.WriteInteger(data.Num)
-	case BulkString:
-		if data.IsNull {
-			return w.WriteNull()
-		}
-		return w.WriteBulkString(data.Str)
-	case Array:
-		if data.IsNull {
-			return w.WriteNullArray()
-		}
-		return w.WriteArray(data.Array)
-	default:
-		return fmt.Errorf("unknown RESP type: %v", data.Type)
+	w.writeMu.Lock()
+	defer w.writeMu.Unlock()
+	if err := w.writeWithoutFlush(data); err != nil {
+		return err
 	}
+	return w.writer.Flush()
 }
 
 func (w *RESPreader) WriteSimpleString(s string) error {
-	_, err := w.writer.WriteString("+" + s + "\r\n")
-	if err != nil {
-		return err
-	}
-	return w.writer.Flush()
+	return w.Write(RespData{Type: SimpleString, Str: s})
 }
 
 func (w *RESPreader) WriteError(s string) error {
-	_, err := w.writer.WriteString("-" + s + "\r\n")
-	if err != nil {
-		return err
-	}
-	return w.writer.Flush()
+	return w.Write(RespData{Type: Error, Str: s})
 }
 
 func (w *RESPreader) WriteInteger(i int64) error {
-	_, err := w.writer.WriteString(":" + strconv.FormatInt(i, 10) + "\r\n")
-	if err != nil {
-		return err
-	}
-	return w.writer.Flush()
+	return w.Write(RespData{Type: Integer, Num: i})
 }
 
 func (w *RESPreader) WriteBulkString(s string) error {
-	_, err := w.writer.WriteString("$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n")
-	if err != nil {
-		return err
-	}
-	return w.writer.Flush()
+	return w.Write(RespData{Type: BulkString, Str: s})
 }
 
 func (w *RESPreader) WriteNull() error {
-	_, err := w.writer.WriteString("$-1\r\n")
-	if err != nil {
-		return err
-	}
-	return w.writer.Flush()
+	return w.Write(RespData{Type: BulkString, IsNull: true})
 }
 
 func (w *RESPreader) WriteNullArray() error {
-	_, err := w.writer.WriteString("*-1\r\n")
-	if err != nil {
-		return err
-	}
-	return w.writer.Flush()
+	return w.Write(RespData{Type: Array, IsNull: true})
 }
 
 func (w *RESPreader) WriteArray(arr []RespData) error {
-	_, err := w.writer.WriteString("*" + strconv.Itoa(len(arr)) + "\r\n")
-	if err != nil {
-		return err
-	}
-
-	for _, item := range arr {
-		err := w.writeWithoutFlush(item)
-		if err != nil {
-			return err
-		}
-	}
-
-	return w.writer.Flush()
+	return w.Write(RespData{Type: Array, Array: arr})
 }
 
 func (w *RESPreader) WriteCommand(cmd string, args ...string) error {
@@ -393,6 +427,30 @@ func (w *RESPreader) writeWithoutFlush(data RespData) error {
 			}
 		}
 		return nil
+	case Batch:
+		for _, item := range data.Array {
+			err := w.writeWithoutFlush(item)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case Map:
+		if len(data.Array)%2 != 0 {
+			return fmt.Errorf("map has an odd number of elements: %d", len(data.Array))
+		}
+		// Write pair count, then alternating keys and values
+		_, err := w.writer.WriteString("%" + strconv.Itoa(len(data.Array)/2) + "\r\n")
+		if err != nil {
+			return err
+		}
+		for _, item := range data.Array {
+			err := w.writeWithoutFlush(item)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
 	default:
 		return fmt.Errorf("unknown RESP type: %v", data.Type)
 	}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// encode returns the bytes Write sends for data.
+func encode(t *testing.T, data RespData) string {
+	t.Helper()
+	var buf bytes.Buffer
+	w := &RESPreader{writer: bufio.NewWriter(&buf)}
+	if err := w.Write(data); err != nil {
+		t.Fatalf("Write(%v): %v", data, err)
+	}
+	return buf.String()
+}
+
+func TestWriteNested(t *testing.T) {
+	tests := []struct {
+		name string
+		data RespData
+		want string
+	}{
+		{
+			"nested array",
+			RespData{Type: Array, Array: []RespData{
+				{Type: BulkString, Str: "a"},
+				{Type: Array, Array: []RespData{
+					{Type: Integer, Num: 1},
+					{Type: BulkString, IsNull: true},
+				}},
+				{Type: Array, IsNull: true},
+			}},
+			"*3\r\n$1\r\na\r\n*2\r\n:1\r\n$-1\r\n*-1\r\n",
+		},
+		{
+			"map",
+			RespData{Type: Map, Array: []RespData{
+				{Type: BulkString, Str: "name"},
+				{Type: BulkString, Str: "x"},
+				{Type: BulkString, Str: "list"},
+				{Type: Array, Array: []RespData{{Type: SimpleString, Str: "OK"}}},
+			}},
+			"%2\r\n$4\r\nname\r\n$1\r\nx\r\n$4\r\nlist\r\n*1\r\n+OK\r\n",
+		},
+		{
+			"batch",
+			RespData{Type: Batch, Array: []RespData{
+				{Type: Error, Str: "ERR x"},
+				{Type: Integer, Num: -2},
+			}},
+			"-ERR x\r\n:-2\r\n",
+		},
+	}
+	for _, tt := range tests {
+		if got := encode(t, tt.data); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWriteOddMap(t *testing.T) {
+	var buf bytes.Buffer
+	w := &RESPreader{writer: bufio.NewWriter(&buf)}
+	if err := w.Write(RespData{Type: Map, Array: []RespData{{Type: Integer, Num: 1}}}); err == nil {
+		t.Error("Write of a map with an odd element count succeeded")
+	}
+}
+
+func TestInlineCommands(t *testing.T) {
+	conn, r := dialTestServer(t)
+	if _, err := conn.Write([]byte("SET  k   \"v\"\r\nGET k\nPING\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	expectLine(t, r, "+OK\r\n")
+	expectLine(t, r, "$3\r\n")
+	expectLine(t, r, "\"v\"\r\n")
+	expectLine(t, r, "+PONG\r\n")
+}
+
+func TestInlineTooBig(t *testing.T) {
+	server = NewServer(t.TempDir(), "dump.rdb", "6379", defaultDatabases)
+	server.protoMaxBulkLen.Store(100)
+	conn, r := dialTestConn(t)
+	if _, err := conn.Write([]byte("PING " + strings.Repeat("x", 200) + "\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	expectLine(t, r, "-ERR Protocol error: too big inline request\r\n")
+	if _, err := r.ReadByte(); err != io.EOF {
+		t.Errorf("connection still open after the protocol error: %v", err)
+	}
+}
+
+// TestConfigSetWhileServing changes proto-max-bulk-len while another
+// connection is reading requests under it; run it with -race.
+func TestConfigSetWhileServing(t *testing.T) {
+	c := newTestClient(t)
+	conn, r := dialTestConn(t)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := range 100 {
+			run(c, "CONFIG", "SET", "proto-max-bulk-len", strconv.Itoa(1000+i))
+		}
+	}()
+	for range 100 {
+		if _, err := conn.Write([]byte("PING\r\n")); err != nil {
+			t.Fatal(err)
+		}
+		expectLine(t, r, "+PONG\r\n")
+	}
+	<-done
+}
+
+func TestArrayLimits(t *testing.T) {
+	tests := []struct {
+		name, request, reply string
+	}{
+		{"deep nesting", strings.Repeat("*1\r\n", 100_000), "-ERR Protocol error: too deeply nested array\r\n"},
+		{"huge multi-bulk", "*2000000\r\n", "-ERR Protocol error: invalid multibulk length\r\n"},
+	}
+	server = NewServer(t.TempDir(), "dump.rdb", "6379", defaultDatabases)
+	for _, tt := range tests {
+		conn, r := dialTestConn(t)
+		// The server stops reading at the error, so don't wait for the
+		// rest of the request to be taken
+		go conn.Write([]byte(tt.request))
+		line, err := r.ReadString('\n')
+		if err != nil || line != tt.reply {
+			t.Errorf("%s: got %q (%v), want %q", tt.name, line, err, tt.reply)
+		}
+		if _, err := r.ReadByte(); err != io.EOF {
+			t.Errorf("%s: connection still open after the protocol error: %v", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// savePoint triggers an automatic save once at least changes writes have
+// happened and seconds have passed since the last save, like Redis's
+// "save <seconds> <changes>" directive.
+type savePoint struct {
+	seconds int64
+	changes int64
+}
+
+const defaultSavePoints = "3600 1 300 100 60 10000"
+
+// parseSavePoints parses "<seconds> <changes> ..." pairs; an empty string
+// disables automatic saving.
+func parseSavePoints(s string) ([]savePoint, error) {
+	fields := strings.Fields(s)
+	if len(fields)%2 != 0 {
+		return nil, errors.New("save points must be pairs of seconds and changes")
+	}
+
+	points := make([]savePoint, 0, len(fields)/2)
+	for i := 0; i < len(fields); i += 2 {
+		seconds, err1 := strconv.ParseInt(fields[i], 10, 64)
+		changes, err2 := strconv.ParseInt(fields[i+1], 10, 64)
+		if err1 != nil || err2 != nil || seconds < 1 || changes < 0 {
+			return nil, fmt.Errorf("invalid save point %q %q", fields[i], fields[i+1])
+		}
+		points = append(points, savePoint{seconds: seconds, changes: changes})
+	}
+	return points, nil
+}
+
+func (s *Server) setSavePoints(spec string) error {
+	points, err := parseSavePoints(spec)
+	if err != nil {
+		return err
+	}
+	s.saveParams.Store(&points)
+	return nil
+}
+
+// savePointsString formats the save points as CONFIG GET save shows them.
+func (s *Server) savePointsString() string {
+	var parts []string
+	for _, p := range *s.saveParams.Load() {
+		parts = append(parts, strconv.FormatInt(p.seconds, 10), strconv.FormatInt(p.changes, 10))
+	}
+	return strings.Join(parts, " ")
+}
+
+// saveDue reports whether any save point is satisfied at now.
+func (s *Server) saveDue(now time.Time) bool {
+	dirty := s.dirty.Load()
+	elapsed := now.Unix() - s.lastSave.Load()
+	for _, p := range *s.saveParams.Load() {
+		if dirty >= p.changes && dirty > 0 && elapsed >= p.seconds {
+			return true
+		}
+	}
+	return false
+}
+
+// runAutosave checks the save points every second and saves in the
+// background when one is met, until the server shuts down.
+func (s *Server) runAutosave() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.closing:
+			return
+		case now := <-ticker.C:
+			if !s.saveDue(now) {
+				continue
+			}
+			if err := s.SaveRDB(); err != nil {
+				fmt.Printf("Error during automatic RDB save: %v\n", err)
+			}
+		}
+	}
+}
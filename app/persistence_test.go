@@ -0,0 +1,122 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestAutosave(t *testing.T) {
+	c := newTestClient(t)
+	expectReply(t, c, okReply(), "CONFIG", "SET", "save", "1 1")
+	s := server
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.runAutosave()
+	}()
+	t.Cleanup(func() {
+		s.Shutdown()
+		<-done
+	})
+
+	path := filepath.Join(s.dir, s.dbfilename)
+	run(c, "SET", "k", "v")
+	if dirty := infoField(t, c, "rdb_changes_since_last_save"); dirty != "1" {
+		t.Errorf("rdb_changes_since_last_save = %s, want 1", dirty)
+	}
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		if _, err := os.Stat(path); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no RDB file written within 3s")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	waitFor(t, "the dirty counter to reset", func() bool { return s.dirty.Load() == 0 })
+
+	// The saved file holds the write
+	server = NewServer(s.dir, s.dbfilename, "6379", defaultDatabases)
+	if err := server.LoadRDB(); err != nil {
+		t.Fatal(err)
+	}
+	expectReply(t, connectTestClient(t), bulkReply("v"), "GET", "k")
+}
+
+func TestBackgroundSaveIsPointInTime(t *testing.T) {
+	c := newTestClient(t)
+	s := server
+	for i := range 1000 {
+		run(c, "SET", "k"+strconv.Itoa(i), "before")
+	}
+	run(c, "RPUSH", "list", "a", "b")
+
+	expectReply(t, c, RespData{Type: SimpleString, Str: "Background saving started"}, "BGSAVE")
+	// Writes made once BGSAVE has replied are not in the file
+	for i := range 1000 {
+		run(c, "SET", "k"+strconv.Itoa(i), "after")
+	}
+	run(c, "RPUSH", "list", "c")
+	run(c, "SET", "new", "v")
+	waitFor(t, "the background save to finish", func() bool {
+		if !s.saveMu.TryLock() {
+			return false
+		}
+		s.saveMu.Unlock()
+		return true
+	})
+	expectReply(t, c, bulkReply("after"), "GET", "k0")
+
+	server = NewServer(s.dir, s.dbfilename, "6379", defaultDatabases)
+	if err := server.LoadRDB(); err != nil {
+		t.Fatal(err)
+	}
+	loaded := connectTestClient(t)
+	expectReply(t, loaded, intReply(1001), "DBSIZE")
+	for i := range 1000 {
+		if got := run(loaded, "GET", "k"+strconv.Itoa(i)); got.Str != "before" {
+			t.Fatalf("k%d = %q in the saved file, want before", i, got.Str)
+		}
+	}
+	expectReply(t, loaded, intReply(2), "LLEN", "list")
+}
+
+// BenchmarkSnapshot measures the copy BGSAVE makes under the read locks
+// before writing in the background.
+func BenchmarkSnapshot(b *testing.B) {
+	for _, size := range []int{1_000, 10_000, 100_000} {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			s := NewServer(b.TempDir(), "dump.rdb", "6379", defaultDatabases)
+			db := s.databases[0]
+			for i := range size {
+				db.Set("key"+strconv.Itoa(i), "value", SetOptions{ExpireAt: -1}, false)
+			}
+			b.ResetTimer()
+			for range b.N {
+				s.snapshot()
+			}
+		})
+	}
+}
+
+func TestConfigSave(t *testing.T) {
+	c := newTestClient(t)
+	pair := func(value string) RespData {
+		return RespData{Type: Array, Array: []RespData{bulkReply("save"), bulkReply(value)}}
+	}
+	expectReply(t, c, pair(defaultSavePoints), "CONFIG", "GET", "save")
+
+	expectReply(t, c, okReply(), "CONFIG", "SET", "save", "900 1  300 10")
+	expectReply(t, c, pair("900 1 300 10"), "CONFIG", "GET", "save")
+	expectReply(t, c, okReply(), "CONFIG", "SET", "save", "")
+	expectReply(t, c, pair(""), "CONFIG", "GET", "save")
+
+	for _, bad := range []string{"900", "900 x", "0 1", "10 -1"} {
+		expectReply(t, c, errorReply("ERR Invalid argument '"+bad+"' for CONFIG SET 'save'"), "CONFIG", "SET", "save", bad)
+	}
+	expectReply(t, c, pair(""), "CONFIG", "GET", "save")
+}
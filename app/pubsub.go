@@ -0,0 +1,164 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// PubSub is the server-wide registry of channel subscriptions.
+type PubSub struct {
+	mu       sync.RWMutex
+	channels map[string]map[*ClientConn]struct{} // channel -> subscribers
+}
+
+var pubsub = NewPubSub()
+
+func NewPubSub() *PubSub {
+	return &PubSub{channels: make(map[string]map[*ClientConn]struct{})}
+}
+
+func (ps *PubSub) Subscribe(client *ClientConn, channel string) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	subscribers, ok := ps.channels[channel]
+	if !ok {
+		subscribers = make(map[*ClientConn]struct{})
+		ps.channels[channel] = subscribers
+	}
+	subscribers[client] = struct{}{}
+}
+
+func (ps *PubSub) Unsubscribe(client *ClientConn, channel string) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	subscribers := ps.channels[channel]
+	delete(subscribers, client)
+	if len(subscribers) == 0 {
+		delete(ps.channels, channel)
+	}
+}
+
+// UnsubscribeAll removes client from every channel it is subscribed to. It
+// is called when the client disconnects so PUBLISH stops counting it.
+func (ps *PubSub) UnsubscribeAll(client *ClientConn) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	for channel := range client.subscriptions {
+		subscribers := ps.channels[channel]
+		delete(subscribers, client)
+		if len(subscribers) == 0 {
+			delete(ps.channels, channel)
+		}
+	}
+	clear(client.subscriptions)
+}
+
+// Publish sends message to every subscriber of channel and returns how many
+// clients it was delivered to.
+func (ps *PubSub) Publish(channel, message string) int {
+	ps.mu.RLock()
+	receivers := make([]*ClientConn, 0, len(ps.channels[channel]))
+	for client := range ps.channels[channel] {
+		receivers = append(receivers, client)
+	}
+	ps.mu.RUnlock()
+
+	frame := RespData{
+		Type: Array,
+		Array: []RespData{
+			{Type: BulkString, Str: "message"},
+			{Type: BulkString, Str: channel},
+			{Type: BulkString, Str: message},
+		},
+	}
+	for _, client := range receivers {
+		client.writer.Write(frame)
+	}
+	return len(receivers)
+}
+
+// subscriptionFrame builds the confirmation sent for each (un)subscribed
+// channel. A nil channel is sent as a null bulk string.
+func subscriptionFrame(kind string, channel *string, count int) RespData {
+	channelData := RespData{Type: BulkString, IsNull: true}
+	if channel != nil {
+		channelData = RespData{Type: BulkString, Str: *channel}
+	}
+	return RespData{
+		Type: Array,
+		Array: []RespData{
+			{Type: BulkString, Str: kind},
+			channelData,
+			{Type: Integer, Num: int64(count)},
+		},
+	}
+}
+
+// allowedWhileSubscribed lists the commands a client may send once it has
+// entered subscriber mode.
+var allowedWhileSubscribed = map[string]bool{
+	"subscribe":   true,
+	"unsubscribe": true,
+	"ping":        true,
+}
+
+func handleSubscribeCommand(cmd Command, clientConn *ClientConn) RespData {
+	if len(cmd.args) < 1 {
+		return RespData{Type: Error, Str: "ERR wrong number of arguments for 'subscribe' command"}
+	}
+
+	frames := make([]RespData, 0, len(cmd.args))
+	for _, channel := range cmd.args {
+		if _, ok := clientConn.subscriptions[channel]; !ok {
+			clientConn.subscriptions[channel] = struct{}{}
+			pubsub.Subscribe(clientConn, channel)
+		}
+		frames = append(frames, subscriptionFrame("subscribe", &channel, len(clientConn.subscriptions)))
+	}
+	return RespData{Type: Batch, Array: frames}
+}
+
+func handleUnsubscribeCommand(cmd Command, clientConn *ClientConn) RespData {
+	channels := cmd.args
+	if len(channels) == 0 {
+		// Unsubscribe from everything
+		for channel := range clientConn.subscriptions {
+			channels = append(channels, channel)
+		}
+		sort.Strings(channels)
+		if len(channels) == 0 {
+			return subscriptionFrame("unsubscribe", nil, 0)
+		}
+	}
+
+	frames := make([]RespData, 0, len(channels))
+	for _, channel := range channels {
+		if _, ok := clientConn.subscriptions[channel]; ok {
+			delete(clientConn.subscriptions, channel)
+			pubsub.Unsubscribe(clientConn, channel)
+		}
+		frames = append(frames, subscriptionFrame("unsubscribe", &channel, len(clientConn.subscriptions)))
+	}
+	return RespData{Type: Batch, Array: frames}
+}
+
+func handlePublishCommand(cmd Command) RespData {
+	if len(cmd.args) != 2 {
+		return RespData{Type: Error, Str: "ERR wrong number of arguments for 'publish' command"}
+	}
+
+	receivers := pubsub.Publish(cmd.args[0], cmd.args[1])
+	return RespData{Type: Integer, Num: int64(receivers)}
+}
+
+// subscribedModeError is returned for commands not allowed in subscriber mode.
+func subscribedModeError(cmd Command) RespData {
+	return RespData{
+		Type: Error,
+		Str:  "ERR Can't execute '" + strings.ToLower(cmd.cmd) + "': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context",
+	}
+}
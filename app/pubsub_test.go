@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// respCommand encodes args as a RESP array of bulk strings.
+func respCommand(args ...string) string {
+	s := fmt.Sprintf("*%d\r\n", len(args))
+	for _, arg := range args {
+		s += fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg)
+	}
+	return s
+}
+
+func TestBinarySafePayloads(t *testing.T) {
+	conn, r := dialTestServer(t)
+	sub := subscribeTestClient(t, "ch")
+	const payload = "a\r\nb\x00c\r\n"
+
+	req := respCommand("PUBLISH", "ch", payload) +
+		respCommand("SET", "k\r\n", payload) +
+		respCommand("GET", "k\r\n")
+	if _, err := io.WriteString(conn, req); err != nil {
+		t.Fatal(err)
+	}
+	expectLine(t, r, ":1\r\n")
+	expectLine(t, r, "+OK\r\n")
+	expectLine(t, r, fmt.Sprintf("$%d\r\n", len(payload)))
+	got := make([]byte, len(payload)+2)
+	if _, err := io.ReadFull(r, got); err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != payload+"\r\n" {
+		t.Errorf("GET: got %q, want %q", got, payload)
+	}
+
+	msgs := sub.received(t)
+	if len(msgs) != 1 || msgs[0] != [2]string{"ch", payload} {
+		t.Errorf("subscriber got %q, want [[ch %q]]", msgs, payload)
+	}
+}
+
+// expectLines reads one line per want.
+func expectLines(t *testing.T, r *bufio.Reader, want ...string) {
+	t.Helper()
+	for _, line := range want {
+		expectLine(t, r, line+"\r\n")
+	}
+}
+
+func TestSubscribePipelined(t *testing.T) {
+	conn, r := dialTestServer(t)
+	if _, err := io.WriteString(conn, respCommand("SUBSCRIBE", "ch")+respCommand("PING")+"PING hi\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	expectLines(t, r, "*3", "$9", "subscribe", "$2", "ch", ":1")
+	expectLines(t, r, "*2", "$4", "pong", "$0", "")
+	expectLines(t, r, "*2", "$4", "pong", "$2", "hi")
+}
+
+// frames summarises a (un)subscribe reply as "kind:channel:count" strings,
+// with "nil" for a null channel.
+func frames(reply RespData) []string {
+	batch := reply.Array
+	if reply.Type != Batch {
+		batch = []RespData{reply}
+	}
+	var out []string
+	for _, f := range batch {
+		if len(f.Array) != 3 {
+			return append(out, fmt.Sprintf("%+v", f))
+		}
+		channel := f.Array[1].Str
+		if f.Array[1].IsNull {
+			channel = "nil"
+		}
+		out = append(out, fmt.Sprintf("%s:%s:%d", f.Array[0].Str, channel, f.Array[2].Num))
+	}
+	return out
+}
+
+func TestUnsubscribeAll(t *testing.T) {
+	c := newTestClient(t)
+	run(c, "SUBSCRIBE", "c", "a", "b")
+	want := []string{"unsubscribe:a:2", "unsubscribe:b:1", "unsubscribe:c:0"}
+	if got := frames(run(c, "UNSUBSCRIBE")); !slices.Equal(got, want) {
+		t.Errorf("UNSUBSCRIBE: got %v, want %v", got, want)
+	}
+	if got := frames(run(c, "UNSUBSCRIBE")); !slices.Equal(got, []string{"unsubscribe:nil:0"}) {
+		t.Errorf("UNSUBSCRIBE with no subscriptions: got %v", got)
+	}
+	// Unknown channels still get a frame each
+	if got := frames(run(c, "UNSUBSCRIBE", "x", "y")); !slices.Equal(got, []string{"unsubscribe:x:0", "unsubscribe:y:0"}) {
+		t.Errorf("UNSUBSCRIBE x y: got %v", got)
+	}
+	expectReply(t, c, intReply(0), "PUBLISH", "a", "m")
+}
+
+// TestConcurrentPublishCount publishes while clients subscribe and
+// unsubscribe; run it with -race.
+func TestConcurrentPublishCount(t *testing.T) {
+	newTestClient(t)
+	const stable, churners = 3, 8
+	for range stable {
+		run(connectTestClient(t), "SUBSCRIBE", "ch")
+	}
+
+	var wg sync.WaitGroup
+	for range churners {
+		c := connectTestClient(t)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 200 {
+				run(c, "SUBSCRIBE", "ch")
+				run(c, "UNSUBSCRIBE", "ch")
+			}
+		}()
+	}
+	publisher := connectTestClient(t)
+	stop := make(chan struct{})
+	published := make(chan struct{})
+	go func() {
+		defer close(published)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if n := run(publisher, "PUBLISH", "ch", "m").Num; n < stable || n > stable+churners {
+				t.Errorf("PUBLISH reached %d clients, want %d..%d", n, stable, stable+churners)
+				return
+			}
+		}
+	}()
+	wg.Wait()
+	close(stop)
+	<-published
+
+	expectReply(t, publisher, intReply(stable), "PUBLISH", "ch", "m")
+}
+
+func TestDisconnectUnsubscribes(t *testing.T) {
+	conn, r := dialTestServer(t)
+	if _, err := io.WriteString(conn, respCommand("SUBSCRIBE", "ch", "other")); err != nil {
+		t.Fatal(err)
+	}
+	expectLines(t, r, "*3", "$9", "subscribe", "$2", "ch", ":1")
+	expectLines(t, r, "*3", "$9", "subscribe", "$5", "other", ":2")
+
+	conn.Close()
+	c := connectTestClient(t)
+	waitFor(t, "the subscriber to be removed", func() bool {
+		return run(c, "PUBLISH", "ch", "m").Num == 0
+	})
+	pubsub.mu.RLock()
+	defer pubsub.mu.RUnlock()
+	if len(pubsub.channels["ch"]) != 0 || len(pubsub.channels["other"]) != 0 {
+		t.Errorf("channels still have subscribers: %v", pubsub.channels)
+	}
+}
+
+func TestSubscribeCounts(t *testing.T) {
+	c := newTestClient(t)
+	want := []string{"subscribe:a:1", "subscribe:b:2", "subscribe:c:3"}
+	if got := frames(run(c, "SUBSCRIBE", "a", "b", "c")); !slices.Equal(got, want) {
+		t.Errorf("SUBSCRIBE a b c: got %v, want %v", got, want)
+	}
+	// Resubscribing doesn't count twice
+	want = []string{"subscribe:b:3", "subscribe:d:4"}
+	if got := frames(run(c, "SUBSCRIBE", "b", "d")); !slices.Equal(got, want) {
+		t.Errorf("SUBSCRIBE b d: got %v, want %v", got, want)
+	}
+	expectReply(t, connectTestClient(t), intReply(1), "PUBLISH", "b", "m")
+}
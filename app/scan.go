@@ -0,0 +1,132 @@
+package main
+
+import (
+	"hash/fnv"
+	"math/bits"
+	"time"
+)
+
+// SCAN walks a table of buckets kept alongside db.M, the way Redis walks its
+// dict. Each call visits buckets until it has seen count keys, so its cost
+// doesn't depend on the size of the keyspace. The cursor counts up from the
+// high bit rather than the low one: when the table doubles, bucket b splits
+// into b and b+size, and both come after every bucket already visited, so a
+// key that exists for the whole scan is never missed. Shrinking can return
+// a key twice.
+
+const (
+	minScanBuckets = 16
+	// scanEmptyVisits bounds the empty buckets one call looks at per key
+	// asked for, as in Redis
+	scanEmptyVisits = 10
+)
+
+// scanIndex holds db.M's keys by bucket. It is guarded by db.mu.
+type scanIndex struct {
+	buckets [][]string // power-of-two length, by scanHash
+	size    int
+}
+
+// scanHash places keys in scanIndex buckets.
+func scanHash(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64()
+}
+
+func (ix *scanIndex) bucket(key string) *[]string {
+	return &ix.buckets[scanHash(key)&uint64(len(ix.buckets)-1)]
+}
+
+func (ix *scanIndex) add(key string) {
+	if len(ix.buckets) == 0 {
+		ix.buckets = make([][]string, minScanBuckets)
+	}
+	b := ix.bucket(key)
+	*b = append(*b, key)
+	ix.size++
+	if ix.size > len(ix.buckets) {
+		ix.resize(len(ix.buckets) * 2)
+	}
+}
+
+func (ix *scanIndex) remove(key string) {
+	b := ix.bucket(key)
+	for i, k := range *b {
+		if k == key {
+			last := len(*b) - 1
+			(*b)[i] = (*b)[last]
+			*b = (*b)[:last]
+			ix.size--
+			break
+		}
+	}
+	if len(ix.buckets) > minScanBuckets && ix.size < len(ix.buckets)/8 {
+		ix.resize(len(ix.buckets) / 2)
+	}
+}
+
+func (ix *scanIndex) resize(n int) {
+	old := ix.buckets
+	ix.buckets = make([][]string, n)
+	for _, b := range old {
+		for _, key := range b {
+			nb := ix.bucket(key)
+			*nb = append(*nb, key)
+		}
+	}
+}
+
+// putLocked stores entry at key, adding key to the SCAN index if it is new.
+// Every write to db.M goes through here. The caller must hold db.mu.
+func (db *DataBase) putLocked(key string, entry DBentry) {
+	if _, exists := db.M[key]; !exists {
+		db.index.add(key)
+	}
+	db.M[key] = entry
+}
+
+// deleteLocked removes key from db.M and the SCAN index. The caller must
+// hold db.mu.
+func (db *DataBase) deleteLocked(key string) {
+	if _, exists := db.M[key]; exists {
+		delete(db.M, key)
+		db.index.remove(key)
+	}
+}
+
+// Scan returns the live keys of at least count keys' worth of buckets,
+// starting at cursor, and the cursor to continue from (0 once the scan is
+// complete). Only keys matching pattern are returned, but every key looked
+// at counts towards count.
+func (db *DataBase) Scan(cursor uint64, count int, pattern string) (uint64, []string) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.index.size == 0 {
+		return 0, nil
+	}
+
+	now := time.Now().UnixMilli()
+	mask := uint64(len(db.index.buckets) - 1)
+	var keys []string
+	seen, emptyVisits := 0, count*scanEmptyVisits
+	for {
+		bucket := db.index.buckets[cursor&mask]
+		if len(bucket) == 0 {
+			emptyVisits--
+		}
+		for _, key := range bucket {
+			seen++
+			if entry := db.M[key]; !entry.isExpired(now) && (pattern == "" || globMatch(pattern, key)) {
+				keys = append(keys, key)
+			}
+		}
+		// Set the bits above the mask so that incrementing the reversed
+		// cursor carries through the masked bits only
+		cursor |= ^mask
+		cursor = bits.Reverse64(bits.Reverse64(cursor) + 1)
+		if cursor == 0 || seen >= count || emptyVisits <= 0 {
+			return cursor, keys
+		}
+	}
+}
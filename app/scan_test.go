@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// scanAll runs a full SCAN with the given extra arguments, calling between
+// after each call, and returns how often each key was seen and how many
+// calls it took.
+func scanAll(t *testing.T, c *ClientConn, between func(), args ...string) (map[string]int, int) {
+	t.Helper()
+	seen := make(map[string]int)
+	cursor, calls := "0", 0
+	for {
+		reply := run(c, append([]string{"SCAN", cursor}, args...)...)
+		if reply.Type != Array || len(reply.Array) != 2 {
+			t.Fatalf("SCAN %s: got %+v", cursor, reply)
+		}
+		calls++
+		for _, key := range reply.Array[1].Array {
+			seen[key.Str]++
+		}
+		cursor = reply.Array[0].Str
+		if cursor == "0" {
+			return seen, calls
+		}
+		if calls > 10000 {
+			t.Fatal("SCAN did not finish")
+		}
+		if between != nil {
+			between()
+		}
+	}
+}
+
+func TestScanCount(t *testing.T) {
+	c := newTestClient(t)
+	for i := range 1000 {
+		run(c, "SET", "key"+strconv.Itoa(i), "v")
+	}
+
+	seen, calls := scanAll(t, c, nil, "COUNT", "100")
+	if calls < 8 || calls > 14 {
+		t.Errorf("SCAN COUNT 100 over 1000 keys took %d calls, want about 10", calls)
+	}
+	if len(seen) != 1000 {
+		t.Errorf("SCAN returned %d distinct keys, want 1000", len(seen))
+	}
+	for key, n := range seen {
+		if n != 1 {
+			t.Errorf("SCAN returned %s %d times", key, n)
+		}
+	}
+}
+
+func TestScanWhileKeyspaceGrows(t *testing.T) {
+	c := newTestClient(t)
+	for i := range 100 {
+		run(c, "SET", "key"+strconv.Itoa(i), "v")
+	}
+
+	// Each call adds enough keys to resize the table several times over
+	added := 0
+	seen, _ := scanAll(t, c, func() {
+		for range 50 {
+			run(c, "SET", fmt.Sprintf("new%d", added), "v")
+			added++
+		}
+	}, "COUNT", "10")
+	for i := range 100 {
+		if seen["key"+strconv.Itoa(i)] == 0 {
+			t.Errorf("SCAN missed key%d", i)
+		}
+	}
+}
+
+func TestScanWhileKeyspaceShrinks(t *testing.T) {
+	c := newTestClient(t)
+	for i := range 1000 {
+		run(c, "SET", "key"+strconv.Itoa(i), "v")
+	}
+
+	deleted := 999
+	seen, _ := scanAll(t, c, func() {
+		for range 50 {
+			if deleted >= 100 {
+				run(c, "DELETE", "key"+strconv.Itoa(deleted))
+				deleted--
+			}
+		}
+	}, "COUNT", "10")
+	for i := range 100 {
+		if seen["key"+strconv.Itoa(i)] == 0 {
+			t.Errorf("SCAN missed key%d", i)
+		}
+	}
+}
+
+func TestScanMatchAndExpired(t *testing.T) {
+	c := newTestClient(t)
+	run(c, "SET", "user:1", "v")
+	run(c, "SET", "user:2", "v")
+	run(c, "SET", "other", "v")
+	run(c, "SET", "user:gone", "v", "PX", "1")
+	time.Sleep(5 * time.Millisecond)
+
+	seen, _ := scanAll(t, c, nil, "MATCH", "user:*")
+	if len(seen) != 2 || seen["user:1"] != 1 || seen["user:2"] != 1 {
+		t.Errorf("SCAN MATCH user:*: got %v", seen)
+	}
+
+	expectReply(t, c, okReply(), "FLUSHDB")
+	reply := run(c, "SCAN", "0")
+	if reply.Array[0].Str != "0" || len(reply.Array[1].Array) != 0 {
+		t.Errorf("SCAN of an empty database: got %+v", reply)
+	}
+}
+
+// TestScanIndexTracksKeyspace runs commands that add and remove keys in
+// every way and checks the SCAN index still holds exactly db.M's keys.
+func TestScanIndexTracksKeyspace(t *testing.T) {
+	c := newTestClient(t)
+	for i := range 200 {
+		k := "k" + strconv.Itoa(i%40)
+		for _, args := range [][]string{
+			{"SET", k, "v"},
+			{"RPUSH", "l" + k, "a"},
+			{"LMOVE", "l" + k, "m" + k, "LEFT", "RIGHT"},
+			{"XADD", "s" + k, "*", "f", "v"},
+			{"RENAME", k, "r" + k},
+			{"COPY", "r" + k, "c" + k},
+			{"INCR", "n" + k},
+			{"GETDEL", "c" + k},
+			{"EXPIRE", "n" + k, "0"},
+			{"RPOP", "m" + k},
+			{"MSET", "a" + k, "1", "b" + k, "2"},
+			{"DELETE", "a" + k},
+		} {
+			run(c, args...)
+		}
+		if i == 100 {
+			run(c, "SWAPDB", "0", "1")
+			run(c, "SELECT", "1")
+		}
+		if i == 150 {
+			run(c, "FLUSHDB")
+		}
+	}
+
+	for _, db := range server.databases {
+		db.mu.RLock()
+		if db.index.size != len(db.M) {
+			t.Errorf("db %d: index holds %d keys, M holds %d", db.id, db.index.size, len(db.M))
+		}
+		for key := range db.M {
+			found := 0
+			for _, k := range *db.index.bucket(key) {
+				if k == key {
+					found++
+				}
+			}
+			if found != 1 {
+				t.Errorf("db %d: %s is in the index %d times", db.id, key, found)
+			}
+		}
+		db.mu.RUnlock()
+	}
+}
+
+func TestScanArguments(t *testing.T) {
+	c := newTestClient(t)
+	syntax := errorReply("ERR syntax error")
+	expectReply(t, c, errorReply("ERR wrong number of arguments for 'scan' command"), "SCAN")
+	expectReply(t, c, errorReply("ERR invalid cursor"), "SCAN", "-1")
+	expectReply(t, c, errorReply("ERR invalid cursor"), "SCAN", "abc")
+	expectReply(t, c, syntax, "SCAN", "0", "COUNT")
+	expectReply(t, c, syntax, "SCAN", "0", "COUNT", "0")
+	expectReply(t, c, syntax, "SCAN", "0", "LIMIT", "5")
+	expectReply(t, c, errorReply("ERR value is not an integer or out of range"), "SCAN", "0", "COUNT", "ten")
+
+	// An empty keyspace finishes at once with an empty batch
+	got := run(c, "SCAN", "0", "MATCH", "*", "COUNT", "5")
+	if len(got.Array) != 2 || got.Array[0].Str != "0" || got.Array[1].Type != Array || len(got.Array[1].Array) != 0 {
+		t.Errorf("SCAN of an empty keyspace: got %+v", got)
+	}
+}
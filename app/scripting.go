@@ -0,0 +1,150 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// EVAL and EVALSHA run Lua scripts (see eval.go).
+// Functions are not supported: FUNCTION answers with empty results so
+// clients probing for it during initialization keep working, while FCALL
+// fails with a clear error.
+
+const errNoScripting = "ERR This server does not support scripting"
+
+// scriptCache holds scripts by the hex SHA1 of their body, like Redis.
+var scriptCache = struct {
+	mu      sync.RWMutex
+	scripts map[string]string
+}{scripts: make(map[string]string)}
+
+func cacheScript(body string) string {
+	sum := sha1.Sum([]byte(body))
+	sha := hex.EncodeToString(sum[:])
+	scriptCache.mu.Lock()
+	scriptCache.scripts[sha] = body
+	scriptCache.mu.Unlock()
+	return sha
+}
+
+func lookupScript(sha string) (string, bool) {
+	scriptCache.mu.RLock()
+	defer scriptCache.mu.RUnlock()
+	body, ok := scriptCache.scripts[strings.ToLower(sha)]
+	return body, ok
+}
+
+func handleEvalCommand(cmd Command, clientConn *ClientConn) RespData {
+	var body string
+	switch strings.ToLower(cmd.cmd) {
+	case "eval":
+		if len(cmd.args) < 2 {
+			return RespData{Type: Error, Str: "ERR wrong number of arguments for 'eval' command"}
+		}
+		body = cmd.args[0]
+		cacheScript(body)
+	case "evalsha":
+		if len(cmd.args) < 2 {
+			return RespData{Type: Error, Str: "ERR wrong number of arguments for 'evalsha' command"}
+		}
+		var ok bool
+		if body, ok = lookupScript(cmd.args[0]); !ok {
+			return RespData{Type: Error, Str: "NOSCRIPT No matching script. Please use EVAL."}
+		}
+	default:
+		return RespData{Type: Error, Str: errNoScripting}
+	}
+
+	numKeys, err := strconv.Atoi(cmd.args[1])
+	if err != nil {
+		return RespData{Type: Error, Str: "ERR value is not an integer or out of range"}
+	}
+	if numKeys < 0 {
+		return RespData{Type: Error, Str: "ERR Number of keys can't be negative"}
+	}
+	if numKeys > len(cmd.args)-2 {
+		return RespData{Type: Error, Str: "ERR Number of keys can't be greater than number of args"}
+	}
+
+	keys := cmd.args[2 : 2+numKeys]
+	argv := cmd.args[2+numKeys:]
+	server.holdListServes()
+	defer server.releaseListServes()
+	return runScript(body, keys, argv, clientConn)
+}
+
+func handleScriptCommand(cmd Command) RespData {
+	if len(cmd.args) < 1 {
+		return RespData{Type: Error, Str: "ERR wrong number of arguments for 'script' command"}
+	}
+
+	switch strings.ToLower(cmd.args[0]) {
+	case "exists":
+		if len(cmd.args) < 2 {
+			return RespData{Type: Error, Str: "ERR wrong number of arguments for 'script|exists' command"}
+		}
+		results := make([]RespData, len(cmd.args)-1)
+		for i, sha := range cmd.args[1:] {
+			if _, ok := lookupScript(sha); ok {
+				results[i] = RespData{Type: Integer, Num: 1}
+			} else {
+				results[i] = RespData{Type: Integer, Num: 0}
+			}
+		}
+		return RespData{Type: Array, Array: results}
+	case "flush":
+		if len(cmd.args) > 2 {
+			return RespData{Type: Error, Str: "ERR wrong number of arguments for 'script|flush' command"}
+		}
+		if len(cmd.args) == 2 {
+			mode := strings.ToLower(cmd.args[1])
+			if mode != "sync" && mode != "async" {
+				return RespData{Type: Error, Str: "ERR SCRIPT FLUSH only support SYNC|ASYNC option"}
+			}
+		}
+		scriptCache.mu.Lock()
+		scriptCache.scripts = make(map[string]string)
+		scriptCache.mu.Unlock()
+		return RespData{Type: SimpleString, Str: "OK"}
+	case "load":
+		if len(cmd.args) != 2 {
+			return RespData{Type: Error, Str: "ERR wrong number of arguments for 'script|load' command"}
+		}
+		if _, err := compileScript(cmd.args[1]); err != nil {
+			return RespData{Type: Error, Str: "ERR Error compiling script: " + err.Error()}
+		}
+		return RespData{Type: BulkString, Str: cacheScript(cmd.args[1])}
+	default:
+		return RespData{Type: Error, Str: "ERR unknown subcommand '" + cmd.args[0] + "'. Try SCRIPT HELP."}
+	}
+}
+
+func handleFunctionCommand(cmd Command) RespData {
+	if len(cmd.args) < 1 {
+		return RespData{Type: Error, Str: "ERR wrong number of arguments for 'function' command"}
+	}
+
+	switch strings.ToLower(cmd.args[0]) {
+	case "list":
+		return RespData{Type: Array, Array: []RespData{}}
+	case "dump":
+		return RespData{Type: BulkString, IsNull: true}
+	case "stats":
+		return RespData{
+			Type: Array,
+			Array: []RespData{
+				{Type: BulkString, Str: "running_script"},
+				{Type: BulkString, IsNull: true},
+				{Type: BulkString, Str: "engines"},
+				{Type: Array, Array: []RespData{}},
+			},
+		}
+	case "flush":
+		return RespData{Type: SimpleString, Str: "OK"}
+	default:
+		return RespData{Type: Error, Str: errNoScripting}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestEvalUsesSelectedDatabase(t *testing.T) {
+	c := newTestClient(t)
+	expectReply(t, c, okReply(), "SELECT", "3")
+	expectReply(t, c, bulkReply("v"), "EVAL", "redis.call('set', KEYS[1], ARGV[1]) return redis.call('get', KEYS[1])", "1", "k", "v")
+
+	expectReply(t, c, bulkReply("v"), "GET", "k")
+	expectReply(t, c, okReply(), "SELECT", "0")
+	expectReply(t, c, nullReply(), "GET", "k")
+}
+
+func TestEvalSHA(t *testing.T) {
+	c := newTestClient(t)
+	expectReply(t, c, errorReply("ERR wrong number of arguments for 'evalsha' command"), "EVALSHA")
+	expectReply(t, c, errorReply("ERR wrong number of arguments for 'evalsha' command"), "EVALSHA", "abc")
+
+	sha := run(c, "SCRIPT", "LOAD", "return ARGV[1]").Str
+	expectReply(t, c, bulkReply("x"), "EVALSHA", sha, "0", "x")
+	expectReply(t, c, errorReply("NOSCRIPT No matching script. Please use EVAL."), "EVALSHA", "0000", "0")
+}
+
+func TestScriptCache(t *testing.T) {
+	c := newTestClient(t)
+	unknown := "0000000000000000000000000000000000000000"
+	expectReply(t, c, RespData{Type: Array, Array: []RespData{intReply(0)}}, "SCRIPT", "EXISTS", unknown)
+
+	sha := run(c, "SCRIPT", "LOAD", "return 1").Str
+	if len(sha) != 40 {
+		t.Fatalf("SCRIPT LOAD: got %q, want a 40 character sha", sha)
+	}
+	expectReply(t, c, RespData{Type: Array, Array: []RespData{intReply(1), intReply(0)}},
+		"SCRIPT", "EXISTS", strings.ToUpper(sha), unknown)
+
+	expectReply(t, c, okReply(), "SCRIPT", "FLUSH", "ASYNC")
+	expectReply(t, c, RespData{Type: Array, Array: []RespData{intReply(0)}}, "SCRIPT", "EXISTS", sha)
+	expectReply(t, c, errorReply("NOSCRIPT No matching script. Please use EVAL."), "EVALSHA", sha, "0")
+	expectReply(t, c, errorReply("ERR SCRIPT FLUSH only support SYNC|ASYNC option"), "SCRIPT", "FLUSH", "LATER")
+}
+
+func TestFunctionsUnsupported(t *testing.T) {
+	c := newTestClient(t)
+	expectReply(t, c, RespData{Type: Array, Array: []RespData{}}, "FUNCTION", "LIST")
+	expectReply(t, c, nullReply(), "FUNCTION", "DUMP")
+	if got := run(c, "FUNCTION", "STATS"); got.Type != Array || len(got.Array) != 4 || got.Array[0].Str != "running_script" {
+		t.Errorf("FUNCTION STATS: got %+v", got)
+	}
+	expectReply(t, c, errorReply(errNoScripting), "FCALL", "f", "0")
+	expectReply(t, c, errorReply(errNoScripting), "FCALL_RO", "f", "1", "k")
+}
+
+// TestScriptsAreAtomic checks that no other client's command runs between
+// a script's redis.calls, whether the script runs on its own or from EXEC.
+func TestScriptsAreAtomic(t *testing.T) {
+	conn, r := dialTestServer(t)
+	writer, wr := dialTestConn(t)
+	started, done := make(chan struct{}), make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			if i == 1 {
+				close(started)
+			}
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if _, err := io.WriteString(writer, respCommand("SET", "k", "theirs")); err != nil {
+				return
+			}
+			if _, err := wr.ReadString('\n'); err != nil {
+				return
+			}
+		}
+	}()
+	defer wg.Wait()
+	defer close(done)
+	<-started
+
+	script := "redis.call('set', KEYS[1], 'mine') " +
+		"for i = 1, 20000 do redis.call('get', KEYS[1]) end " +
+		"return redis.call('get', KEYS[1])"
+	if _, err := io.WriteString(conn, respCommand("EVAL", script, "1", "k")); err != nil {
+		t.Fatal(err)
+	}
+	expectLines(t, r, "$4", "mine")
+	req := respCommand("MULTI") + respCommand("EVAL", script, "1", "k") + respCommand("EXEC")
+	if _, err := io.WriteString(conn, req); err != nil {
+		t.Fatal(err)
+	}
+	expectLines(t, r, "+BEGIN", "+QUEUED", "*1", "$4", "mine")
+}
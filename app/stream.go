@@ -18,23 +18,43 @@ type Stream struct {
 	Entries []StreamEntry
 	LastID  string
 	Waiters []*StreamWaiter // For blocking reads
+	Groups  map[string]*ConsumerGroup
 }
 type StreamEntry struct {
 	ID     string
 	Fields map[string]string
 }
 
-func generateStreamID(userID string) string {
-	now := time.Now().UnixMilli()
+// generateStreamID fills in the parts of userID left to the server. Like
+// Redis, a generated ID is never below lastID: within the same millisecond
+// the sequence number counts up instead.
+func generateStreamID(userID, lastID string) string {
+	var lastMs, lastSeq int64 = 0, -1
+	if lastID != "" {
+		ms, seq, _ := strings.Cut(lastID, "-")
+		lastMs, _ = strconv.ParseInt(ms, 10, 64)
+		lastSeq, _ = strconv.ParseInt(seq, 10, 64)
+	}
 
 	if userID == "" || userID == "*" {
-		// Auto-generate ID: timestamp-0
+		// Auto-generate ID: timestamp-sequence
+		now := time.Now().UnixMilli()
+		if now <= lastMs {
+			return fmt.Sprintf("%d-%d", lastMs, lastSeq+1)
+		}
 		return fmt.Sprintf("%d-0", now)
 	}
 
 	// If user provides partial ID like "1234567890-*"
 	if strings.HasSuffix(userID, "-*") {
 		timestamp := strings.TrimSuffix(userID, "-*")
+		ms, _ := strconv.ParseInt(timestamp, 10, 64)
+		if lastID != "" && ms == lastMs {
+			return fmt.Sprintf("%s-%d", timestamp, lastSeq+1)
+		}
+		if ms == 0 {
+			return "0-1" // 0-0 is never a valid entry ID
+		}
 		return timestamp + "-0"
 	}
 
@@ -69,32 +89,50 @@ func compareStreamIDs(id1, id2 string) int {
 	return 0
 }
 
-func handleXAddCommand(cmd Command) RespData {
-	if len(cmd.args) < 3 || len(cmd.args)%2 != 0 {
+func handleXAddCommand(cmd Command, db *DataBase) RespData {
+	if len(cmd.args) < 3 {
 		return RespData{Type: Error, Str: "ERR wrong number of arguments for 'xadd' command"}
 	}
 
 	key := cmd.args[0]
-	id := cmd.args[1]
+	argIndex := 1
+
+	// Handle NOMKSTREAM option
+	noMkStream := false
+	if strings.ToLower(cmd.args[argIndex]) == "nomkstream" {
+		noMkStream = true
+		argIndex++
+	}
+
+	if argIndex >= len(cmd.args) {
+		return RespData{Type: Error, Str: "ERR wrong number of arguments for 'xadd' command"}
+	}
+	id := cmd.args[argIndex]
+	argIndex++
 
 	// Parse field-value pairs
+	fieldArgs := cmd.args[argIndex:]
+	if len(fieldArgs) == 0 || len(fieldArgs)%2 != 0 {
+		return RespData{Type: Error, Str: "ERR wrong number of arguments for 'xadd' command"}
+	}
 	fields := make(map[string]string)
-	for i := 2; i < len(cmd.args); i += 2 {
-		if i+1 >= len(cmd.args) {
-			return RespData{Type: Error, Str: "ERR wrong number of arguments for 'xadd' command"}
-		}
-		fields[cmd.args[i]] = cmd.args[i+1]
+	for i := 0; i < len(fieldArgs); i += 2 {
+		fields[fieldArgs[i]] = fieldArgs[i+1]
 	}
 
-	generatedID, err := db.XAdd(key, id, fields)
+	generatedID, err := db.XAdd(key, id, fields, noMkStream)
 	if err != nil {
 		return RespData{Type: Error, Str: err.Error()}
 	}
+	if generatedID == "" {
+		// NOMKSTREAM on a missing key
+		return RespData{Type: BulkString, IsNull: true}
+	}
 
 	return RespData{Type: BulkString, Str: generatedID}
 }
 
-func handleXLenCommand(cmd Command) RespData {
+func handleXLenCommand(cmd Command, db *DataBase) RespData {
 	if len(cmd.args) != 1 {
 		return RespData{Type: Error, Str: "ERR wrong number of arguments for 'xlen' command"}
 	}
@@ -103,7 +141,7 @@ func handleXLenCommand(cmd Command) RespData {
 	return RespData{Type: Integer, Num: length}
 }
 
-func handleXRangeCommand(cmd Command) RespData {
+func handleXRangeCommand(cmd Command, db *DataBase) RespData {
 	if len(cmd.args) < 3 {
 		return RespData{Type: Error, Str: "ERR wrong number of arguments for 'xrange' command"}
 	}
@@ -147,7 +185,8 @@ func handleXRangeCommand(cmd Command) RespData {
 	return RespData{Type: Array, Array: respArray}
 }
 
-func handleXReadCommand(cmd Command) RespData {
+func handleXReadCommand(cmd Command, clientConn *ClientConn) RespData {
+	db := clientConn.db
 	if len(cmd.args) < 3 {
 		return RespData{Type: Error, Str: "ERR wrong number of arguments for 'xread' command"}
 	}
@@ -202,7 +241,9 @@ func handleXReadCommand(cmd Command) RespData {
 	var err error
 
 	if blockMs >= 0 {
-		result, err = db.XReadBlocking(keys, ids, count, blockMs)
+		closed, stop := clientConn.watchClose()
+		result, err = db.XReadBlocking(keys, ids, count, blockMs, closed)
+		stop()
 	} else {
 		result = db.XRead(keys, ids, count)
 	}
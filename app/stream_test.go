@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestXAddGeneratesIncreasingIDs(t *testing.T) {
+	c := newTestClient(t)
+	last := ""
+	// Many of these land in the same millisecond
+	for range 100 {
+		got := run(c, "XADD", "s", "*", "f", "v")
+		if got.Type != BulkString {
+			t.Fatalf("XADD *: got %+v", got)
+		}
+		if last != "" && compareStreamIDs(got.Str, last) <= 0 {
+			t.Fatalf("XADD *: %s is not above %s", got.Str, last)
+		}
+		last = got.Str
+	}
+	expectReply(t, c, intReply(100), "XLEN", "s")
+
+	ms, _, _ := strings.Cut(last, "-")
+	expectReply(t, c, bulkReply("0-1"), "XADD", "t", "0-*", "f", "v")
+	expectReply(t, c, bulkReply("5-0"), "XADD", "t", "5-*", "f", "v")
+	expectReply(t, c, bulkReply("5-1"), "XADD", "t", "5-*", "f", "v")
+	expectReply(t, c, errorReply("ERR The ID specified in XADD is equal or smaller than the target stream top item"),
+		"XADD", "t", "4-*", "f", "v")
+	got := run(c, "XADD", "s", ms+"-*", "f", "v")
+	if compareStreamIDs(got.Str, last) <= 0 {
+		t.Errorf("XADD %s-*: got %+v, want an ID above %s", ms, got, last)
+	}
+}
+
+func TestXAddNoMkStream(t *testing.T) {
+	c := newTestClient(t)
+	expectReply(t, c, nullReply(), "XADD", "s", "NOMKSTREAM", "*", "f", "v")
+	expectReply(t, c, intReply(0), "EXISTS", "s")
+
+	expectReply(t, c, bulkReply("1-1"), "XADD", "s", "1-1", "f", "v")
+	expectReply(t, c, bulkReply("1-2"), "XADD", "s", "nomkstream", "1-2", "f", "v")
+	expectReply(t, c, intReply(2), "XLEN", "s")
+}
+
+func TestXAddFieldArity(t *testing.T) {
+	c := newTestClient(t)
+	wrongArgs := errorReply("ERR wrong number of arguments for 'xadd' command")
+	expectReply(t, c, wrongArgs, "XADD", "s", "*")
+	expectReply(t, c, wrongArgs, "XADD", "s", "*", "f")
+	expectReply(t, c, wrongArgs, "XADD", "s", "*", "f", "v", "g")
+	expectReply(t, c, wrongArgs, "XADD", "s", "NOMKSTREAM", "*")
+	expectReply(t, c, wrongArgs, "XADD", "s", "NOMKSTREAM", "*", "f")
+	// Nothing was created by the rejected calls
+	expectReply(t, c, intReply(0), "EXISTS", "s")
+
+	expectReply(t, c, bulkReply("1-1"), "XADD", "s", "1-1", "f", "v", "g", "w")
+	got := run(c, "XRANGE", "s", "-", "+")
+	if len(got.Array) != 1 || len(got.Array[0].Array[1].Array) != 4 {
+		t.Errorf("XRANGE: got %+v, want one entry with two pairs", got)
+	}
+}
@@ -0,0 +1,711 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ConsumerGroup tracks delivery of a stream's entries to a set of consumers.
+// Groups live in memory only and are not written to the RDB file.
+type ConsumerGroup struct {
+	Name            string
+	LastDeliveredID string
+	Consumers       map[string]*Consumer
+	Pending         map[string]*PendingEntry // entry id -> delivery
+}
+
+type Consumer struct {
+	Name     string
+	SeenTime time.Time
+}
+
+// PendingEntry is an entry delivered to a consumer but not yet acknowledged.
+type PendingEntry struct {
+	ID            string
+	Consumer      string
+	DeliveryTime  time.Time
+	DeliveryCount int
+}
+
+func newConsumerGroup(name, lastID string) *ConsumerGroup {
+	return &ConsumerGroup{
+		Name:            name,
+		LastDeliveredID: lastID,
+		Consumers:       make(map[string]*Consumer),
+		Pending:         make(map[string]*PendingEntry),
+	}
+}
+
+// clone returns a deep copy of g.
+func (g *ConsumerGroup) clone() *ConsumerGroup {
+	c := newConsumerGroup(g.Name, g.LastDeliveredID)
+	for name, consumer := range g.Consumers {
+		copied := *consumer
+		c.Consumers[name] = &copied
+	}
+	for id, pe := range g.Pending {
+		copied := *pe
+		c.Pending[id] = &copied
+	}
+	return c
+}
+
+// consumer returns the named consumer, creating it if needed. created
+// reports whether it was new.
+func (g *ConsumerGroup) consumer(name string) (c *Consumer, created bool) {
+	if c, ok := g.Consumers[name]; ok {
+		return c, false
+	}
+	c = &Consumer{Name: name, SeenTime: time.Now()}
+	g.Consumers[name] = c
+	return c, true
+}
+
+// pendingFor returns the ids pending for a consumer in stream order.
+func (g *ConsumerGroup) pendingFor(consumer string) []string {
+	var ids []string
+	for id, pe := range g.Pending {
+		if pe.Consumer == consumer {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return compareStreamIDs(ids[i], ids[j]) < 0 })
+	return ids
+}
+
+var errNoGroupKey = errors.New("ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+
+func noGroupError(key, group string) error {
+	return fmt.Errorf("NOGROUP No such key '%s' or consumer group '%s'", key, group)
+}
+
+// normalizeStreamID accepts "ms-seq" or "ms" and returns the "ms-seq" form.
+func normalizeStreamID(id string) (string, bool) {
+	ms, seq, found := strings.Cut(id, "-")
+	if _, err := strconv.ParseUint(ms, 10, 64); err != nil {
+		return "", false
+	}
+	if !found {
+		return ms + "-0", true
+	}
+	if _, err := strconv.ParseUint(seq, 10, 64); err != nil {
+		return "", false
+	}
+	return id, true
+}
+
+// streamLocked returns the stream at key. The caller must hold db.mu.
+func (db *DataBase) streamLocked(key string) (*Stream, bool, error) {
+	entry, exists := db.M[key]
+	if !exists || entry.isExpired(time.Now().UnixMilli()) {
+		return nil, false, nil
+	}
+	if !entry.IsStream() {
+		return nil, false, ErrWrongType
+	}
+	return entry.stream, true, nil
+}
+
+// groupLocked returns a consumer group, or a NOGROUP error if the stream
+// or group doesn't exist. The caller must hold db.mu.
+func (db *DataBase) groupLocked(key, group string) (*ConsumerGroup, error) {
+	stream, ok, err := db.streamLocked(key)
+	if err != nil {
+		return nil, err
+	}
+	if !ok || stream.Groups[group] == nil {
+		return nil, noGroupError(key, group)
+	}
+	return stream.Groups[group], nil
+}
+
+// XGroupCreate creates a consumer group starting after id ("$" for the
+// current end of the stream). With mkStream a missing key becomes an empty
+// stream.
+func (db *DataBase) XGroupCreate(key, group, id string, mkStream bool) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	stream, ok, err := db.streamLocked(key)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		if !mkStream {
+			return errNoGroupKey
+		}
+		stream = &Stream{Entries: []StreamEntry{}, Waiters: []*StreamWaiter{}}
+		db.putLocked(key, DBentry{
+			dataType:  StreamType,
+			stream:    stream,
+			timestamp: time.Now().UnixMilli(),
+			ttlMs:     -1,
+		})
+	}
+	if stream.Groups[group] != nil {
+		return errors.New("BUSYGROUP Consumer Group name already exists")
+	}
+
+	lastID, err := resolveGroupID(stream, id)
+	if err != nil {
+		return err
+	}
+	if stream.Groups == nil {
+		stream.Groups = make(map[string]*ConsumerGroup)
+	}
+	stream.Groups[group] = newConsumerGroup(group, lastID)
+	db.dirty.Add(1)
+	return nil
+}
+
+// resolveGroupID turns the id argument of XGROUP CREATE/SETID into an id.
+func resolveGroupID(stream *Stream, id string) (string, error) {
+	if id == "$" {
+		if stream.LastID == "" {
+			return "0-0", nil
+		}
+		return stream.LastID, nil
+	}
+	normalized, ok := normalizeStreamID(id)
+	if !ok {
+		return "", errors.New("ERR Invalid stream ID specified as stream command argument")
+	}
+	return normalized, nil
+}
+
+// XGroupSetID moves the group's last delivered id, so later reads with ">"
+// start after it.
+func (db *DataBase) XGroupSetID(key, group, id string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	stream, ok, err := db.streamLocked(key)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errNoGroupKey
+	}
+	g := stream.Groups[group]
+	if g == nil {
+		return noGroupError(key, group)
+	}
+	lastID, err := resolveGroupID(stream, id)
+	if err != nil {
+		return err
+	}
+	g.LastDeliveredID = lastID
+	db.dirty.Add(1)
+	return nil
+}
+
+// XGroupDestroy removes a group and reports whether it existed.
+func (db *DataBase) XGroupDestroy(key, group string) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	stream, ok, err := db.streamLocked(key)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		return false, errNoGroupKey
+	}
+	if stream.Groups[group] == nil {
+		return false, nil
+	}
+	delete(stream.Groups, group)
+	db.dirty.Add(1)
+	return true, nil
+}
+
+// XGroupCreateConsumer adds a consumer and reports whether it was new.
+func (db *DataBase) XGroupCreateConsumer(key, group, consumer string) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	g, err := db.groupLocked(key, group)
+	if err != nil {
+		return false, err
+	}
+	_, created := g.consumer(consumer)
+	if created {
+		db.dirty.Add(1)
+	}
+	return created, nil
+}
+
+// XGroupDelConsumer removes a consumer along with its pending entries and
+// returns how many were pending.
+func (db *DataBase) XGroupDelConsumer(key, group, consumer string) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	g, err := db.groupLocked(key, group)
+	if err != nil {
+		return 0, err
+	}
+	if g.Consumers[consumer] == nil {
+		return 0, nil
+	}
+	pending := g.pendingFor(consumer)
+	for _, id := range pending {
+		delete(g.Pending, id)
+	}
+	delete(g.Consumers, consumer)
+	db.dirty.Add(1)
+	return len(pending), nil
+}
+
+// XReadGroup reads for a consumer. An id of ">" delivers entries past the
+// group's last delivered id and adds them to the pending list (unless
+// noAck); any other id returns the consumer's pending entries after it.
+func (db *DataBase) XReadGroup(group, consumer string, keys, ids []string, count int, noAck bool) (map[string][]StreamEntry, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	groups := make([]*ConsumerGroup, len(keys))
+	for i, key := range keys {
+		g, err := db.groupLocked(key, group)
+		if err != nil {
+			return nil, err
+		}
+		groups[i] = g
+	}
+
+	now := time.Now()
+	result := make(map[string][]StreamEntry)
+	for i, key := range keys {
+		g := groups[i]
+		stream := db.M[key].stream
+		c, _ := g.consumer(consumer)
+		c.SeenTime = now
+
+		var entries []StreamEntry
+		if ids[i] == ">" {
+			for _, se := range stream.Entries {
+				if compareStreamIDs(se.ID, g.LastDeliveredID) <= 0 {
+					continue
+				}
+				entries = append(entries, se)
+				g.LastDeliveredID = se.ID
+				if !noAck {
+					g.Pending[se.ID] = &PendingEntry{ID: se.ID, Consumer: consumer, DeliveryTime: now, DeliveryCount: 1}
+				}
+				if count > 0 && len(entries) >= count {
+					break
+				}
+			}
+			if len(entries) > 0 {
+				result[key] = entries
+			}
+			continue
+		}
+
+		// History of the consumer's own pending entries; always replied to,
+		// even when empty
+		entries = []StreamEntry{}
+		for _, id := range g.pendingFor(consumer) {
+			if compareStreamIDs(id, ids[i]) <= 0 {
+				continue
+			}
+			for _, se := range stream.Entries {
+				if se.ID == id {
+					entries = append(entries, se)
+					break
+				}
+			}
+			if count > 0 && len(entries) >= count {
+				break
+			}
+		}
+		result[key] = entries
+	}
+	return result, nil
+}
+
+// XAck removes ids from the group's pending list and returns how many were
+// pending.
+func (db *DataBase) XAck(key, group string, ids []string) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	stream, ok, err := db.streamLocked(key)
+	if err != nil {
+		return 0, err
+	}
+	if !ok || stream.Groups[group] == nil {
+		return 0, nil
+	}
+	g := stream.Groups[group]
+
+	acked := 0
+	for _, id := range ids {
+		if _, ok := g.Pending[id]; ok {
+			delete(g.Pending, id)
+			acked++
+		}
+	}
+	db.dirty.Add(int64(acked))
+	return acked, nil
+}
+
+func handleXGroupCommand(cmd Command, db *DataBase) RespData {
+	if len(cmd.args) < 1 {
+		return RespData{Type: Error, Str: "ERR wrong number of arguments for 'xgroup' command"}
+	}
+
+	sub := strings.ToLower(cmd.args[0])
+	args := cmd.args[1:]
+	wrongArgs := RespData{Type: Error, Str: "ERR wrong number of arguments for 'xgroup|" + sub + "' command"}
+
+	switch sub {
+	case "create":
+		if len(args) != 3 && len(args) != 4 {
+			return wrongArgs
+		}
+		mkStream := false
+		if len(args) == 4 {
+			if strings.ToLower(args[3]) != "mkstream" {
+				return RespData{Type: Error, Str: "ERR syntax error"}
+			}
+			mkStream = true
+		}
+		if err := db.XGroupCreate(args[0], args[1], args[2], mkStream); err != nil {
+			return RespData{Type: Error, Str: err.Error()}
+		}
+		return RespData{Type: SimpleString, Str: "OK"}
+
+	case "setid":
+		if len(args) != 3 {
+			return wrongArgs
+		}
+		if err := db.XGroupSetID(args[0], args[1], args[2]); err != nil {
+			return RespData{Type: Error, Str: err.Error()}
+		}
+		return RespData{Type: SimpleString, Str: "OK"}
+
+	case "destroy":
+		if len(args) != 2 {
+			return wrongArgs
+		}
+		destroyed, err := db.XGroupDestroy(args[0], args[1])
+		if err != nil {
+			return RespData{Type: Error, Str: err.Error()}
+		}
+		if destroyed {
+			return RespData{Type: Integer, Num: 1}
+		}
+		return RespData{Type: Integer, Num: 0}
+
+	case "createconsumer":
+		if len(args) != 3 {
+			return wrongArgs
+		}
+		created, err := db.XGroupCreateConsumer(args[0], args[1], args[2])
+		if err != nil {
+			return RespData{Type: Error, Str: err.Error()}
+		}
+		if created {
+			return RespData{Type: Integer, Num: 1}
+		}
+		return RespData{Type: Integer, Num: 0}
+
+	case "delconsumer":
+		if len(args) != 3 {
+			return wrongArgs
+		}
+		pending, err := db.XGroupDelConsumer(args[0], args[1], args[2])
+		if err != nil {
+			return RespData{Type: Error, Str: err.Error()}
+		}
+		return RespData{Type: Integer, Num: int64(pending)}
+
+	default:
+		return RespData{Type: Error, Str: "ERR unknown subcommand '" + cmd.args[0] + "'. Try XGROUP HELP."}
+	}
+}
+
+func handleXReadGroupCommand(cmd Command, clientConn *ClientConn) RespData {
+	db := clientConn.db
+	if len(cmd.args) < 6 || strings.ToLower(cmd.args[0]) != "group" {
+		return RespData{Type: Error, Str: "ERR syntax error"}
+	}
+	group, consumer := cmd.args[1], cmd.args[2]
+
+	count := -1
+	var blockMs int64 = -1
+	noAck := false
+	argIndex := 3
+	for argIndex < len(cmd.args) && strings.ToLower(cmd.args[argIndex]) != "streams" {
+		switch strings.ToLower(cmd.args[argIndex]) {
+		case "count", "block":
+			if argIndex+1 >= len(cmd.args) {
+				return RespData{Type: Error, Str: "ERR syntax error"}
+			}
+			num, err := strconv.ParseInt(cmd.args[argIndex+1], 10, 64)
+			if err != nil {
+				return RespData{Type: Error, Str: "ERR value is not an integer or out of range"}
+			}
+			if strings.ToLower(cmd.args[argIndex]) == "count" {
+				count = int(num)
+			} else {
+				blockMs = num
+			}
+			argIndex += 2
+		case "noack":
+			noAck = true
+			argIndex++
+		default:
+			return RespData{Type: Error, Str: "ERR syntax error"}
+		}
+	}
+	if argIndex >= len(cmd.args) {
+		return RespData{Type: Error, Str: "ERR syntax error"}
+	}
+
+	remainingArgs := cmd.args[argIndex+1:]
+	if len(remainingArgs) == 0 || len(remainingArgs)%2 != 0 {
+		return RespData{Type: Error, Str: "ERR Unbalanced 'xreadgroup' list of streams: for each stream key an ID or '>' must be specified."}
+	}
+	streamCount := len(remainingArgs) / 2
+	keys := remainingArgs[:streamCount]
+	ids := make([]string, streamCount)
+	for i, id := range remainingArgs[streamCount:] {
+		if id == ">" {
+			ids[i] = id
+			continue
+		}
+		normalized, ok := normalizeStreamID(id)
+		if !ok {
+			return RespData{Type: Error, Str: "ERR Invalid stream ID specified as stream command argument"}
+		}
+		ids[i] = normalized
+	}
+
+	result, err := db.XReadGroup(group, consumer, keys, ids, count, noAck)
+	if err != nil {
+		return RespData{Type: Error, Str: err.Error()}
+	}
+
+	// Block for new entries only when every id asks for them
+	if len(result) == 0 && blockMs >= 0 && !clientConn.isTransaction && clientConn.conn != nil {
+		closed, stop := clientConn.watchClose()
+		db.XReadBlocking(keys, db.groupPositions(keys, group), 1, blockMs, closed)
+		stop()
+		if result, err = db.XReadGroup(group, consumer, keys, ids, count, noAck); err != nil {
+			return RespData{Type: Error, Str: err.Error()}
+		}
+	}
+
+	if len(result) == 0 {
+		return RespData{Type: Array, IsNull: true}
+	}
+	respArray := make([]RespData, 0, len(result))
+	for _, key := range keys {
+		entries, ok := result[key]
+		if !ok {
+			continue
+		}
+		respArray = append(respArray, RespData{
+			Type: Array,
+			Array: []RespData{
+				{Type: BulkString, Str: key},
+				streamEntriesReply(entries),
+			},
+		})
+	}
+	return RespData{Type: Array, Array: respArray}
+}
+
+// groupPositions returns each group's last delivered id, the position a
+// blocked XREADGROUP waits past.
+func (db *DataBase) groupPositions(keys []string, group string) []string {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	ids := make([]string, len(keys))
+	for i, key := range keys {
+		ids[i] = "0-0"
+		if g, err := db.groupLocked(key, group); err == nil {
+			ids[i] = g.LastDeliveredID
+		}
+	}
+	return ids
+}
+
+func handleXAckCommand(cmd Command, db *DataBase) RespData {
+	if len(cmd.args) < 3 {
+		return RespData{Type: Error, Str: "ERR wrong number of arguments for 'xack' command"}
+	}
+
+	ids := make([]string, len(cmd.args)-2)
+	for i, id := range cmd.args[2:] {
+		normalized, ok := normalizeStreamID(id)
+		if !ok {
+			return RespData{Type: Error, Str: "ERR Invalid stream ID specified as stream command argument"}
+		}
+		ids[i] = normalized
+	}
+	acked, err := db.XAck(cmd.args[0], cmd.args[1], ids)
+	if err != nil {
+		return RespData{Type: Error, Str: err.Error()}
+	}
+	return RespData{Type: Integer, Num: int64(acked)}
+}
+
+// streamEntriesReply formats entries as [[id, [field, value, ...]], ...].
+func streamEntriesReply(entries []StreamEntry) RespData {
+	entryArray := make([]RespData, len(entries))
+	for i, entry := range entries {
+		fieldArray := make([]RespData, 0, len(entry.Fields)*2)
+		for field, value := range entry.Fields {
+			fieldArray = append(fieldArray,
+				RespData{Type: BulkString, Str: field},
+				RespData{Type: BulkString, Str: value},
+			)
+		}
+		entryArray[i] = RespData{
+			Type: Array,
+			Array: []RespData{
+				{Type: BulkString, Str: entry.ID},
+				{Type: Array, Array: fieldArray},
+			},
+		}
+	}
+	return RespData{Type: Array, Array: entryArray}
+}
+
+// GroupInfo and ConsumerInfo are snapshots reported by XINFO.
+type GroupInfo struct {
+	Name            string
+	Consumers       int
+	Pending         int
+	LastDeliveredID string
+}
+
+type ConsumerInfo struct {
+	Name    string
+	Pending int
+	IdleMs  int64
+}
+
+// XInfoGroups describes the stream's groups in name order.
+func (db *DataBase) XInfoGroups(key string) ([]GroupInfo, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	stream, ok, err := db.streamLocked(key)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New("ERR no such key")
+	}
+
+	infos := make([]GroupInfo, 0, len(stream.Groups))
+	for _, g := range stream.Groups {
+		infos = append(infos, GroupInfo{
+			Name:            g.Name,
+			Consumers:       len(g.Consumers),
+			Pending:         len(g.Pending),
+			LastDeliveredID: g.LastDeliveredID,
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos, nil
+}
+
+// XInfoConsumers describes a group's consumers in name order.
+func (db *DataBase) XInfoConsumers(key, group string) ([]ConsumerInfo, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	g, err := db.groupLocked(key, group)
+	if err != nil {
+		return nil, err
+	}
+
+	pending := make(map[string]int)
+	for _, pe := range g.Pending {
+		pending[pe.Consumer]++
+	}
+	now := time.Now()
+	infos := make([]ConsumerInfo, 0, len(g.Consumers))
+	for _, c := range g.Consumers {
+		infos = append(infos, ConsumerInfo{
+			Name:    c.Name,
+			Pending: pending[c.Name],
+			IdleMs:  now.Sub(c.SeenTime).Milliseconds(),
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos, nil
+}
+
+func handleXInfoCommand(cmd Command, db *DataBase) RespData {
+	if len(cmd.args) < 1 {
+		return RespData{Type: Error, Str: "ERR wrong number of arguments for 'xinfo' command"}
+	}
+
+	sub := strings.ToLower(cmd.args[0])
+	args := cmd.args[1:]
+	switch sub {
+	case "groups":
+		if len(args) != 1 {
+			return RespData{Type: Error, Str: "ERR wrong number of arguments for 'xinfo|groups' command"}
+		}
+		infos, err := db.XInfoGroups(args[0])
+		if err != nil {
+			return RespData{Type: Error, Str: err.Error()}
+		}
+		groups := make([]RespData, len(infos))
+		for i, info := range infos {
+			groups[i] = RespData{
+				Type: Array,
+				Array: []RespData{
+					{Type: BulkString, Str: "name"},
+					{Type: BulkString, Str: info.Name},
+					{Type: BulkString, Str: "consumers"},
+					{Type: Integer, Num: int64(info.Consumers)},
+					{Type: BulkString, Str: "pending"},
+					{Type: Integer, Num: int64(info.Pending)},
+					{Type: BulkString, Str: "last-delivered-id"},
+					{Type: BulkString, Str: info.LastDeliveredID},
+				},
+			}
+		}
+		return RespData{Type: Array, Array: groups}
+
+	case "consumers":
+		if len(args) != 2 {
+			return RespData{Type: Error, Str: "ERR wrong number of arguments for 'xinfo|consumers' command"}
+		}
+		infos, err := db.XInfoConsumers(args[0], args[1])
+		if err != nil {
+			return RespData{Type: Error, Str: err.Error()}
+		}
+		consumers := make([]RespData, len(infos))
+		for i, info := range infos {
+			consumers[i] = RespData{
+				Type: Array,
+				Array: []RespData{
+					{Type: BulkString, Str: "name"},
+					{Type: BulkString, Str: info.Name},
+					{Type: BulkString, Str: "pending"},
+					{Type: Integer, Num: int64(info.Pending)},
+					{Type: BulkString, Str: "idle"},
+					{Type: Integer, Num: info.IdleMs},
+				},
+			}
+		}
+		return RespData{Type: Array, Array: consumers}
+
+	default:
+		return RespData{Type: Error, Str: "ERR unknown subcommand '" + cmd.args[0] + "'. Try XINFO HELP."}
+	}
+}
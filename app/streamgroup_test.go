@@ -0,0 +1,125 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+// readGroupIDs runs XREADGROUP for new entries of key and returns the IDs
+// delivered.
+func readGroupIDs(t *testing.T, c *ClientConn, group, consumer, key string) []string {
+	t.Helper()
+	reply := run(c, "XREADGROUP", "GROUP", group, consumer, "STREAMS", key, ">")
+	if reply.Type == Error {
+		t.Fatalf("XREADGROUP: %s", reply.Str)
+	}
+	var ids []string
+	for _, stream := range reply.Array {
+		for _, entry := range stream.Array[1].Array {
+			ids = append(ids, entry.Array[0].Str)
+		}
+	}
+	return ids
+}
+
+func TestXGroupManagement(t *testing.T) {
+	c := newTestClient(t)
+	run(c, "XADD", "s", "1-1", "f", "v")
+	run(c, "XADD", "s", "1-2", "f", "v")
+	expectReply(t, c, okReply(), "XGROUP", "CREATE", "s", "g", "0")
+	expectReply(t, c, errorReply("BUSYGROUP Consumer Group name already exists"), "XGROUP", "CREATE", "s", "g", "0")
+
+	expectReply(t, c, intReply(1), "XGROUP", "CREATECONSUMER", "s", "g", "alice")
+	expectReply(t, c, intReply(0), "XGROUP", "CREATECONSUMER", "s", "g", "alice")
+
+	if got := readGroupIDs(t, c, "g", "alice", "s"); !slices.Equal(got, []string{"1-1", "1-2"}) {
+		t.Errorf("first read: got %v", got)
+	}
+	if got := readGroupIDs(t, c, "g", "alice", "s"); len(got) != 0 {
+		t.Errorf("second read: got %v, want nothing new", got)
+	}
+
+	// SETID rewinds delivery so the group reads from the start again
+	expectReply(t, c, okReply(), "XGROUP", "SETID", "s", "g", "0")
+	if got := readGroupIDs(t, c, "g", "bob", "s"); !slices.Equal(got, []string{"1-1", "1-2"}) {
+		t.Errorf("read after SETID: got %v", got)
+	}
+	expectReply(t, c, okReply(), "XGROUP", "SETID", "s", "g", "$")
+	if got := readGroupIDs(t, c, "g", "bob", "s"); len(got) != 0 {
+		t.Errorf("read after SETID $: got %v", got)
+	}
+
+	// DELCONSUMER returns the pending entries it drops. Redelivery moved
+	// alice's entries to bob.
+	expectReply(t, c, intReply(0), "XGROUP", "DELCONSUMER", "s", "g", "alice")
+	expectReply(t, c, intReply(2), "XGROUP", "DELCONSUMER", "s", "g", "bob")
+
+	expectReply(t, c, intReply(1), "XGROUP", "DESTROY", "s", "g")
+	expectReply(t, c, intReply(0), "XGROUP", "DESTROY", "s", "g")
+	got := run(c, "XREADGROUP", "GROUP", "g", "bob", "STREAMS", "s", ">")
+	if got.Type != Error {
+		t.Errorf("XREADGROUP on a destroyed group: got %+v", got)
+	}
+}
+
+// infoEntries turns an XINFO reply of flat name/value arrays into maps, with
+// integers formatted as decimal strings.
+func infoEntries(reply RespData) []map[string]string {
+	var out []map[string]string
+	for _, item := range reply.Array {
+		m := make(map[string]string)
+		for i := 0; i+1 < len(item.Array); i += 2 {
+			v := item.Array[i+1]
+			if v.Type == Integer {
+				m[item.Array[i].Str] = strconv.FormatInt(v.Num, 10)
+			} else {
+				m[item.Array[i].Str] = v.Str
+			}
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+func TestXInfoGroupsAndConsumers(t *testing.T) {
+	c := newTestClient(t)
+	for _, id := range []string{"1-1", "1-2", "1-3"} {
+		run(c, "XADD", "s", id, "f", "v")
+	}
+	run(c, "XGROUP", "CREATE", "s", "g", "0")
+	run(c, "XGROUP", "CREATE", "s", "idle", "$")
+	run(c, "XREADGROUP", "GROUP", "g", "alice", "COUNT", "2", "STREAMS", "s", ">")
+	run(c, "XREADGROUP", "GROUP", "g", "bob", "STREAMS", "s", ">")
+	expectReply(t, c, intReply(1), "XACK", "s", "g", "1-1")
+
+	groups := infoEntries(run(c, "XINFO", "GROUPS", "s"))
+	if len(groups) != 2 {
+		t.Fatalf("XINFO GROUPS: got %v", groups)
+	}
+	want := map[string]string{"name": "g", "consumers": "2", "pending": "2", "last-delivered-id": "1-3"}
+	if !maps.Equal(groups[0], want) {
+		t.Errorf("group g: got %v, want %v", groups[0], want)
+	}
+	want = map[string]string{"name": "idle", "consumers": "0", "pending": "0", "last-delivered-id": "1-3"}
+	if !maps.Equal(groups[1], want) {
+		t.Errorf("group idle: got %v, want %v", groups[1], want)
+	}
+
+	consumers := infoEntries(run(c, "XINFO", "CONSUMERS", "s", "g"))
+	if len(consumers) != 2 {
+		t.Fatalf("XINFO CONSUMERS: got %v", consumers)
+	}
+	for i, name := range []string{"alice", "bob"} {
+		if consumers[i]["name"] != name || consumers[i]["pending"] != "1" {
+			t.Errorf("consumer %d: got %v, want %s with 1 pending", i, consumers[i], name)
+		}
+		if idle, err := strconv.Atoi(consumers[i]["idle"]); err != nil || idle < 0 {
+			t.Errorf("consumer %s: idle %q", name, consumers[i]["idle"])
+		}
+	}
+
+	expectReply(t, c, errorReply("ERR no such key"), "XINFO", "GROUPS", "missing")
+	expectReply(t, c, errorReply("NOGROUP No such key 's' or consumer group 'nope'"), "XINFO", "CONSUMERS", "s", "nope")
+}
@@ -16,10 +16,20 @@ func handleExecCommand(clientConn *ClientConn) RespData {
 	if !clientConn.isTransaction {
 		return RespData{Type: Error, Str: "ERR EXEC without MULTI"}
 	}
+	if clientConn.queueError {
+		clientConn.isTransaction = false
+		clientConn.transactionQueue = nil
+		clientConn.queueError = false
+		return RespData{Type: Error, Str: "EXECABORT Transaction discarded because of previous errors."}
+	}
+	server.holdListServes()
+	defer server.releaseListServes()
 	var results []RespData
 	for _, queuedCmd := range clientConn.transactionQueue {
 		// Temporarily disable transaction mode to execute commands
+		dbIndex := clientConn.db.id
 		result := executeCommand(queuedCmd, clientConn, true)
+		propagateWrite(dbIndex, queuedCmd, result)
 		results = append(results, result)
 	}
 
@@ -37,5 +47,6 @@ func handleDiscardCommand(clientConn *ClientConn) RespData {
 
 	clientConn.isTransaction = false
 	clientConn.transactionQueue = nil
+	clientConn.queueError = false
 	return RespData{Type: SimpleString, Str: "OK"}
 }
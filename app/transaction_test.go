@@ -0,0 +1,89 @@
+package main
+
+import "testing"
+
+func TestExecAbortAfterQueueError(t *testing.T) {
+	c := newTestClient(t)
+	run(c, "MULTI")
+	expectReply(t, c, RespData{Type: SimpleString, Str: "QUEUED"}, "SET", "k", "v")
+	expectReply(t, c, errorReply("ERR unknown command 'NOPE'"), "NOPE")
+	expectReply(t, c, errorReply("EXECABORT Transaction discarded because of previous errors."), "EXEC")
+	// Nothing ran, and the connection is out of the transaction
+	expectReply(t, c, nullReply(), "GET", "k")
+	expectReply(t, c, errorReply("ERR EXEC without MULTI"), "EXEC")
+}
+
+func TestDiscardClearsQueueError(t *testing.T) {
+	c := newTestClient(t)
+	run(c, "MULTI")
+	expectReply(t, c, errorReply("ERR wrong number of arguments for 'set' command"), "SET", "k")
+	expectReply(t, c, okReply(), "DISCARD")
+
+	run(c, "MULTI")
+	expectReply(t, c, RespData{Type: SimpleString, Str: "QUEUED"}, "SET", "k", "v")
+	expectReply(t, c, RespData{Type: SimpleString, Str: "QUEUED"}, "GET", "k")
+	got := run(c, "EXEC")
+	if got.Type != Array || len(got.Array) != 2 || got.Array[0].Str != "OK" || got.Array[1].Str != "v" {
+		t.Errorf("EXEC after DISCARD: got %+v", got)
+	}
+}
+
+func TestExecKeepsRuntimeErrors(t *testing.T) {
+	c := newTestClient(t)
+	run(c, "RPUSH", "list", "a")
+	run(c, "MULTI")
+	run(c, "INCR", "list")
+	run(c, "SET", "k", "v")
+	got := run(c, "EXEC")
+	if got.Type != Array || len(got.Array) != 2 || got.Array[0].Type != Error || got.Array[1].Str != "OK" {
+		t.Errorf("EXEC with a WRONGTYPE command: got %+v", got)
+	}
+	expectReply(t, c, bulkReply("v"), "GET", "k")
+}
+
+func TestMaxQueuedCommands(t *testing.T) {
+	c := newTestClient(t)
+	expectReply(t, c, okReply(), "CONFIG", "SET", "max-queued-commands", "2")
+	run(c, "MULTI")
+	run(c, "SET", "a", "1")
+	run(c, "SET", "b", "2")
+	expectReply(t, c, errorReply("ERR Too many commands queued in transaction"), "SET", "c", "3")
+	expectReply(t, c, errorReply("EXECABORT Transaction discarded because of previous errors."), "EXEC")
+	expectReply(t, c, nullReply(), "GET", "a")
+
+	// The limit is per transaction, and 0 lifts it
+	run(c, "MULTI")
+	run(c, "SET", "a", "1")
+	run(c, "SET", "b", "2")
+	if got := run(c, "EXEC"); len(got.Array) != 2 {
+		t.Errorf("EXEC at the limit: got %+v", got)
+	}
+	expectReply(t, c, okReply(), "CONFIG", "SET", "max-queued-commands", "0")
+	run(c, "MULTI")
+	for range 10 {
+		run(c, "INCR", "n")
+	}
+	if got := run(c, "EXEC"); len(got.Array) != 10 {
+		t.Errorf("EXEC without a limit: got %d replies", len(got.Array))
+	}
+	expectReply(t, c, errorReply("ERR Invalid argument '-1' for CONFIG SET 'max-queued-commands'"),
+		"CONFIG", "SET", "max-queued-commands", "-1")
+}
+
+func TestSubscribeAndMulti(t *testing.T) {
+	c := newTestClient(t)
+	run(c, "MULTI")
+	expectReply(t, c, errorReply("ERR SUBSCRIBE is not allowed in transactions"), "SUBSCRIBE", "ch")
+	expectReply(t, c, errorReply("ERR UNSUBSCRIBE is not allowed in transactions"), "UNSUBSCRIBE")
+	expectReply(t, c, errorReply("EXECABORT Transaction discarded because of previous errors."), "EXEC")
+	if len(c.subscriptions) != 0 {
+		t.Errorf("subscribed to %v inside MULTI", c.subscriptions)
+	}
+
+	run(c, "SUBSCRIBE", "ch")
+	expectReply(t, c, errorReply("ERR Can't execute 'multi': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context"), "MULTI")
+	expectReply(t, c, errorReply("ERR Can't execute 'exec': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context"), "EXEC")
+	if c.isTransaction {
+		t.Error("MULTI while subscribed started a transaction")
+	}
+}